@@ -0,0 +1,91 @@
+package client
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/webstradev/blockstra/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// ErrClosed is returned by a closed pool
+var ErrClosed = errors.New("client pool is closed")
+
+// Pool keeps one connection per node address, so tools talking to nodes
+// repeatedly reuse their connections instead of dialing every time. The
+// connections stay open until the pool is closed.
+type Pool struct {
+	opts []grpc.DialOption
+
+	lock   sync.Mutex
+	conns  map[string]*grpc.ClientConn
+	closed bool
+}
+
+// NewPool returns a pool dialing nodes with the options, without options
+// nodes are dialed without transport security
+func NewPool(opts ...grpc.DialOption) *Pool {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	return &Pool{
+		opts:  opts,
+		conns: map[string]*grpc.ClientConn{},
+	}
+}
+
+// Node returns a client of the node at the address
+func (p *Pool) Node(addr string) (proto.NodeClient, error) {
+	conn, err := p.Conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	return proto.NewNodeClient(conn), nil
+}
+
+// Admin returns a client of the admin RPCs at the address
+func (p *Pool) Admin(addr string) (proto.AdminClient, error) {
+	conn, err := p.Conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	return proto.NewAdminClient(conn), nil
+}
+
+// Conn returns the connection to the address, dialing it the first time
+func (p *Pool) Conn(addr string) (*grpc.ClientConn, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return nil, ErrClosed
+	}
+	if conn, ok := p.conns[addr]; ok {
+		return conn, nil
+	}
+
+	conn, err := grpc.Dial(addr, p.opts...)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[addr] = conn
+	return conn, nil
+}
+
+// Close closes the connections of the pool, clients of the pool fail after
+// that
+func (p *Pool) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.closed = true
+	var errs []error
+	for addr, conn := range p.conns {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(p.conns, addr)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type pingServer struct {
+	proto.UnimplementedNodeServer
+}
+
+func (pingServer) Ping(ctx context.Context, hb *proto.Heartbeat) (*proto.Heartbeat, error) {
+	return &proto.Heartbeat{Height: hb.Height + 1}, nil
+}
+
+func TestPool(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	proto.RegisterNodeServer(server, pingServer{})
+	go server.Serve(ln)
+	defer server.Stop()
+
+	var (
+		addr = ln.Addr().String()
+		pool = NewPool()
+	)
+
+	// Clients of the same address share the connection
+	a, err := pool.Conn(addr)
+	assert.NoError(t, err)
+	b, err := pool.Conn(addr)
+	assert.NoError(t, err)
+	assert.Same(t, a, b)
+
+	client, err := pool.Node(addr)
+	assert.NoError(t, err)
+	hb, err := client.Ping(context.Background(), &proto.Heartbeat{Height: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), hb.Height)
+
+	// Closing the pool closes the connections
+	assert.NoError(t, pool.Close())
+	_, err = client.Ping(context.Background(), &proto.Heartbeat{})
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	_, err = pool.Node(addr)
+	assert.ErrorIs(t, err, ErrClosed)
+}
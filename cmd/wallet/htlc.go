@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/webstradev/blockstra/client"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+)
+
+func htlcCreate(args []string) error {
+	var (
+		fs      = flag.NewFlagSet("htlc-create", flag.ExitOnError)
+		wf      = addWalletFlags(fs)
+		node    = fs.String("node", ":3000", "address of the node to submit the transaction to")
+		utxo    = fs.String("utxo", "", "output to fund the contract with, as <tx hash>:<index>")
+		to      = fs.String("to", "", "address of the recipient")
+		amount  = fs.Int64("amount", 0, "amount to lock in the contract")
+		timeout = fs.Int("timeout", 0, "height from which the funds can be refunded")
+		hash    = fs.String("hash", "", "hex SHA256 of the secret, a new secret is generated if empty")
+	)
+	fs.Parse(args)
+
+	key, err := wf.key()
+	if err != nil {
+		return err
+	}
+
+	recipient, err := crypto.ParseAddress(*to, wf.addressNetwork())
+	if err != nil {
+		return err
+	}
+
+	var preimage []byte
+	if *hash == "" {
+		preimage = make([]byte, sha256.Size)
+		if _, err := io.ReadFull(rand.Reader, preimage); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(preimage)
+		*hash = hex.EncodeToString(sum[:])
+	}
+
+	hashBytes, err := hex.DecodeString(*hash)
+	if err != nil {
+		return fmt.Errorf("invalid hash: %w", err)
+	}
+
+	htlc := &types.HTLC{
+		Hash:      hashBytes,
+		Recipient: recipient,
+		Refund:    key.Public().Address(),
+		Timeout:   int32(*timeout),
+	}
+
+	output, err := types.NewHTLCOutput(*amount, htlc)
+	if err != nil {
+		return err
+	}
+
+	return withNode(*node, func(c proto.NodeClient) error {
+		outPoint, err := parseOutPoint(*utxo)
+		if err != nil {
+			return err
+		}
+
+		funding, err := c.GetOutput(context.Background(), outPoint)
+		if err != nil {
+			return err
+		}
+
+		if funding.Amount < *amount {
+			return fmt.Errorf("output holds %d, can't lock %d", funding.Amount, *amount)
+		}
+
+		tx := &proto.Transaction{
+			Version: 1,
+			Inputs: []*proto.TxInput{{
+				PrevTxHash:   outPoint.TxHash,
+				PrevOutIndex: outPoint.Index,
+				PublicKey:    key.Public().Bytes(),
+			}},
+			Outputs: []*proto.TxOutput{output},
+		}
+
+		// Send what's left back to ourselves
+		if change := funding.Amount - *amount; change > 0 {
+			tx.Outputs = append(tx.Outputs, &proto.TxOutput{
+				Amount:  change,
+				Address: key.Public().Address().Bytes(),
+			})
+		}
+
+		sig, err := types.SignTransaction(key, tx)
+		if err != nil {
+			return err
+		}
+		tx.Inputs[0].Signature = sig.Bytes()
+
+		txHash, err := submit(c, tx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("htlc:     %s:0\n", txHash)
+		fmt.Printf("hash:     %s\n", *hash)
+		if preimage != nil {
+			fmt.Printf("preimage: %x (keep it secret until you claim the counterparty's htlc)\n", preimage)
+		}
+		return nil
+	})
+}
+
+func htlcClaim(args []string) error {
+	var (
+		fs       = flag.NewFlagSet("htlc-claim", flag.ExitOnError)
+		wf       = addWalletFlags(fs)
+		node     = fs.String("node", ":3000", "address of the node to submit the transaction to")
+		utxo     = fs.String("utxo", "", "htlc output to claim, as <tx hash>:<index>")
+		preimage = fs.String("preimage", "", "hex secret whose SHA256 unlocks the htlc")
+		to       = fs.String("to", "", "address to send the funds to, defaults to the wallet's address")
+	)
+	fs.Parse(args)
+
+	secret, err := hex.DecodeString(*preimage)
+	if err != nil {
+		return fmt.Errorf("invalid preimage: %w", err)
+	}
+
+	return spendHTLC(wf, *node, *utxo, *to, func(key *crypto.PrivateKey, htlc *types.HTLC, tx *proto.Transaction) error {
+		if hash := sha256.Sum256(secret); !bytes.Equal(hash[:], htlc.Hash) {
+			return fmt.Errorf("preimage does not match the htlc hash %x", htlc.Hash)
+		}
+
+		// The script only accepts the key's address of the current version
+		if !bytes.Equal(key.Public().Address().Bytes(), htlc.Recipient.Bytes()) {
+			return fmt.Errorf("htlc can only be claimed by %s", htlc.Recipient.Encode(wf.addressNetwork()))
+		}
+
+		return types.SignHTLCClaim(key, tx, tx.Inputs[0], secret)
+	})
+}
+
+func htlcRefund(args []string) error {
+	var (
+		fs   = flag.NewFlagSet("htlc-refund", flag.ExitOnError)
+		wf   = addWalletFlags(fs)
+		node = fs.String("node", ":3000", "address of the node to submit the transaction to")
+		utxo = fs.String("utxo", "", "htlc output to refund, as <tx hash>:<index>")
+		to   = fs.String("to", "", "address to send the funds to, defaults to the wallet's address")
+	)
+	fs.Parse(args)
+
+	return spendHTLC(wf, *node, *utxo, *to, func(key *crypto.PrivateKey, htlc *types.HTLC, tx *proto.Transaction) error {
+		if !bytes.Equal(key.Public().Address().Bytes(), htlc.Refund.Bytes()) {
+			return fmt.Errorf("htlc can only be refunded to %s", htlc.Refund.Encode(wf.addressNetwork()))
+		}
+
+		return types.SignHTLCRefund(key, tx, tx.Inputs[0])
+	})
+}
+
+// spendHTLC builds a transaction moving the whole htlc output to an address,
+// has it signed by sign and submits it.
+func spendHTLC(wf *walletFlags, node, utxo, to string, sign func(*crypto.PrivateKey, *types.HTLC, *proto.Transaction) error) error {
+	key, err := wf.key()
+	if err != nil {
+		return err
+	}
+
+	dest := key.Public().Address()
+	if to != "" {
+		if dest, err = crypto.ParseAddress(to, wf.addressNetwork()); err != nil {
+			return err
+		}
+	}
+
+	outPoint, err := parseOutPoint(utxo)
+	if err != nil {
+		return err
+	}
+
+	return withNode(node, func(c proto.NodeClient) error {
+		output, err := c.GetOutput(context.Background(), outPoint)
+		if err != nil {
+			return err
+		}
+
+		htlc, err := types.ParseHTLC(output.LockingScript)
+		if err != nil {
+			return err
+		}
+
+		tx := &proto.Transaction{
+			Version: 1,
+			Inputs: []*proto.TxInput{{
+				PrevTxHash:   outPoint.TxHash,
+				PrevOutIndex: outPoint.Index,
+			}},
+			Outputs: []*proto.TxOutput{{
+				Amount:  output.Amount,
+				Address: dest.Bytes(),
+			}},
+		}
+
+		if err := sign(key, htlc, tx); err != nil {
+			return err
+		}
+
+		txHash, err := submit(c, tx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("tx: %s\n", txHash)
+		return nil
+	})
+}
+
+// withNode connects to a node for the duration of f
+func withNode(addr string, f func(proto.NodeClient) error) error {
+	pool := client.NewPool()
+	defer pool.Close()
+
+	c, err := pool.Node(addr)
+	if err != nil {
+		return err
+	}
+	return f(c)
+}
+
+func submit(c proto.NodeClient, tx *proto.Transaction) (string, error) {
+	if _, err := c.HandleTransaction(context.Background(), tx); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(types.MustHashTransaction(tx)), nil
+}
+
+// parseOutPoint parses an output reference of the form <tx hash>:<index>
+func parseOutPoint(s string) (*proto.OutPoint, error) {
+	hash, index, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid output %q, expected <tx hash>:<index>", s)
+	}
+
+	txHash, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", s, err)
+	}
+
+	i, err := strconv.ParseUint(index, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", s, err)
+	}
+
+	return &proto.OutPoint{TxHash: txHash, Index: uint32(i)}, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/wallet"
+)
+
+const mnemonicEnv = "BLOCKSTRA_MNEMONIC"
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "new":
+		fmt.Println(crypto.MustGenerateMnemonic())
+	case "address":
+		err = address(os.Args[2:])
+	case "validate":
+		err = validate(os.Args[2:])
+	case "htlc-create":
+		err = htlcCreate(os.Args[2:])
+	case "htlc-claim":
+		err = htlcClaim(os.Args[2:])
+	case "htlc-refund":
+		err = htlcRefund(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	log.Fatalf(`usage: wallet <command> [flags]
+
+commands:
+  new          generate a new mnemonic
+  address      derive an address from the mnemonic in $%s
+  validate     check that an address is valid for a network
+  htlc-create  lock funds in a hash time-locked contract
+  htlc-claim   claim an htlc output with the secret preimage
+  htlc-refund  refund an htlc output after its timeout`, mnemonicEnv)
+}
+
+// walletFlags are the flags of every command that uses the wallet
+type walletFlags struct {
+	network    *string
+	passphrase *string
+	account    *uint
+	index      *uint
+}
+
+func addWalletFlags(fs *flag.FlagSet) *walletFlags {
+	return &walletFlags{
+		network:    fs.String("network", string(crypto.MainNet), "network prefix of addresses"),
+		passphrase: fs.String("passphrase", "", "optional passphrase protecting the mnemonic"),
+		account:    fs.Uint("account", 0, "account to derive keys in"),
+		index:      fs.Uint("index", 0, "index of the key to use"),
+	}
+}
+
+// key derives the wallet from the mnemonic in the environment and returns
+// the key selected by the index flag.
+func (f *walletFlags) key() (*crypto.PrivateKey, error) {
+	w, err := wallet.New(os.Getenv(mnemonicEnv), *f.passphrase, uint32(*f.account))
+	if err != nil {
+		return nil, err
+	}
+	return w.Key(uint32(*f.index)), nil
+}
+
+func (f *walletFlags) addressNetwork() crypto.Network {
+	return crypto.Network(*f.network)
+}
+
+func address(args []string) error {
+	var (
+		fs = flag.NewFlagSet("address", flag.ExitOnError)
+		wf = addWalletFlags(fs)
+	)
+	fs.Parse(args)
+
+	key, err := wf.key()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(key.Public().Address().Encode(wf.addressNetwork()))
+	return nil
+}
+
+func validate(args []string) error {
+	var (
+		fs      = flag.NewFlagSet("validate", flag.ExitOnError)
+		network = fs.String("network", string(crypto.MainNet), "network prefix the address must have")
+	)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one address")
+	}
+
+	address, err := crypto.ParseAddress(fs.Arg(0), crypto.Network(*network))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(address)
+	return nil
+}
@@ -0,0 +1,60 @@
+package consensus
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/webstradev/blockstra/crypto"
+)
+
+// Kind selects the consensus engine of a chain
+type Kind string
+
+const (
+	KindProofOfAuthority Kind = "poa"
+	KindProofOfWork      Kind = "pow"
+)
+
+// Config holds the consensus rules every node of a chain has to agree on
+type Config struct {
+	// Kind defaults to KindProofOfAuthority
+	Kind Kind
+	// BlockTime is the targeted time between blocks
+	BlockTime time.Duration
+	// RetargetInterval is the number of blocks after which a proof of work
+	// chain adjusts its difficulty
+	RetargetInterval int32
+	// EpochLength is the number of blocks after which a proof of authority
+	// chain recomputes its validators from the stake bonded to them
+	EpochLength int32
+}
+
+func (c Config) Validate() error {
+	if c.BlockTime <= 0 {
+		return fmt.Errorf("block time must be positive")
+	}
+
+	switch c.Kind {
+	case KindProofOfAuthority:
+		if c.EpochLength < 1 {
+			return fmt.Errorf("epoch length must be at least 1 block")
+		}
+	case KindProofOfWork:
+		if c.RetargetInterval < 2 {
+			return fmt.Errorf("retarget interval must be at least 2 blocks")
+		}
+	default:
+		return fmt.Errorf("unknown consensus %q", c.Kind)
+	}
+
+	return nil
+}
+
+// New creates the engine selected by the configuration. The signer is only
+// used by proof of authority.
+func New(cfg Config, signer *crypto.PrivateKey) Engine {
+	if cfg.Kind == KindProofOfWork {
+		return NewProofOfWork(cfg.BlockTime, cfg.RetargetInterval)
+	}
+	return NewProofOfAuthority(cfg.BlockTime, signer)
+}
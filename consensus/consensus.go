@@ -0,0 +1,35 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+
+	"github.com/webstradev/blockstra/proto"
+)
+
+// ErrNotProposer is returned by Engine.Prepare when the node may not propose
+// a block at the given time
+var ErrNotProposer = errors.New("not the proposer")
+
+// Engine implements the rules deciding who may add a block on top of the
+// chain
+type Engine interface {
+	// Prepare fills in the consensus fields of the header of a new block built
+	// on top of parent. It returns ErrNotProposer when this node may not
+	// propose a block at the timestamp of the header.
+	Prepare(chain ChainReader, header, parent *proto.Header) error
+	// Seal finalizes a prepared block once its transactions are known
+	Seal(ctx context.Context, block *proto.Block) error
+	// VerifyBlock checks the consensus rules of a block built on top of parent
+	VerifyBlock(chain ChainReader, block *proto.Block, parent *proto.Header) error
+}
+
+// ChainReader gives an engine access to the headers of the chain a block is
+// built on
+type ChainReader interface {
+	// HeaderByHeight returns the header of the chain at the given height
+	HeaderByHeight(height int32) (*proto.Header, error)
+	// ValidatorsAt returns the validators allowed to propose the block at the
+	// given height
+	ValidatorsAt(height int32) (*ValidatorSet, error)
+}
@@ -0,0 +1,359 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+)
+
+// maxRoundsAhead bounds how many rounds ahead of the current one votes are
+// kept for
+const maxRoundsAhead = 10
+
+// Finality is a Tendermint style finality gadget on top of the blocks
+// proposed by the validators. For every height the validators prevote for a
+// block and precommit it once more than two thirds of them prevoted for it in
+// the same round. A block is final once more than two thirds of the
+// validators precommit it in the same round.
+//
+// A validator that precommitted a block is locked on it and keeps prevoting
+// for it in later rounds until a later round reaches a quorum of prevotes for
+// another block, so two different blocks can never both be final.
+type Finality struct {
+	lock       sync.Mutex
+	validators *ValidatorSet
+	// signer is the key of this node, nil if it only follows the votes
+	signer *crypto.PrivateKey
+
+	height int32
+	round  int32
+	// candidate is the block at height on the chain of this node
+	candidate []byte
+
+	lockedHash  []byte
+	lockedRound int32
+
+	// votes of the current and the next height by validator public key
+	votes map[voteKey]map[string]*proto.Vote
+}
+
+type voteKey struct {
+	height int32
+	round  int32
+	typ    proto.VoteType
+}
+
+// NewFinality starts finalizing blocks at the given height among the given
+// validators. The signer only votes while it is one of the validators.
+func NewFinality(validators *ValidatorSet, signer *crypto.PrivateKey, height int32) *Finality {
+	return &Finality{
+		validators: validators,
+		signer:     signer,
+		height:     height,
+		votes:      map[voteKey]map[string]*proto.Vote{},
+	}
+}
+
+// Height returns the height of the block being finalized
+func (f *Finality) Height() int32 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.height
+}
+
+func (f *Finality) Round() int32 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.round
+}
+
+// SetCandidate sets the block this node prevotes for at the given height
+func (f *Finality) SetCandidate(height int32, blockHash []byte) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if height == f.height {
+		f.candidate = blockHash
+	}
+}
+
+// AddVote records a vote of a validator. It returns false for votes that are
+// already known or not of use at the current height, those don't have to be
+// relayed.
+func (f *Finality) AddVote(v *proto.Vote) (bool, error) {
+	if err := types.VerifyVote(v); err != nil {
+		return false, err
+	}
+
+	pubKey, err := crypto.PublicKeyFromBytes(v.PublicKey)
+	if err != nil {
+		return false, err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// Votes for finalized heights don't matter anymore and votes too far
+	// ahead can't be used yet
+	if v.Height < f.height || v.Height > f.height+1 || v.Round < 0 || v.Round > f.round+maxRoundsAhead {
+		return false, nil
+	}
+
+	if !f.validators.Contains(pubKey) {
+		// The validators of the next height aren't known yet
+		if v.Height > f.height {
+			return false, nil
+		}
+		return false, fmt.Errorf("vote of unknown validator %x", v.PublicKey)
+	}
+
+	return f.addVote(v)
+}
+
+func (f *Finality) addVote(v *proto.Vote) (bool, error) {
+	key := voteKey{height: v.Height, round: v.Round, typ: v.Type}
+	if f.votes[key] == nil {
+		f.votes[key] = map[string]*proto.Vote{}
+	}
+
+	voter := hex.EncodeToString(v.PublicKey)
+	if known, ok := f.votes[key][voter]; ok {
+		if !bytes.Equal(known.BlockHash, v.BlockHash) {
+			return false, fmt.Errorf("validator %s cast conflicting %s votes at height %d round %d", voter, v.Type, v.Height, v.Round)
+		}
+		return false, nil
+	}
+
+	f.votes[key][voter] = v
+	return true, nil
+}
+
+// Step casts the votes of this node that became due and returns them, to be
+// sent to the other validators. It returns a commit once a block at the
+// current height is final.
+func (f *Finality) Step() ([]*proto.Vote, *proto.Commit) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// Catch up with a later round more than a third of the validators are in
+	for round := f.round + maxRoundsAhead; round > f.round; round-- {
+		if f.participants(round) > f.validators.Len()/3 {
+			f.round = round
+			break
+		}
+	}
+
+	votes := []*proto.Vote{}
+
+	if hash := f.prevoteFor(); hash != nil {
+		if v := f.vote(proto.VoteType_PREVOTE, hash); v != nil {
+			votes = append(votes, v)
+		}
+	}
+
+	if hash, _ := f.majority(f.round, proto.VoteType_PREVOTE); hash != nil {
+		f.lockedHash, f.lockedRound = hash, f.round
+		if v := f.vote(proto.VoteType_PRECOMMIT, hash); v != nil {
+			votes = append(votes, v)
+		}
+	}
+
+	for key := range f.votes {
+		if key.height != f.height || key.typ != proto.VoteType_PRECOMMIT {
+			continue
+		}
+		if hash, precommits := f.majority(key.round, proto.VoteType_PRECOMMIT); hash != nil {
+			return votes, &proto.Commit{
+				Height:     f.height,
+				Round:      key.round,
+				BlockHash:  hash,
+				Precommits: precommits,
+			}
+		}
+	}
+
+	return votes, nil
+}
+
+// Timeout moves on to the next round when the current round didn't finalize
+// a block in time
+func (f *Finality) Timeout() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.round++
+}
+
+// Finalized moves on to the height after the finalized one, voted on by the
+// given validators
+func (f *Finality) Finalized(height int32, validators *ValidatorSet) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if height < f.height {
+		return
+	}
+
+	f.validators = validators
+	f.height = height + 1
+	f.round = 0
+	f.candidate = nil
+	f.lockedHash = nil
+	f.lockedRound = 0
+
+	for key, votes := range f.votes {
+		if key.height < f.height {
+			delete(f.votes, key)
+			continue
+		}
+
+		// Votes for the next height were kept before its validators were known
+		for voter, v := range votes {
+			if !f.validators.Contains(crypto.MustPublicKeyFromBytes(v.PublicKey)) {
+				delete(votes, voter)
+			}
+		}
+	}
+}
+
+// prevoteFor returns the block to prevote for in the current round: the
+// locked block, otherwise the block most validators prevoted for in the
+// previous round so the validators converge when they disagree, otherwise
+// the candidate.
+func (f *Finality) prevoteFor() []byte {
+	if f.lockedHash != nil {
+		return f.lockedHash
+	}
+
+	if f.round > 0 {
+		if hash := f.mostVoted(f.round-1, proto.VoteType_PREVOTE); hash != nil {
+			return hash
+		}
+	}
+
+	return f.candidate
+}
+
+// vote signs a vote of this node in the current round, unless this node
+// isn't a validator or already cast the vote
+func (f *Finality) vote(typ proto.VoteType, hash []byte) *proto.Vote {
+	if f.signer == nil {
+		return nil
+	}
+
+	if !f.validators.Contains(f.signer.Public()) {
+		return nil
+	}
+	if _, ok := f.votes[voteKey{height: f.height, round: f.round, typ: typ}][hex.EncodeToString(f.signer.Public().Bytes())]; ok {
+		return nil
+	}
+
+	v := &proto.Vote{
+		Type:      typ,
+		Height:    f.height,
+		Round:     f.round,
+		BlockHash: hash,
+		PublicKey: f.signer.Public().Bytes(),
+	}
+	v.Signature = types.MustSignVote(f.signer, v).Bytes()
+
+	f.addVote(v)
+
+	return v
+}
+
+// majority returns the block more than two thirds of the validators voted
+// for in the round together with those votes
+func (f *Finality) majority(round int32, typ proto.VoteType) ([]byte, []*proto.Vote) {
+	byHash := map[string][]*proto.Vote{}
+	for _, v := range f.votes[voteKey{height: f.height, round: round, typ: typ}] {
+		key := hex.EncodeToString(v.BlockHash)
+		byHash[key] = append(byHash[key], v)
+	}
+
+	for _, votes := range byHash {
+		if len(votes) >= f.validators.Quorum() {
+			return votes[0].BlockHash, votes
+		}
+	}
+
+	return nil, nil
+}
+
+// mostVoted returns the block with the most votes in the round, ties are
+// broken by the lowest hash
+func (f *Finality) mostVoted(round int32, typ proto.VoteType) []byte {
+	counts := map[string]int{}
+	for _, v := range f.votes[voteKey{height: f.height, round: round, typ: typ}] {
+		counts[string(v.BlockHash)]++
+	}
+
+	hashes := make([]string, 0, len(counts))
+	for hash := range counts {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		if counts[hashes[i]] != counts[hashes[j]] {
+			return counts[hashes[i]] > counts[hashes[j]]
+		}
+		return hashes[i] < hashes[j]
+	})
+
+	if len(hashes) == 0 {
+		return nil
+	}
+	return []byte(hashes[0])
+}
+
+// participants returns the number of validators that voted in the round of
+// the current height
+func (f *Finality) participants(round int32) int {
+	voters := map[string]bool{}
+	for _, typ := range []proto.VoteType{proto.VoteType_PREVOTE, proto.VoteType_PRECOMMIT} {
+		for voter := range f.votes[voteKey{height: f.height, round: round, typ: typ}] {
+			voters[voter] = true
+		}
+	}
+	return len(voters)
+}
+
+// VerifyCommit checks that a commit holds valid precommits for its block of
+// more than two thirds of the validators
+func VerifyCommit(commit *proto.Commit, validators *ValidatorSet) error {
+	if commit == nil {
+		return fmt.Errorf("commit is nil")
+	}
+
+	signers := map[int]bool{}
+	for _, v := range commit.Precommits {
+		if v.Type != proto.VoteType_PRECOMMIT || v.Height != commit.Height || v.Round != commit.Round || !bytes.Equal(v.BlockHash, commit.BlockHash) {
+			return fmt.Errorf("commit holds a vote for another block")
+		}
+
+		if err := types.VerifyVote(v); err != nil {
+			return err
+		}
+
+		pubKey, err := crypto.PublicKeyFromBytes(v.PublicKey)
+		if err != nil {
+			return err
+		}
+
+		index := validators.Index(pubKey)
+		if index < 0 {
+			return fmt.Errorf("commit holds a vote of unknown validator %x", v.PublicKey)
+		}
+		signers[index] = true
+	}
+
+	if len(signers) < validators.Quorum() {
+		return fmt.Errorf("commit has %d precommits, needs %d", len(signers), validators.Quorum())
+	}
+
+	return nil
+}
@@ -0,0 +1,259 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+	"github.com/webstradev/blockstra/util"
+)
+
+// testNetwork runs a finality gadget per validator, plus one following the
+// votes without voting, and delivers every vote to all of them
+type testNetwork struct {
+	t        *testing.T
+	keys     []*crypto.PrivateKey
+	set      *ValidatorSet
+	gadgets  []*Finality
+	follower *Finality
+}
+
+func newTestNetwork(t *testing.T, n int) *testNetwork {
+	net := &testNetwork{t: t}
+	pubKeys := []*crypto.PublicKey{}
+	for i := 0; i < n; i++ {
+		key := crypto.MustGeneratePrivateKey()
+		net.keys = append(net.keys, key)
+		pubKeys = append(pubKeys, key.Public())
+	}
+
+	net.set = NewValidatorSet(pubKeys)
+	for _, key := range net.keys {
+		net.gadgets = append(net.gadgets, NewFinality(net.set, key, 1))
+	}
+	net.follower = NewFinality(net.set, nil, 1)
+
+	return net
+}
+
+func (net *testNetwork) all() []*Finality {
+	return append(append([]*Finality{}, net.gadgets...), net.follower)
+}
+
+// run steps every gadget until no more votes are cast and returns the
+// commits they reached
+func (net *testNetwork) run() []*proto.Commit {
+	commits := make([]*proto.Commit, len(net.gadgets)+1)
+	for {
+		pending := []*proto.Vote{}
+		for i, f := range net.all() {
+			votes, commit := f.Step()
+			pending = append(pending, votes...)
+			if commit != nil {
+				commits[i] = commit
+			}
+		}
+
+		if len(pending) == 0 {
+			return commits
+		}
+
+		for _, v := range pending {
+			for _, f := range net.all() {
+				_, err := f.AddVote(v)
+				assert.NoError(net.t, err)
+			}
+		}
+	}
+}
+
+// testPrevote returns a prevote signed by key
+func testPrevote(key *crypto.PrivateKey, height, round int32, hash []byte) *proto.Vote {
+	v := &proto.Vote{
+		Type:      proto.VoteType_PREVOTE,
+		Height:    height,
+		Round:     round,
+		BlockHash: hash,
+		PublicKey: key.Public().Bytes(),
+	}
+	v.Signature = types.MustSignVote(key, v).Bytes()
+	return v
+}
+
+func TestFinality(t *testing.T) {
+	var (
+		net  = newTestNetwork(t, 4)
+		hash = util.RandomHash()
+	)
+
+	for _, f := range net.gadgets {
+		f.SetCandidate(1, hash)
+	}
+
+	for _, commit := range net.run() {
+		assert.NotNil(t, commit)
+		assert.Equal(t, hash, commit.BlockHash)
+		assert.Equal(t, int32(1), commit.Height)
+		assert.NoError(t, VerifyCommit(commit, net.set))
+	}
+
+	for _, f := range net.all() {
+		f.Finalized(1, net.set)
+		assert.Equal(t, int32(2), f.Height())
+	}
+}
+
+func TestFinalityConvergesAfterTimeout(t *testing.T) {
+	var (
+		net = newTestNetwork(t, 4)
+		a   = util.RandomHash()
+		b   = util.RandomHash()
+	)
+
+	// Half of the validators see a different block at height 1
+	for i, f := range net.gadgets {
+		if i%2 == 0 {
+			f.SetCandidate(1, a)
+		} else {
+			f.SetCandidate(1, b)
+		}
+	}
+
+	for _, commit := range net.run() {
+		assert.Nil(t, commit)
+	}
+
+	for _, f := range net.all() {
+		f.Timeout()
+	}
+
+	commits := net.run()
+	for _, commit := range commits {
+		assert.NotNil(t, commit)
+		assert.Equal(t, commits[0].BlockHash, commit.BlockHash)
+		assert.Equal(t, int32(1), commit.Round)
+		assert.NoError(t, VerifyCommit(commit, net.set))
+	}
+}
+
+func TestFinalityCatchesUpWithRound(t *testing.T) {
+	var (
+		net  = newTestNetwork(t, 4)
+		hash = util.RandomHash()
+	)
+
+	// The first validator is a round behind the others
+	for i, f := range net.gadgets {
+		f.SetCandidate(1, hash)
+		if i > 0 {
+			f.Timeout()
+		}
+	}
+
+	for _, commit := range net.run() {
+		assert.NotNil(t, commit)
+		assert.Equal(t, int32(1), commit.Round)
+	}
+	assert.Equal(t, int32(1), net.gadgets[0].Round())
+}
+
+func TestFinalityAddVote(t *testing.T) {
+	var (
+		net   = newTestNetwork(t, 4)
+		f     = net.follower
+		other = crypto.MustGeneratePrivateKey()
+	)
+
+	v := testPrevote(net.keys[0], 1, 0, util.RandomHash())
+	added, err := f.AddVote(v)
+	assert.True(t, added)
+	assert.NoError(t, err)
+
+	// Known votes don't have to be relayed again
+	added, err = f.AddVote(v)
+	assert.False(t, added)
+	assert.NoError(t, err)
+
+	_, err = f.AddVote(testPrevote(net.keys[0], 1, 0, util.RandomHash()))
+	assert.Error(t, err)
+
+	_, err = f.AddVote(testPrevote(other, 1, 0, util.RandomHash()))
+	assert.Error(t, err)
+
+	// Votes too far ahead are dropped
+	added, err = f.AddVote(testPrevote(net.keys[1], 3, 0, util.RandomHash()))
+	assert.False(t, added)
+	assert.NoError(t, err)
+
+	added, err = f.AddVote(testPrevote(net.keys[1], 1, maxRoundsAhead+1, util.RandomHash()))
+	assert.False(t, added)
+	assert.NoError(t, err)
+
+	v = testPrevote(net.keys[1], 1, 0, util.RandomHash())
+	v.Round = 1
+	_, err = f.AddVote(v)
+	assert.Error(t, err)
+
+	// Votes for finalized heights are ignored
+	f.Finalized(1, net.set)
+	added, err = f.AddVote(testPrevote(net.keys[1], 1, 0, util.RandomHash()))
+	assert.False(t, added)
+	assert.NoError(t, err)
+}
+
+func TestVerifyCommit(t *testing.T) {
+	var (
+		net  = newTestNetwork(t, 4)
+		hash = util.RandomHash()
+	)
+
+	for _, f := range net.gadgets {
+		f.SetCandidate(1, hash)
+	}
+	commit := net.run()[0]
+	assert.NoError(t, VerifyCommit(commit, net.set))
+
+	// A quorum of distinct validators is required
+	duplicated := &proto.Commit{
+		Height:     commit.Height,
+		Round:      commit.Round,
+		BlockHash:  commit.BlockHash,
+		Precommits: []*proto.Vote{commit.Precommits[0], commit.Precommits[0], commit.Precommits[0]},
+	}
+	assert.Error(t, VerifyCommit(duplicated, net.set))
+
+	commit.BlockHash = util.RandomHash()
+	assert.Error(t, VerifyCommit(commit, net.set))
+
+	assert.Error(t, VerifyCommit(nil, net.set))
+	assert.Error(t, VerifyCommit(commit, NewValidatorSet(nil)))
+}
+
+func TestFinalityWithChangedValidators(t *testing.T) {
+	var (
+		net  = newTestNetwork(t, 4)
+		f    = net.follower
+		next = NewValidatorSet([]*crypto.PublicKey{net.keys[0].Public(), net.keys[1].Public(), net.keys[2].Public()})
+	)
+
+	// Votes for the next height are kept until its validators are known
+	for _, key := range net.keys {
+		added, err := f.AddVote(testPrevote(key, 2, 0, util.RandomHash()))
+		assert.NoError(t, err)
+		assert.True(t, added)
+	}
+
+	// The removed validator's vote is dropped with the validator
+	f.Finalized(1, next)
+	_, err := f.AddVote(testPrevote(net.keys[3], 2, 0, util.RandomHash()))
+	assert.Error(t, err)
+	assert.Equal(t, 3, len(f.votes[voteKey{height: 2, typ: proto.VoteType_PREVOTE}]))
+
+	// A removed validator doesn't vote anymore
+	removed := NewFinality(next, net.keys[3], 2)
+	removed.SetCandidate(2, util.RandomHash())
+	votes, _ := removed.Step()
+	assert.Empty(t, votes)
+}
@@ -0,0 +1,122 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+)
+
+// ProofOfAuthority lets the validators of the chain propose blocks in turn.
+// Every blockTime after the parent block a new round starts, so when the
+// proposer of a round is offline the next validator takes over once the
+// round has passed.
+type ProofOfAuthority struct {
+	blockTime time.Duration
+	// signer is the key of this node, nil if it only verifies blocks
+	signer *crypto.PrivateKey
+}
+
+func NewProofOfAuthority(blockTime time.Duration, signer *crypto.PrivateKey) *ProofOfAuthority {
+	return &ProofOfAuthority{
+		blockTime: blockTime,
+		signer:    signer,
+	}
+}
+
+// Round returns the round a block with the given timestamp is proposed in.
+// Round 0 starts one blockTime after the parent block.
+func (p *ProofOfAuthority) Round(parent *proto.Header, timestamp int64) (int64, error) {
+	elapsed := timestamp - parent.Timestamp
+	if elapsed < int64(p.blockTime) {
+		return 0, fmt.Errorf("block timestamp %d is less than %s after its parent", timestamp, p.blockTime)
+	}
+	return elapsed/int64(p.blockTime) - 1, nil
+}
+
+func (p *ProofOfAuthority) Prepare(chain ChainReader, header, parent *proto.Header) error {
+	if p.signer == nil {
+		return ErrNotProposer
+	}
+
+	round, err := p.Round(parent, header.Timestamp)
+	if err != nil {
+		return ErrNotProposer
+	}
+
+	validators, err := chain.ValidatorsAt(header.Height)
+	if err != nil {
+		return err
+	}
+
+	proposer := validators.Proposer(header.Height, round)
+	if proposer == nil || !bytes.Equal(proposer.Bytes(), p.signer.Public().Bytes()) {
+		return ErrNotProposer
+	}
+
+	return nil
+}
+
+// Seal signs the block with the key of this node
+func (p *ProofOfAuthority) Seal(ctx context.Context, block *proto.Block) error {
+	if p.signer == nil {
+		return ErrNotProposer
+	}
+
+	sig, err := types.SignBlock(p.signer, block)
+	if err != nil {
+		return err
+	}
+
+	block.PublicKey = p.signer.Public().Bytes()
+	block.Signature = sig.Bytes()
+
+	return nil
+}
+
+// VerifyBlock checks that the block is signed by the proposer of its round.
+// Blocks more than half a blockTime in the future are rejected, so a
+// validator can't take over the round of the validator before it.
+func (p *ProofOfAuthority) VerifyBlock(chain ChainReader, block *proto.Block, parent *proto.Header) error {
+	hash, err := types.HashBlock(block)
+	if err != nil {
+		return err
+	}
+
+	if maxTimestamp := time.Now().Add(p.blockTime / 2).UnixNano(); block.Header.Timestamp > maxTimestamp {
+		return fmt.Errorf("block timestamp %d is in the future", block.Header.Timestamp)
+	}
+
+	round, err := p.Round(parent, block.Header.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	validators, err := chain.ValidatorsAt(block.Header.Height)
+	if err != nil {
+		return err
+	}
+
+	proposer := validators.Proposer(block.Header.Height, round)
+	if proposer == nil {
+		return fmt.Errorf("validator set is empty")
+	}
+
+	if !bytes.Equal(proposer.Bytes(), block.PublicKey) {
+		return fmt.Errorf("block at height %d round %d is not signed by its proposer", block.Header.Height, round)
+	}
+
+	sig, err := crypto.SignatureFromBytes(block.Signature)
+	if err != nil {
+		return err
+	}
+	if !sig.Verify(proposer, hash) {
+		return fmt.Errorf("invalid block signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+)
+
+const testBlockTime = time.Second
+
+// testValidators is a ChainReader with the same validators at every height
+type testValidators struct {
+	set *ValidatorSet
+}
+
+func (v testValidators) HeaderByHeight(height int32) (*proto.Header, error) {
+	return nil, fmt.Errorf("no header at height %d", height)
+}
+
+func (v testValidators) ValidatorsAt(height int32) (*ValidatorSet, error) {
+	return v.set, nil
+}
+
+func newTestBlock(parent *proto.Header, rounds int64) *proto.Block {
+	return &proto.Block{
+		Header: &proto.Header{
+			Version:   "1",
+			Height:    parent.Height + 1,
+			PrevHash:  types.MustHashHeader(parent),
+			Timestamp: parent.Timestamp + (rounds+1)*int64(testBlockTime),
+		},
+	}
+}
+
+func TestProofOfAuthority(t *testing.T) {
+	var (
+		keys   = []*crypto.PrivateKey{crypto.MustGeneratePrivateKey(), crypto.MustGeneratePrivateKey()}
+		chain  = testValidators{set: NewValidatorSet([]*crypto.PublicKey{keys[0].Public(), keys[1].Public()})}
+		first  = NewProofOfAuthority(testBlockTime, keys[0])
+		second = NewProofOfAuthority(testBlockTime, keys[1])
+		parent = &proto.Header{Version: "1", Timestamp: time.Now().Add(-time.Minute).UnixNano()}
+	)
+
+	// Height 1 round 0 belongs to the second validator
+	block := newTestBlock(parent, 0)
+	assert.ErrorIs(t, first.Prepare(chain, block.Header, parent), ErrNotProposer)
+	assert.NoError(t, second.Prepare(chain, block.Header, parent))
+	assert.NoError(t, second.Seal(context.Background(), block))
+	assert.NoError(t, first.VerifyBlock(chain, block, parent))
+
+	// Tampering with the header invalidates the signature
+	block.Header.RootHash = []byte{1}
+	assert.Error(t, first.VerifyBlock(chain, block, parent))
+
+	// A block signed by the wrong validator is rejected
+	block = newTestBlock(parent, 0)
+	assert.NoError(t, first.Seal(context.Background(), block))
+	assert.Error(t, second.VerifyBlock(chain, block, parent))
+
+	// Once round 0 is missed the first validator takes over
+	block = newTestBlock(parent, 1)
+	assert.NoError(t, first.Prepare(chain, block.Header, parent))
+	assert.NoError(t, first.Seal(context.Background(), block))
+	assert.NoError(t, second.VerifyBlock(chain, block, parent))
+}
+
+func TestProofOfAuthorityRejectsTimestamps(t *testing.T) {
+	var (
+		key    = crypto.MustGeneratePrivateKey()
+		chain  = testValidators{set: NewValidatorSet([]*crypto.PublicKey{key.Public()})}
+		poa    = NewProofOfAuthority(testBlockTime, key)
+		parent = &proto.Header{Version: "1", Timestamp: time.Now().UnixNano()}
+	)
+
+	// Too early after the parent
+	block := newTestBlock(parent, 0)
+	block.Header.Timestamp = parent.Timestamp + 1
+	assert.ErrorIs(t, poa.Prepare(chain, block.Header, parent), ErrNotProposer)
+	assert.NoError(t, poa.Seal(context.Background(), block))
+	assert.Error(t, poa.VerifyBlock(chain, block, parent))
+
+	// Too far in the future
+	block = newTestBlock(parent, 10)
+	assert.NoError(t, poa.Seal(context.Background(), block))
+	assert.Error(t, poa.VerifyBlock(chain, block, parent))
+}
+
+func TestProofOfAuthorityWithoutSigner(t *testing.T) {
+	var (
+		chain  = testValidators{set: NewValidatorSet([]*crypto.PublicKey{crypto.MustGeneratePrivateKey().Public()})}
+		poa    = NewProofOfAuthority(testBlockTime, nil)
+		parent = &proto.Header{Version: "1"}
+		block  = newTestBlock(parent, 0)
+	)
+
+	assert.ErrorIs(t, poa.Prepare(chain, block.Header, parent), ErrNotProposer)
+	assert.ErrorIs(t, poa.Seal(context.Background(), block), ErrNotProposer)
+}
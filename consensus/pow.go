@@ -0,0 +1,160 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+)
+
+const (
+	// minDifficulty is the difficulty of a chain whose genesis block doesn't
+	// set one
+	minDifficulty = 1
+	// maxRetargetFactor bounds how much the difficulty changes per retarget
+	maxRetargetFactor = 4
+	// checkInterval is the number of nonces tried between checks whether
+	// mining was canceled
+	checkInterval = 1 << 12
+)
+
+// maxHash is the highest possible header hash
+var maxHash = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// ProofOfWork lets anyone add a block whose header hash meets the difficulty
+// target. Every retargetInterval blocks the difficulty is adjusted so blocks
+// are found every blockTime on average. The chain follows the branch with the
+// most cumulative difficulty that doesn't conflict with a final block, of
+// branches with the same work it keeps the one it saw first.
+type ProofOfWork struct {
+	blockTime        time.Duration
+	retargetInterval int32
+}
+
+func NewProofOfWork(blockTime time.Duration, retargetInterval int32) *ProofOfWork {
+	return &ProofOfWork{
+		blockTime:        blockTime,
+		retargetInterval: retargetInterval,
+	}
+}
+
+// Difficulty returns the difficulty of the block at height, built on parent
+func (p *ProofOfWork) Difficulty(chain ChainReader, height int32, parent *proto.Header) (uint64, error) {
+	difficulty := parent.Difficulty
+	if difficulty < minDifficulty {
+		difficulty = minDifficulty
+	}
+
+	if height%p.retargetInterval != 0 {
+		return difficulty, nil
+	}
+
+	first, err := chain.HeaderByHeight(height - p.retargetInterval)
+	if err != nil {
+		return 0, err
+	}
+
+	// The headers from first to parent span retargetInterval-1 block times
+	var (
+		expected = int64(p.blockTime) * int64(p.retargetInterval-1)
+		actual   = parent.Timestamp - first.Timestamp
+	)
+	if actual < expected/maxRetargetFactor {
+		actual = expected / maxRetargetFactor
+	}
+	if actual > expected*maxRetargetFactor {
+		actual = expected * maxRetargetFactor
+	}
+
+	next := new(big.Int).SetUint64(difficulty)
+	next.Mul(next, big.NewInt(expected))
+	next.Div(next, big.NewInt(actual))
+
+	switch {
+	case next.Cmp(big.NewInt(minDifficulty)) < 0:
+		return minDifficulty, nil
+	case !next.IsUint64():
+		return math.MaxUint64, nil
+	default:
+		return next.Uint64(), nil
+	}
+}
+
+func (p *ProofOfWork) Prepare(chain ChainReader, header, parent *proto.Header) error {
+	if header.Timestamp <= parent.Timestamp {
+		header.Timestamp = parent.Timestamp + 1
+	}
+
+	difficulty, err := p.Difficulty(chain, header.Height, parent)
+	if err != nil {
+		return err
+	}
+	header.Difficulty = difficulty
+
+	return nil
+}
+
+// Seal searches for a nonce meeting the difficulty target of the block until
+// it finds one or ctx is done
+func (p *ProofOfWork) Seal(ctx context.Context, block *proto.Block) error {
+	target := Target(block.Header.Difficulty)
+
+	for nonce := uint64(0); ; nonce++ {
+		if nonce%checkInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		block.Header.Nonce = nonce
+		if meetsTarget(types.MustHashHeader(block.Header), target) {
+			return nil
+		}
+	}
+}
+
+// VerifyBlock checks the difficulty and the work of the block. Blocks more
+// than two block times in the future are rejected.
+func (p *ProofOfWork) VerifyBlock(chain ChainReader, block *proto.Block, parent *proto.Header) error {
+	hash, err := types.HashBlock(block)
+	if err != nil {
+		return err
+	}
+
+	if block.Header.Timestamp <= parent.Timestamp {
+		return fmt.Errorf("block timestamp %d is not after its parent", block.Header.Timestamp)
+	}
+	if maxTimestamp := time.Now().Add(2 * p.blockTime).UnixNano(); block.Header.Timestamp > maxTimestamp {
+		return fmt.Errorf("block timestamp %d is in the future", block.Header.Timestamp)
+	}
+
+	difficulty, err := p.Difficulty(chain, block.Header.Height, parent)
+	if err != nil {
+		return err
+	}
+	if block.Header.Difficulty != difficulty {
+		return fmt.Errorf("block difficulty %d, expected %d", block.Header.Difficulty, difficulty)
+	}
+
+	if !meetsTarget(hash, Target(difficulty)) {
+		return fmt.Errorf("block hash %x does not meet the difficulty %d", hash, difficulty)
+	}
+
+	return nil
+}
+
+// Target returns the highest header hash meeting the difficulty
+func Target(difficulty uint64) *big.Int {
+	if difficulty < minDifficulty {
+		difficulty = minDifficulty
+	}
+	return new(big.Int).Div(maxHash, new(big.Int).SetUint64(difficulty))
+}
+
+func meetsTarget(hash []byte, target *big.Int) bool {
+	return new(big.Int).SetBytes(hash).Cmp(target) <= 0
+}
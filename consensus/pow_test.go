@@ -0,0 +1,145 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+)
+
+// testHeaders is a chain of headers implementing ChainReader
+type testHeaders []*proto.Header
+
+func (h testHeaders) HeaderByHeight(height int32) (*proto.Header, error) {
+	if height < 0 || int(height) >= len(h) {
+		return nil, fmt.Errorf("no header at height %d", height)
+	}
+	return h[height], nil
+}
+
+func (h testHeaders) ValidatorsAt(height int32) (*ValidatorSet, error) {
+	return NewValidatorSet(nil), nil
+}
+
+// newTestHeaders returns n headers, spaced by interval, starting an hour
+// ago with the given difficulty
+func newTestHeaders(n int, interval time.Duration, difficulty uint64) testHeaders {
+	start := time.Now().Add(-time.Hour).UnixNano()
+	headers := testHeaders{}
+	for i := 0; i < n; i++ {
+		headers = append(headers, &proto.Header{
+			Version:    "1",
+			Height:     int32(i),
+			Timestamp:  start + int64(i)*int64(interval),
+			Difficulty: difficulty,
+		})
+	}
+	return headers
+}
+
+func TestProofOfWork(t *testing.T) {
+	var (
+		pow     = NewProofOfWork(testBlockTime, 10)
+		headers = newTestHeaders(1, testBlockTime, 256)
+		parent  = headers[0]
+	)
+
+	block := &proto.Block{Header: &proto.Header{Version: "1", Height: 1, PrevHash: types.MustHashHeader(parent)}}
+	assert.NoError(t, pow.Prepare(headers, block.Header, parent))
+	assert.Equal(t, uint64(256), block.Header.Difficulty)
+	assert.Greater(t, block.Header.Timestamp, parent.Timestamp)
+
+	assert.NoError(t, pow.Seal(context.Background(), block))
+	assert.NoError(t, pow.VerifyBlock(headers, block, parent))
+
+	// Changing the header invalidates the work, with overwhelming odds
+	block.Header.Nonce++
+	for meetsTarget(types.MustHashHeader(block.Header), Target(256)) {
+		block.Header.Nonce++
+	}
+	assert.Error(t, pow.VerifyBlock(headers, block, parent))
+
+	// The difficulty is part of the consensus rules
+	block.Header.Difficulty = 1
+	assert.NoError(t, pow.Seal(context.Background(), block))
+	assert.Error(t, pow.VerifyBlock(headers, block, parent))
+}
+
+func TestProofOfWorkSealCanceled(t *testing.T) {
+	var (
+		pow   = NewProofOfWork(testBlockTime, 10)
+		block = &proto.Block{Header: &proto.Header{Difficulty: 1 << 62}}
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, pow.Seal(ctx, block), context.Canceled)
+}
+
+func TestProofOfWorkRejectsTimestamps(t *testing.T) {
+	var (
+		pow     = NewProofOfWork(testBlockTime, 10)
+		headers = newTestHeaders(1, testBlockTime, 1)
+		parent  = headers[0]
+	)
+
+	block := &proto.Block{Header: &proto.Header{Height: 1, Timestamp: parent.Timestamp, Difficulty: 1}}
+	assert.Error(t, pow.VerifyBlock(headers, block, parent))
+
+	block.Header.Timestamp = time.Now().Add(time.Minute).UnixNano()
+	assert.Error(t, pow.VerifyBlock(headers, block, parent))
+}
+
+func TestDifficultyRetarget(t *testing.T) {
+	pow := NewProofOfWork(testBlockTime, 10)
+
+	// Only every retarget interval the difficulty changes
+	headers := newTestHeaders(5, testBlockTime/2, 1000)
+	difficulty, err := pow.Difficulty(headers, 5, headers[4])
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1000), difficulty)
+
+	// Blocks found in half the block time double the difficulty
+	headers = newTestHeaders(10, testBlockTime/2, 1000)
+	difficulty, err = pow.Difficulty(headers, 10, headers[9])
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2000), difficulty)
+
+	// Blocks found in twice the block time halve it
+	headers = newTestHeaders(10, 2*testBlockTime, 1000)
+	difficulty, err = pow.Difficulty(headers, 10, headers[9])
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(500), difficulty)
+
+	// The change per retarget is bounded
+	headers = newTestHeaders(10, testBlockTime/100, 1000)
+	difficulty, err = pow.Difficulty(headers, 10, headers[9])
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4000), difficulty)
+
+	headers = newTestHeaders(10, 100*testBlockTime, 2)
+	difficulty, err = pow.Difficulty(headers, 10, headers[9])
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(minDifficulty), difficulty)
+
+	// A retarget needs the headers of the interval
+	_, err = pow.Difficulty(headers[:0], 10, headers[9])
+	assert.Error(t, err)
+}
+
+func TestConfig(t *testing.T) {
+	assert.NoError(t, Config{Kind: KindProofOfAuthority, BlockTime: time.Second, EpochLength: 1}.Validate())
+	assert.NoError(t, Config{Kind: KindProofOfWork, BlockTime: time.Second, RetargetInterval: 2}.Validate())
+
+	assert.Error(t, Config{Kind: KindProofOfWork, BlockTime: time.Second, RetargetInterval: 1}.Validate())
+	assert.Error(t, Config{Kind: KindProofOfAuthority}.Validate())
+	assert.Error(t, Config{Kind: KindProofOfAuthority, BlockTime: time.Second}.Validate())
+	assert.Error(t, Config{Kind: "pos", BlockTime: time.Second}.Validate())
+
+	assert.IsType(t, &ProofOfWork{}, New(Config{Kind: KindProofOfWork}, nil))
+	assert.IsType(t, &ProofOfAuthority{}, New(Config{Kind: KindProofOfAuthority}, nil))
+}
@@ -0,0 +1,72 @@
+package consensus
+
+import (
+	"bytes"
+
+	"github.com/webstradev/blockstra/crypto"
+)
+
+// ValidatorSet is the ordered list of public keys allowed to propose blocks
+type ValidatorSet struct {
+	validators []*crypto.PublicKey
+}
+
+// NewValidatorSet creates a set of the given validators, in order. Nil keys
+// and duplicates are dropped.
+func NewValidatorSet(validators []*crypto.PublicKey) *ValidatorSet {
+	set := &ValidatorSet{}
+	for _, v := range validators {
+		if v != nil && set.Index(v) < 0 {
+			set.validators = append(set.validators, v)
+		}
+	}
+	return set
+}
+
+// Validators returns the validators of the set in order
+func (s *ValidatorSet) Validators() []*crypto.PublicKey {
+	return append([]*crypto.PublicKey{}, s.validators...)
+}
+
+func (s *ValidatorSet) Len() int {
+	return len(s.validators)
+}
+
+// Index returns the position of the validator in the set or -1 if it is not
+// part of the set
+func (s *ValidatorSet) Index(pubKey *crypto.PublicKey) int {
+	for i, v := range s.validators {
+		if bytes.Equal(v.Bytes(), pubKey.Bytes()) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *ValidatorSet) Contains(pubKey *crypto.PublicKey) bool {
+	return s.Index(pubKey) >= 0
+}
+
+// Proposer returns the validator allowed to propose the block at the given
+// height in the given round. Validators take turns by height and every
+// missed round passes the turn on to the next validator. It returns nil for
+// an empty set.
+func (s *ValidatorSet) Proposer(height int32, round int64) *crypto.PublicKey {
+	n := int64(len(s.validators))
+	if n == 0 {
+		return nil
+	}
+
+	i := (int64(height)%n + round%n) % n
+	if i < 0 {
+		i += n
+	}
+
+	return s.validators[i]
+}
+
+// Quorum returns the number of validators making up more than two thirds of
+// the set
+func (s *ValidatorSet) Quorum() int {
+	return 2*len(s.validators)/3 + 1
+}
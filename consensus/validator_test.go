@@ -0,0 +1,44 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+)
+
+func TestNewValidatorSet(t *testing.T) {
+	var (
+		a = crypto.MustGeneratePrivateKey().Public()
+		b = crypto.MustGeneratePrivateKey().Public()
+	)
+
+	set := NewValidatorSet([]*crypto.PublicKey{a, nil, b, a})
+	assert.Equal(t, 2, set.Len())
+	assert.Equal(t, 0, set.Index(a))
+	assert.Equal(t, 1, set.Index(b))
+	assert.False(t, set.Contains(crypto.MustGeneratePrivateKey().Public()))
+}
+
+func TestProposer(t *testing.T) {
+	var (
+		a = crypto.MustGeneratePrivateKey().Public()
+		b = crypto.MustGeneratePrivateKey().Public()
+		c = crypto.MustGeneratePrivateKey().Public()
+	)
+
+	set := NewValidatorSet([]*crypto.PublicKey{a, b, c})
+
+	// Validators take turns by height
+	assert.Equal(t, a, set.Proposer(0, 0))
+	assert.Equal(t, b, set.Proposer(1, 0))
+	assert.Equal(t, c, set.Proposer(2, 0))
+	assert.Equal(t, a, set.Proposer(3, 0))
+
+	// A missed round passes the turn on
+	assert.Equal(t, c, set.Proposer(1, 1))
+	assert.Equal(t, a, set.Proposer(1, 2))
+	assert.Equal(t, c, set.Proposer(1, 1<<62))
+
+	assert.Nil(t, NewValidatorSet(nil).Proposer(1, 0))
+}
@@ -0,0 +1,103 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// Network is the human readable prefix of an encoded address. Addresses
+// encoded for one network are rejected when parsed for another.
+type Network string
+
+const (
+	MainNet Network = "bs"
+	TestNet Network = "tbs"
+)
+
+// AddressVersion is the first byte of every address and determines how the
+// address was derived from the public key.
+type AddressVersion byte
+
+const (
+	// AddressVersionLegacy addresses are the last 20 bytes of the public key
+	// without a version byte, like addresses were before they had versions.
+	// They are only supported so outputs created with them, like genesis
+	// allocations, stay spendable.
+	AddressVersionLegacy AddressVersion = 0x00
+	// AddressVersionSHA256 addresses are the first 20 bytes of the SHA256 of
+	// the public key.
+	AddressVersionSHA256 AddressVersion = 0x01
+
+	CurrentAddressVersion = AddressVersionSHA256
+)
+
+type Address struct {
+	value []byte
+}
+
+// AddressFromBytes creates an address from its raw bytes, like the ones
+// stored in a transaction output. Legacy addresses are the 20 bytes of the
+// public key, addresses of later versions start with their version byte.
+func AddressFromBytes(b []byte) (Address, error) {
+	if len(b) == addressHashLen {
+		return Address{value: b}, nil
+	}
+	if len(b) != addressLen {
+		return Address{}, fmt.Errorf("invalid address length %d, must be %d or %d for legacy addresses", len(b), addressLen, addressHashLen)
+	}
+
+	if version := AddressVersion(b[0]); version == AddressVersionLegacy || version > CurrentAddressVersion {
+		return Address{}, fmt.Errorf("unknown address version %d", version)
+	}
+
+	return Address{value: b}, nil
+}
+
+// ParseAddress decodes an address that was encoded for the given network,
+// verifying its checksum.
+func ParseAddress(s string, network Network) (Address, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return Address{}, fmt.Errorf("invalid address %q: %w", s, err)
+	}
+
+	if hrp != string(network) {
+		return Address{}, fmt.Errorf("invalid address %q: expected network prefix %q, got %q", s, network, hrp)
+	}
+
+	b, err := convertBits(data, 5, 8, false)
+	if err != nil {
+		return Address{}, fmt.Errorf("invalid address %q: %w", s, err)
+	}
+
+	return AddressFromBytes(b)
+}
+
+// ValidateAddress returns an error if s is not a valid address on the network
+func ValidateAddress(s string, network Network) error {
+	_, err := ParseAddress(s, network)
+	return err
+}
+
+func (a Address) Version() AddressVersion {
+	if len(a.value) != addressLen {
+		return AddressVersionLegacy
+	}
+	return AddressVersion(a.value[0])
+}
+
+func (a Address) Bytes() []byte {
+	return a.value
+}
+
+// Encode returns the human readable, checksummed form of the address for the
+// given network.
+func (a Address) Encode(network Network) string {
+	// converting from 8 to 5 bit groups with padding can not fail
+	data, _ := convertBits(a.value, 8, 5, true)
+	return bech32Encode(string(network), data)
+}
+
+func (a Address) String() string {
+	return hex.EncodeToString(a.value)
+}
@@ -0,0 +1,73 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressEncode(t *testing.T) {
+	address := MustGeneratePrivateKey().Public().Address()
+
+	encoded := address.Encode(MainNet)
+	assert.True(t, strings.HasPrefix(encoded, "bs1"))
+
+	parsed, err := ParseAddress(encoded, MainNet)
+	assert.NoError(t, err)
+	assert.Equal(t, address.Bytes(), parsed.Bytes())
+
+	// Uppercase addresses are valid as well
+	parsed, err = ParseAddress(strings.ToUpper(encoded), MainNet)
+	assert.NoError(t, err)
+	assert.Equal(t, address.Bytes(), parsed.Bytes())
+}
+
+func TestParseAddressWrongNetwork(t *testing.T) {
+	address := MustGeneratePrivateKey().Public().Address()
+
+	_, err := ParseAddress(address.Encode(TestNet), MainNet)
+	assert.Error(t, err)
+
+	assert.NoError(t, ValidateAddress(address.Encode(TestNet), TestNet))
+}
+
+func TestParseAddressTypo(t *testing.T) {
+	encoded := MustGeneratePrivateKey().Public().Address().Encode(MainNet)
+
+	// Swap a single character of the payload
+	typo := []byte(encoded)
+	if typo[5] == 'q' {
+		typo[5] = 'p'
+	} else {
+		typo[5] = 'q'
+	}
+
+	assert.Error(t, ValidateAddress(string(typo), MainNet))
+
+	// Plain hex addresses are not accepted
+	assert.Error(t, ValidateAddress("013657440b42694d9d9aa8229513626c2972e2211c", MainNet))
+}
+
+func TestAddressFromBytes(t *testing.T) {
+	_, err := AddressFromBytes(make([]byte, addressLen+1))
+	assert.Error(t, err)
+
+	versioned := make([]byte, addressLen)
+	versioned[0] = byte(AddressVersionSHA256)
+	address, err := AddressFromBytes(versioned)
+	assert.NoError(t, err)
+	assert.Equal(t, AddressVersionSHA256, address.Version())
+
+	// Legacy addresses have no version byte
+	address, err = AddressFromBytes(make([]byte, addressHashLen))
+	assert.NoError(t, err)
+	assert.Equal(t, AddressVersionLegacy, address.Version())
+	_, err = AddressFromBytes(make([]byte, addressLen))
+	assert.Error(t, err)
+
+	unknownVersion := make([]byte, addressLen)
+	unknownVersion[0] = 0x7f
+	_, err = AddressFromBytes(unknownVersion)
+	assert.Error(t, err)
+}
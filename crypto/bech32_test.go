@@ -0,0 +1,54 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Valid and invalid checksums from the BIP-173 test vectors
+func TestBech32Decode(t *testing.T) {
+	valid := []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	}
+	for _, s := range valid {
+		hrp, data, err := bech32Decode(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, strings.ToLower(s[:strings.LastIndex(s, "1")]), hrp, s)
+		assert.Equal(t, len(s)-len(hrp)-7, len(data), s)
+	}
+
+	invalid := []string{
+		"pzry9x0s0muk",  // no separator
+		"1pzry9x0s0muk", // empty prefix
+		"x1b4n0q5v",     // invalid data character
+		"li1dgmt3",      // checksum too short
+		"A1G7SGD8",      // checksum calculated with uppercase prefix
+		"a12UEL5L",      // mixed case
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxx", // bad checksum
+	}
+	for _, s := range invalid {
+		_, _, err := bech32Decode(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestBech32RoundTrip(t *testing.T) {
+	payload := []byte{0x00, 0x14, 0x75, 0x1e, 0x76, 0xe8, 0x19, 0x91, 0x96, 0xd4}
+
+	data, err := convertBits(payload, 8, 5, true)
+	assert.NoError(t, err)
+
+	hrp, decoded, err := bech32Decode(bech32Encode("bs", data))
+	assert.NoError(t, err)
+	assert.Equal(t, "bs", hrp)
+
+	regrouped, err := convertBits(decoded, 5, 8, false)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, regrouped)
+}
@@ -0,0 +1,113 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// HardenedOffset is added to a child index to mark it as hardened.
+	// SLIP-0010 only defines hardened derivation for ed25519.
+	HardenedOffset uint32 = 0x80000000
+
+	chainCodeLen = 32
+	masterSecret = "ed25519 seed"
+)
+
+// ExtendedKey is a node in a SLIP-0010 ed25519 derivation tree.
+type ExtendedKey struct {
+	seed      []byte
+	chainCode []byte
+}
+
+// NewMasterKey creates the root of the derivation tree from a seed, usually
+// the result of SeedFromMnemonic.
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, fmt.Errorf("invalid seed length %d, must be between 16 and 64 bytes", len(seed))
+	}
+
+	return newExtendedKey([]byte(masterSecret), seed), nil
+}
+
+// Child derives the hardened child at the given index. Indexes below
+// HardenedOffset are hardened implicitly.
+func (k *ExtendedKey) Child(index uint32) *ExtendedKey {
+	if index < HardenedOffset {
+		index += HardenedOffset
+	}
+
+	data := make([]byte, 0, 1+seedLen+4)
+	data = append(data, 0x00)
+	data = append(data, k.seed...)
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	return newExtendedKey(k.chainCode, data)
+}
+
+// Derive walks a path like "m/44'/1337'/0'" starting at k, which must be the
+// master key.
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	indexes, err := ParseDerivationPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := k
+	for _, index := range indexes {
+		key = key.Child(index)
+	}
+
+	return key, nil
+}
+
+// PrivateKey returns the signing key of this node.
+func (k *ExtendedKey) PrivateKey() *PrivateKey {
+	return MustCreatePrivateKeyFromSeed(k.seed)
+}
+
+func (k *ExtendedKey) ChainCode() []byte {
+	return k.chainCode
+}
+
+func newExtendedKey(key, data []byte) *ExtendedKey {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	return &ExtendedKey{
+		seed:      sum[:seedLen],
+		chainCode: sum[seedLen : seedLen+chainCodeLen],
+	}
+}
+
+// ParseDerivationPath parses a path like "m/44'/1337'/0'" into hardened child
+// indexes. Since ed25519 only supports hardened derivation every segment must
+// be marked with ' or h.
+func ParseDerivationPath(path string) ([]uint32, error) {
+	segments := strings.Split(strings.TrimSpace(path), "/")
+	if len(segments) == 0 || segments[0] != "m" {
+		return nil, fmt.Errorf("invalid derivation path %q, must start with m", path)
+	}
+
+	indexes := make([]uint32, 0, len(segments)-1)
+	for _, segment := range segments[1:] {
+		trimmed := strings.TrimRight(segment, "'h")
+		if len(trimmed) != len(segment)-1 {
+			return nil, fmt.Errorf("invalid derivation path %q, segment %q is not hardened", path, segment)
+		}
+
+		index, err := strconv.ParseUint(trimmed, 10, 32)
+		if err != nil || uint32(index) >= HardenedOffset {
+			return nil, fmt.Errorf("invalid derivation path %q, bad index %q", path, segment)
+		}
+
+		indexes = append(indexes, uint32(index)+HardenedOffset)
+	}
+
+	return indexes, nil
+}
@@ -0,0 +1,38 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test vector 1 for ed25519 from the SLIP-0010 specification
+func TestExtendedKeyDerive(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+
+	master, err := NewMasterKey(seed)
+	assert.NoError(t, err)
+	assert.Equal(t, "90046a93de5380a72b5e45010748567d5ea02bbf6522f979e05c0d8d8ca9fffb", hex.EncodeToString(master.ChainCode()))
+	assert.Equal(t, "2b4be7f19ee27bbf30c667b642d5f4aa69fd169872f8fc3059c08ebae2eb19e7", hex.EncodeToString(master.PrivateKey().Bytes()[:seedLen]))
+
+	child, err := master.Derive("m/0'")
+	assert.NoError(t, err)
+	assert.Equal(t, "8b59aa11380b624e81507a27fedda59fea6d0b779a778918a2fd3590e16e9c69", hex.EncodeToString(child.ChainCode()))
+	assert.Equal(t, "68e0fe46dfb67e368c75379acec591dad19df3cde26e63b93a8e704f1dade7a3", hex.EncodeToString(child.PrivateKey().Bytes()[:seedLen]))
+
+	// Unhardened indexes are hardened implicitly
+	assert.Equal(t, child.PrivateKey().Bytes(), master.Child(0).PrivateKey().Bytes())
+}
+
+func TestParseDerivationPath(t *testing.T) {
+	indexes, err := ParseDerivationPath("m/44'/1337h/0'")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{44 + HardenedOffset, 1337 + HardenedOffset, HardenedOffset}, indexes)
+
+	_, err = ParseDerivationPath("m/44'/0")
+	assert.Error(t, err)
+
+	_, err = ParseDerivationPath("44'/0'")
+	assert.Error(t, err)
+}
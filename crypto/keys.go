@@ -1,8 +1,10 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -13,29 +15,48 @@ const (
 	signatureLen = 64
 	pubKeyLen    = 32
 	seedLen      = 32
-	addressLen   = 20
+	// addressLen is the version byte followed by the 20 byte hash, legacy
+	// addresses are the hash alone
+	addressLen     = 21
+	addressHashLen = 20
 )
 
 type PrivateKey struct {
 	key ed25519.PrivateKey
 }
 
-func MustCreatePrivateKeyFromString(s string) *PrivateKey {
+func CreatePrivateKeyFromString(s string) (*PrivateKey, error) {
 	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return CreatePrivateKeyFromSeed(b)
+}
+
+func MustCreatePrivateKeyFromString(s string) *PrivateKey {
+	pk, err := CreatePrivateKeyFromString(s)
 	if err != nil {
 		panic(err)
 	}
-	return MustCreatePrivateKeyFromSeed(b)
+	return pk
 }
 
-func MustCreatePrivateKeyFromSeed(seed []byte) *PrivateKey {
+func CreatePrivateKeyFromSeed(seed []byte) (*PrivateKey, error) {
 	if len(seed) != seedLen {
-		panic(fmt.Sprintf("invalid seed lenght, must be %d", seedLen))
+		return nil, fmt.Errorf("invalid seed length %d, must be %d", len(seed), seedLen)
 	}
 
 	return &PrivateKey{
 		key: ed25519.NewKeyFromSeed(seed),
+	}, nil
+}
+
+func MustCreatePrivateKeyFromSeed(seed []byte) *PrivateKey {
+	pk, err := CreatePrivateKeyFromSeed(seed)
+	if err != nil {
+		panic(err)
 	}
+	return pk
 }
 
 func MustGeneratePrivateKey() *PrivateKey {
@@ -70,19 +91,61 @@ type PublicKey struct {
 	key ed25519.PublicKey
 }
 
-func PublicKeyFromBytes(b []byte) *PublicKey {
+func PublicKeyFromBytes(b []byte) (*PublicKey, error) {
 	if len(b) != pubKeyLen {
-		panic(fmt.Sprintf("bytes length not equal to %d", pubKeyLen))
+		return nil, fmt.Errorf("invalid public key length %d, must be %d", len(b), pubKeyLen)
 	}
 	return &PublicKey{
 		key: ed25519.PublicKey(b),
+	}, nil
+}
+
+func MustPublicKeyFromBytes(b []byte) *PublicKey {
+	pubKey, err := PublicKeyFromBytes(b)
+	if err != nil {
+		panic(err)
 	}
+	return pubKey
 }
 
+// Address returns the address of the public key using the current address version
 func (p *PublicKey) Address() Address {
-	return Address{
-		value: p.key[len(p.key)-addressLen:],
+	a, _ := p.AddressWithVersion(CurrentAddressVersion)
+	return a
+}
+
+// AddressWithVersion derives the address of the public key using the scheme
+// of the given address version.
+func (p *PublicKey) AddressWithVersion(version AddressVersion) (Address, error) {
+	var hash []byte
+
+	switch version {
+	case AddressVersionLegacy:
+		value := make([]byte, addressHashLen)
+		copy(value, p.key[len(p.key)-addressHashLen:])
+		return Address{value: value}, nil
+	case AddressVersionSHA256:
+		sum := sha256.Sum256(p.key)
+		hash = sum[:addressHashLen]
+	default:
+		return Address{}, fmt.Errorf("unknown address version %d", version)
 	}
+
+	value := make([]byte, 0, addressLen)
+	value = append(value, byte(version))
+	value = append(value, hash...)
+
+	return Address{value: value}, nil
+}
+
+// Owns reports whether the address was derived from this public key, with
+// whichever scheme the version of the address specifies.
+func (p *PublicKey) Owns(a Address) bool {
+	derived, err := p.AddressWithVersion(a.Version())
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(derived.value, a.value)
 }
 
 func (p *PublicKey) Bytes() []byte {
@@ -93,13 +156,21 @@ type Signature struct {
 	value []byte
 }
 
-func SignatureFromBytes(b []byte) *Signature {
+func SignatureFromBytes(b []byte) (*Signature, error) {
 	if len(b) != signatureLen {
-		panic(fmt.Sprintf("bytes length not equal to %d", signatureLen))
+		return nil, fmt.Errorf("invalid signature length %d, must be %d", len(b), signatureLen)
 	}
 	return &Signature{
 		value: b,
+	}, nil
+}
+
+func MustSignatureFromBytes(b []byte) *Signature {
+	sig, err := SignatureFromBytes(b)
+	if err != nil {
+		panic(err)
 	}
+	return sig
 }
 
 func (s *Signature) Bytes() []byte {
@@ -109,15 +180,3 @@ func (s *Signature) Bytes() []byte {
 func (s *Signature) Verify(pubKey *PublicKey, msg []byte) bool {
 	return ed25519.Verify(pubKey.key, msg, s.value)
 }
-
-type Address struct {
-	value []byte
-}
-
-func (a Address) Bytes() []byte {
-	return a.value
-}
-
-func (a Address) String() string {
-	return hex.EncodeToString(a.value)
-}
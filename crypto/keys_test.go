@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"encoding/hex"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -20,7 +21,8 @@ func TestMustCreatePrivateKeyFromString(t *testing.T) {
 	var (
 		seedStr    = "e22f87e4add94968d0dc7dd9be75968ef4b2cb5686ae6641fddecfb6db8cb893"
 		privKey    = MustCreatePrivateKeyFromString(seedStr)
-		addressStr = "744c5a4919736642ff4a7b3529a174244428560e"
+		addressStr = "013657440b42694d9d9aa8229513626c2972e2211c"
+		legacyStr  = "744c5a4919736642ff4a7b3529a174244428560e"
 	)
 
 	assert.Equal(t, privKeyLen, len(privKey.Bytes()))
@@ -28,6 +30,17 @@ func TestMustCreatePrivateKeyFromString(t *testing.T) {
 	address := privKey.Public().Address()
 
 	assert.Equal(t, addressStr, address.String())
+
+	legacy, err := privKey.Public().AddressWithVersion(AddressVersionLegacy)
+	assert.NoError(t, err)
+	assert.Equal(t, legacyStr, legacy.String())
+
+	old, err := hex.DecodeString(legacyStr)
+	assert.NoError(t, err)
+	parsed, err := AddressFromBytes(old)
+	assert.NoError(t, err)
+	assert.Equal(t, AddressVersionLegacy, parsed.Version())
+	assert.True(t, privKey.Public().Owns(parsed))
 }
 
 func TestPrivateKeySign(t *testing.T) {
@@ -58,5 +71,42 @@ func TestPublicKeyToAddress(t *testing.T) {
 	address := pubkey.Address()
 
 	assert.Equal(t, addressLen, len(address.Bytes()))
+	assert.Equal(t, CurrentAddressVersion, address.Version())
+
+	_, err := pubkey.AddressWithVersion(AddressVersion(0xff))
+	assert.Error(t, err)
+}
+
+func TestPublicKeyOwns(t *testing.T) {
+	var (
+		pubKey      = MustGeneratePrivateKey().Public()
+		otherPubKey = MustGeneratePrivateKey().Public()
+	)
+
+	legacy, err := pubKey.AddressWithVersion(AddressVersionLegacy)
+	assert.NoError(t, err)
+
+	assert.True(t, pubKey.Owns(pubKey.Address()))
+	assert.True(t, pubKey.Owns(legacy))
+	assert.False(t, otherPubKey.Owns(pubKey.Address()))
+	assert.False(t, otherPubKey.Owns(legacy))
+	assert.False(t, pubKey.Owns(Address{}))
+}
+
+func TestFromBytesRejectsInvalidLength(t *testing.T) {
+	_, err := PublicKeyFromBytes(make([]byte, pubKeyLen-1))
+	assert.Error(t, err)
+
+	_, err = SignatureFromBytes(make([]byte, 3))
+	assert.Error(t, err)
+
+	_, err = CreatePrivateKeyFromSeed(nil)
+	assert.Error(t, err)
+
+	_, err = CreatePrivateKeyFromString("not hex")
+	assert.Error(t, err)
 
+	pubKey, err := PublicKeyFromBytes(MustGeneratePrivateKey().Public().Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, pubKeyLen, len(pubKey.Bytes()))
 }
@@ -0,0 +1,176 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	mnemonicSeedLen    = 64
+	mnemonicIterations = 2048
+	bitsPerWord        = 11
+)
+
+//go:embed wordlist_english.txt
+var englishWords string
+
+// wordList is the BIP-39 english word list, wordIndex maps every word back to
+// its position in that list.
+var (
+	wordList  = strings.Split(strings.TrimSpace(englishWords), "\n")
+	wordIndex = func() map[string]int {
+		m := make(map[string]int, len(wordList))
+		for i, w := range wordList {
+			m[w] = i
+		}
+		return m
+	}()
+)
+
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+// NewEntropy returns bitSize bits of random entropy to build a mnemonic from.
+// bitSize must be a multiple of 32 between 128 and 256.
+func NewEntropy(bitSize int) ([]byte, error) {
+	if err := validateEntropyBits(bitSize); err != nil {
+		return nil, err
+	}
+
+	entropy := make([]byte, bitSize/8)
+	if _, err := io.ReadFull(rand.Reader, entropy); err != nil {
+		return nil, err
+	}
+
+	return entropy, nil
+}
+
+// MustGenerateMnemonic returns a random 24 word mnemonic or panics if the
+// system's random source fails.
+func MustGenerateMnemonic() string {
+	entropy, err := NewEntropy(256)
+	if err != nil {
+		panic(err)
+	}
+
+	mnemonic, err := NewMnemonic(entropy)
+	if err != nil {
+		panic(err)
+	}
+
+	return mnemonic
+}
+
+// NewMnemonic encodes the given entropy as a BIP-39 mnemonic sentence.
+func NewMnemonic(entropy []byte) (string, error) {
+	bitSize := len(entropy) * 8
+	if err := validateEntropyBits(bitSize); err != nil {
+		return "", err
+	}
+
+	// The checksum is the first ENT/32 bits of the SHA256 of the entropy
+	var (
+		checksum     = sha256.Sum256(entropy)
+		checksumBits = bitSize / 32
+		wordCount    = (bitSize + checksumBits) / bitsPerWord
+		words        = make([]string, wordCount)
+	)
+
+	data := append(append([]byte{}, entropy...), checksum[:]...)
+	for i := range words {
+		words[i] = wordList[readBits(data, i*bitsPerWord, bitsPerWord)]
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// EntropyFromMnemonic decodes a mnemonic sentence back into its entropy,
+// verifying the words and the checksum.
+func EntropyFromMnemonic(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words)%3 != 0 || len(words) < 12 || len(words) > 24 {
+		return nil, fmt.Errorf("%w: unexpected word count %d", ErrInvalidMnemonic, len(words))
+	}
+
+	var (
+		totalBits    = len(words) * bitsPerWord
+		checksumBits = totalBits / 33
+		entropyBits  = totalBits - checksumBits
+		data         = make([]byte, (totalBits+7)/8)
+	)
+
+	for i, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrInvalidMnemonic, word)
+		}
+		writeBits(data, i*bitsPerWord, bitsPerWord, index)
+	}
+
+	entropy := data[:entropyBits/8]
+	checksum := sha256.Sum256(entropy)
+	if readBits(data, entropyBits, checksumBits) != readBits(checksum[:], 0, checksumBits) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidMnemonic)
+	}
+
+	return entropy, nil
+}
+
+// ValidateMnemonic returns an error if the mnemonic is not a valid BIP-39 sentence.
+func ValidateMnemonic(mnemonic string) error {
+	_, err := EntropyFromMnemonic(mnemonic)
+	return err
+}
+
+// SeedFromMnemonic derives the 64 byte BIP-39 seed of the mnemonic, protected
+// with an optional passphrase. The seed is the input for NewMasterKey.
+func SeedFromMnemonic(mnemonic, passphrase string) ([]byte, error) {
+	if err := ValidateMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+
+	var (
+		password = norm.NFKD.String(strings.Join(strings.Fields(mnemonic), " "))
+		salt     = norm.NFKD.String("mnemonic" + passphrase)
+	)
+
+	return pbkdf2.Key([]byte(password), []byte(salt), mnemonicIterations, mnemonicSeedLen, sha512.New), nil
+}
+
+func validateEntropyBits(bitSize int) error {
+	if bitSize%32 != 0 || bitSize < 128 || bitSize > 256 {
+		return fmt.Errorf("invalid entropy size %d, must be a multiple of 32 between 128 and 256", bitSize)
+	}
+	return nil
+}
+
+// readBits reads n bits from data starting at bit offset, most significant bit first.
+func readBits(data []byte, offset, n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		bit := offset + i
+		v <<= 1
+		if data[bit/8]&(0x80>>(bit%8)) != 0 {
+			v |= 1
+		}
+	}
+	return v
+}
+
+// writeBits writes the n lowest bits of v into data starting at bit offset.
+func writeBits(data []byte, offset, n, v int) {
+	for i := 0; i < n; i++ {
+		if v&(1<<(n-1-i)) != 0 {
+			bit := offset + i
+			data[bit/8] |= 0x80 >> (bit % 8)
+		}
+	}
+}
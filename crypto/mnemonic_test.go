@@ -0,0 +1,54 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMnemonic(t *testing.T) {
+	var (
+		entropy, _ = hex.DecodeString("00000000000000000000000000000000")
+		expected   = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	)
+
+	mnemonic, err := NewMnemonic(entropy)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, mnemonic)
+
+	decoded, err := EntropyFromMnemonic(mnemonic)
+	assert.NoError(t, err)
+	assert.Equal(t, entropy, decoded)
+
+	_, err = NewMnemonic(entropy[:15])
+	assert.Error(t, err)
+}
+
+func TestMustGenerateMnemonic(t *testing.T) {
+	mnemonic := MustGenerateMnemonic()
+
+	assert.Equal(t, 24, len(strings.Fields(mnemonic)))
+	assert.NoError(t, ValidateMnemonic(mnemonic))
+}
+
+func TestValidateMnemonic(t *testing.T) {
+	// Wrong checksum word
+	assert.ErrorIs(t, ValidateMnemonic("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"), ErrInvalidMnemonic)
+	// Unknown word
+	assert.ErrorIs(t, ValidateMnemonic("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon blockstra"), ErrInvalidMnemonic)
+	// Wrong word count
+	assert.ErrorIs(t, ValidateMnemonic("abandon about"), ErrInvalidMnemonic)
+}
+
+func TestSeedFromMnemonic(t *testing.T) {
+	var (
+		mnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+		expected = "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+	)
+
+	seed, err := SeedFromMnemonic(mnemonic, "TREZOR")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, hex.EncodeToString(seed))
+}
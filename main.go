@@ -3,48 +3,104 @@ package main
 import (
 	"context"
 	"log"
+	"os"
+	"os/signal"
 	"time"
 
+	"github.com/webstradev/blockstra/client"
 	"github.com/webstradev/blockstra/crypto"
 	"github.com/webstradev/blockstra/node"
 	"github.com/webstradev/blockstra/proto"
-	"github.com/webstradev/blockstra/util"
+	"github.com/webstradev/blockstra/types"
 	"go.uber.org/zap"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const vers = "blockstra-0.1"
 
+var (
+	// faucetKey owns the genesis allocation the demo transactions spend from
+	faucetKey = crypto.MustGeneratePrivateKey()
+	// every demo node is a validator
+	validatorKeys = []*crypto.PrivateKey{
+		crypto.MustGeneratePrivateKey(),
+		crypto.MustGeneratePrivateKey(),
+		crypto.MustGeneratePrivateKey(),
+	}
+)
+
+var genesis = &types.Genesis{
+	Timestamp: time.Now().UnixNano(),
+	Allocations: []types.GenesisAllocation{
+		{Address: faucetKey.Public().Address(), Amount: 1_000_000},
+	},
+	Validators: []*crypto.PublicKey{
+		validatorKeys[0].Public(),
+		validatorKeys[1].Public(),
+		validatorKeys[2].Public(),
+	},
+}
+
+// faucet is the unspent output of the faucet the next demo transaction spends
+var faucet = &proto.OutPoint{
+	TxHash: types.MustHashTransaction(genesis.Block().Transactions[0]),
+	Index:  0,
+}
+
 func main() {
+	// The nodes stop on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	cfg := node.ServerConfig{
 		Version:    vers,
 		ListenAddr: ":3000",
-		PrivateKey: crypto.MustGeneratePrivateKey(),
+		PrivateKey: validatorKeys[0],
+		Genesis:    genesis,
 	}
-	makeNode(cfg, []string{})
+	nodes := []*node.Node{makeNode(ctx, cfg, []string{})}
 
 	time.Sleep(50 * time.Millisecond)
 	cfg = node.ServerConfig{
 		Version:    vers,
 		ListenAddr: ":4000",
+		PrivateKey: validatorKeys[1],
+		Genesis:    genesis,
 	}
-	makeNode(cfg, []string{":3000"})
+	nodes = append(nodes, makeNode(ctx, cfg, []string{":3000"}))
 
 	time.Sleep(50 * time.Millisecond)
 	cfg = node.ServerConfig{
 		Version:    vers,
 		ListenAddr: ":5000",
+		PrivateKey: validatorKeys[2],
+		Genesis:    genesis,
 	}
-	makeNode(cfg, []string{":4000"})
+	nodes = append(nodes, makeNode(ctx, cfg, []string{":4000"}))
+
+	// The demo transactions are sent over the same connection
+	pool := client.NewPool()
+	defer pool.Close()
 
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
 	for {
-		time.Sleep(2 * time.Second)
-		makeTransaction()
+		select {
+		case <-ticker.C:
+			makeTransaction(pool)
+		case <-ctx.Done():
+			for _, n := range nodes {
+				if err := n.Stop(); err != nil {
+					log.Println(err)
+				}
+			}
+			return
+		}
 	}
 }
 
-func makeNode(cfg node.ServerConfig, bootstrapNodes []string) *node.Node {
+func makeNode(ctx context.Context, cfg node.ServerConfig, bootstrapNodes []string) *node.Node {
 	loggerConfig := zap.NewDevelopmentConfig()
 	loggerConfig.EncoderConfig.TimeKey = "timestamp"
 
@@ -53,39 +109,59 @@ func makeNode(cfg node.ServerConfig, bootstrapNodes []string) *node.Node {
 		log.Fatal(err)
 	}
 	n := node.New(cfg, zap.Sugar(), bootstrapNodes)
-	go n.Start()
+	go func() {
+		if err := n.Start(ctx); err != nil {
+			log.Fatal(err)
+		}
+	}()
 	return n
 }
 
-func makeTransaction() {
-	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
-	client, err := grpc.Dial(":3000", opts...)
+// makeTransaction pays 99 to a random address out of the faucet output and
+// sends the change back to the faucet. Until the previous transaction is
+// included in a block the faucet output doesn't exist yet and nothing is sent.
+func makeTransaction(pool *client.Pool) {
+	c, err := pool.Node(":3000")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	c := proto.NewNodeClient(client)
+	output, err := c.GetOutput(context.Background(), faucet)
+	if status.Code(err) == codes.NotFound {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	privKey := crypto.MustGeneratePrivateKey()
+	toPrivKey := crypto.MustGeneratePrivateKey()
 	tx := &proto.Transaction{
 		Version: 1,
 		Inputs: []*proto.TxInput{
 			{
-				PrevTxHash:   util.RandomHash(),
-				PrevOutIndex: 0,
-				PublicKey:    privKey.Public().Bytes(),
+				PrevTxHash:   faucet.TxHash,
+				PrevOutIndex: faucet.Index,
+				PublicKey:    faucetKey.Public().Bytes(),
 			},
 		},
 		Outputs: []*proto.TxOutput{
 			{
 				Amount:  99,
-				Address: privKey.Public().Address().Bytes(),
+				Address: toPrivKey.Public().Address().Bytes(),
+			},
+			{
+				Amount:  output.Amount - 99,
+				Address: faucetKey.Public().Address().Bytes(),
 			},
 		},
 	}
 
+	tx.Inputs[0].Signature = types.MustSignTransaction(faucetKey, tx).Bytes()
+
 	_, err = c.HandleTransaction(context.Background(), tx)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	faucet = &proto.OutPoint{TxHash: types.MustHashTransaction(tx), Index: 1}
 }
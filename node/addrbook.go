@@ -0,0 +1,244 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The address book holds at most maxAddrBookSize addresses. An address that
+// failed maxAddrAttempts dials in a row and wasn't connected to within
+// addrExpiry is dropped.
+const (
+	maxAddrBookSize = 1000
+	maxAddrAttempts = 10
+	addrExpiry      = time.Hour * 24 * 7
+)
+
+// KnownAddr is the listen address of a node learned from a peer along with
+// the outcome of dialing it
+type KnownAddr struct {
+	Addr string `json:"addr"`
+	// LastSeen is when a peer last told us about the address or we were
+	// connected to it
+	LastSeen    time.Time `json:"lastSeen"`
+	LastAttempt time.Time `json:"lastAttempt"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	// Attempts counts the failed dials since the last success
+	Attempts int `json:"attempts"`
+}
+
+// AddrBook holds the addresses of the nodes of the network. With a path the
+// addresses are stored in a file and survive restarts.
+type AddrBook struct {
+	lock  sync.RWMutex
+	path  string
+	addrs map[string]*KnownAddr
+}
+
+func NewAddrBook(path string) *AddrBook {
+	return &AddrBook{
+		path:  path,
+		addrs: map[string]*KnownAddr{},
+	}
+}
+
+// Load reads the addresses stored in the file of the book, a missing file
+// holds no addresses
+func (b *AddrBook) Load() error {
+	if b.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var addrs []*KnownAddr
+	if err := json.Unmarshal(data, &addrs); err != nil {
+		return err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, addr := range addrs {
+		b.addrs[addr.Addr] = addr
+	}
+	return nil
+}
+
+// Save writes the addresses to the file of the book
+func (b *AddrBook) Save() error {
+	if b.path == "" {
+		return nil
+	}
+
+	b.lock.RLock()
+	addrs := b.list()
+	data, err := json.MarshalIndent(addrs, "", "  ")
+	b.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return writeFile(b.path, data)
+}
+
+// Add adds addresses a peer told us about, addresses beyond the size of the
+// book are ignored
+func (b *AddrBook) Add(addrs ...string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	for _, addr := range addrs {
+		if addr == "" {
+			continue
+		}
+		if known, ok := b.addrs[addr]; ok {
+			known.LastSeen = now
+			continue
+		}
+		if len(b.addrs) >= maxAddrBookSize {
+			continue
+		}
+		b.addrs[addr] = &KnownAddr{Addr: addr, LastSeen: now}
+	}
+}
+
+// Attempt records a failed dial of the address and drops addresses that
+// keep failing
+func (b *AddrBook) Attempt(addr string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	known, ok := b.addrs[addr]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	known.LastAttempt = now
+	known.Attempts++
+	if known.Attempts >= maxAddrAttempts && now.Sub(known.LastSuccess) > addrExpiry {
+		delete(b.addrs, addr)
+	}
+}
+
+// Good records a connection to the address
+func (b *AddrBook) Good(addr string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	known, ok := b.addrs[addr]
+	if !ok {
+		if len(b.addrs) >= maxAddrBookSize {
+			return
+		}
+		known = &KnownAddr{Addr: addr}
+		b.addrs[addr] = known
+	}
+
+	now := time.Now()
+	known.LastSeen = now
+	known.LastAttempt = now
+	known.LastSuccess = now
+	known.Attempts = 0
+}
+
+// Remove drops an address from the book
+func (b *AddrBook) Remove(addr string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.addrs, addr)
+}
+
+// Len returns the number of addresses in the book
+func (b *AddrBook) Len() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return len(b.addrs)
+}
+
+// List returns up to max addresses, the most recently seen first
+func (b *AddrBook) List(max int) []string {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	addrs := []string{}
+	for _, known := range b.list() {
+		if len(addrs) == max {
+			break
+		}
+		addrs = append(addrs, known.Addr)
+	}
+	return addrs
+}
+
+// Select returns up to max addresses to dial that the filter accepts. An
+// address is only dialed again once the reconnect backoff of its failed
+// attempts passed. Addresses connected to before come first, then the ones
+// with the fewest failed attempts.
+func (b *AddrBook) Select(max int, filter func(addr string) bool) []string {
+	b.lock.RLock()
+	known := b.list()
+	b.lock.RUnlock()
+
+	// The filter runs without the lock, it may take locks of its own
+	now := time.Now()
+	candidates := []*KnownAddr{}
+	for _, known := range known {
+		if known.Attempts > 0 && now.Sub(known.LastAttempt) < reconnectBackoff(known.Attempts-1) {
+			continue
+		}
+		if filter(known.Addr) {
+			candidates = append(candidates, known)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if !a.LastSuccess.Equal(b.LastSuccess) {
+			return a.LastSuccess.After(b.LastSuccess)
+		}
+		if a.Attempts != b.Attempts {
+			return a.Attempts < b.Attempts
+		}
+		return a.Addr < b.Addr
+	})
+
+	addrs := []string{}
+	for _, known := range candidates {
+		if len(addrs) == max {
+			break
+		}
+		addrs = append(addrs, known.Addr)
+	}
+	return addrs
+}
+
+// list returns copies of the addresses, the most recently seen first. The
+// caller has to hold lock.
+func (b *AddrBook) list() []*KnownAddr {
+	addrs := make([]*KnownAddr, 0, len(b.addrs))
+	for _, known := range b.addrs {
+		copied := *known
+		addrs = append(addrs, &copied)
+	}
+
+	sort.Slice(addrs, func(i, j int) bool {
+		if !addrs[i].LastSeen.Equal(addrs[j].LastSeen) {
+			return addrs[i].LastSeen.After(addrs[j].LastSeen)
+		}
+		return addrs[i].Addr < addrs[j].Addr
+	})
+	return addrs
+}
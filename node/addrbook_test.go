@@ -0,0 +1,69 @@
+package node
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddrBook(t *testing.T) {
+	var (
+		path = filepath.Join(t.TempDir(), "addrs.json")
+		book = NewAddrBook(path)
+	)
+	assert.NoError(t, book.Load())
+
+	book.Add("10.0.0.1:3000", "10.0.0.2:3000", "")
+	book.Good("10.0.0.3:3000")
+	assert.Equal(t, 3, book.Len())
+	assert.Equal(t, []string{"10.0.0.3:3000"}, book.List(1))
+
+	// The book survives a restart
+	assert.NoError(t, book.Save())
+	loaded := NewAddrBook(path)
+	assert.NoError(t, loaded.Load())
+	assert.Equal(t, book.List(10), loaded.List(10))
+
+	loaded.Remove("10.0.0.3:3000")
+	assert.Equal(t, 2, loaded.Len())
+}
+
+func TestAddrBookSelect(t *testing.T) {
+	book := NewAddrBook("")
+	book.Add("10.0.0.1:3000", "10.0.0.2:3000", "10.0.0.3:3000")
+	book.Good("10.0.0.3:3000")
+	book.Attempt("10.0.0.1:3000")
+
+	all := func(string) bool { return true }
+
+	// Addresses connected to before come first, failed ones wait for their
+	// backoff
+	assert.Equal(t, []string{"10.0.0.3:3000", "10.0.0.2:3000"}, book.Select(10, all))
+	assert.Equal(t, []string{"10.0.0.3:3000"}, book.Select(1, all))
+	assert.Equal(t, []string{"10.0.0.2:3000"}, book.Select(10, func(addr string) bool {
+		return addr != "10.0.0.3:3000"
+	}))
+
+	book.addrs["10.0.0.1:3000"].LastAttempt = time.Now().Add(-minReconnectBackoff)
+	assert.Equal(t, []string{"10.0.0.3:3000", "10.0.0.2:3000", "10.0.0.1:3000"}, book.Select(10, all))
+}
+
+func TestAddrBookDropsFailingAddrs(t *testing.T) {
+	book := NewAddrBook("")
+	book.Add("10.0.0.1:3000")
+
+	for i := 0; i < maxAddrAttempts; i++ {
+		assert.Equal(t, 1, book.Len())
+		book.Attempt("10.0.0.1:3000")
+	}
+	assert.Equal(t, 0, book.Len())
+
+	// Addresses connected to recently are kept
+	book.Good("10.0.0.2:3000")
+	for i := 0; i < maxAddrAttempts; i++ {
+		book.Attempt("10.0.0.2:3000")
+	}
+	assert.Equal(t, 1, book.Len())
+}
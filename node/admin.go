@@ -0,0 +1,85 @@
+package node
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/webstradev/blockstra/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// adminServer serves the Admin RPCs of a node
+type adminServer struct {
+	node *Node
+
+	proto.UnimplementedAdminServer
+}
+
+// ListPeers returns the connected peers ordered by listen address and the
+// banned hosts
+func (s *adminServer) ListPeers(ctx context.Context, req *proto.ListPeersRequest) (*proto.Peers, error) {
+	n := s.node
+
+	n.peerLock.RLock()
+	peers := make([]*proto.PeerInfo, 0, len(n.peers))
+	for id, p := range n.peers {
+		info := &proto.PeerInfo{
+			NodeId:      id,
+			ListenAddr:  p.version.ListenAddr,
+			Inbound:     p.inbound,
+			ConnectedAt: p.connectedAt.UnixNano(),
+		}
+		if p.remoteAddr != "" {
+			info.Score = int32(n.scores.Get(p.remoteAddr))
+		}
+		peers = append(peers, info)
+	}
+	n.peerLock.RUnlock()
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ListenAddr < peers[j].ListenAddr
+	})
+
+	bans := []*proto.Ban{}
+	for _, ban := range n.banList.List() {
+		bans = append(bans, &proto.Ban{Host: ban.Host, Until: ban.Until.UnixNano()})
+	}
+
+	return &proto.Peers{Peers: peers, Bans: bans}, nil
+}
+
+// BanPeer bans the host of a peer and disconnects from it
+func (s *adminServer) BanPeer(ctx context.Context, req *proto.BanRequest) (*proto.Ack, error) {
+	if req.Addr == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing address")
+	}
+	if req.Duration < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "negative ban duration %d", req.Duration)
+	}
+
+	duration := time.Duration(req.Duration)
+	if duration == 0 {
+		duration = s.node.BanDuration
+	}
+
+	if err := s.node.ban(hostOf(req.Addr), duration); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to store ban: %v", err)
+	}
+
+	return &proto.Ack{}, nil
+}
+
+// UnbanPeer lifts the ban of the host of a peer
+func (s *adminServer) UnbanPeer(ctx context.Context, req *proto.UnbanRequest) (*proto.Ack, error) {
+	banned, err := s.node.banList.Unban(hostOf(req.Addr))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to store ban list: %v", err)
+	}
+	if !banned {
+		return nil, status.Errorf(codes.NotFound, "%s is not banned", req.Addr)
+	}
+
+	return &proto.Ack{}, nil
+}
@@ -1,14 +1,25 @@
 package node
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/big"
+	"sort"
 	"sync"
 
+	pb "github.com/golang/protobuf/proto"
+	"github.com/webstradev/blockstra/consensus"
+	"github.com/webstradev/blockstra/crypto"
 	"github.com/webstradev/blockstra/proto"
 	"github.com/webstradev/blockstra/types"
 )
 
+// ErrUnknownParent is returned by AddBlock for blocks that don't build on the
+// chain, the blocks before them may have been missed
+var ErrUnknownParent = errors.New("unknown parent")
+
 type HeaderList struct {
 	lock    sync.RWMutex
 	headers []*proto.Header
@@ -27,11 +38,32 @@ func (l *HeaderList) Add(header *proto.Header) {
 }
 
 func (l *HeaderList) Get(index int) *proto.Header {
-	if index > l.Height() {
+	header, ok := l.Lookup(index)
+	if !ok {
 		panic("index to high")
 	}
 
-	return l.headers[index]
+	return header
+}
+
+// Lookup returns the header at index and whether it exists
+func (l *HeaderList) Lookup(index int) (*proto.Header, bool) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if index < 0 || index >= len(l.headers) {
+		return nil, false
+	}
+	return l.headers[index], true
+}
+
+// Truncate removes the headers from the given height on
+func (l *HeaderList) Truncate(height int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if height < len(l.headers) {
+		l.headers = l.headers[:height]
+	}
 }
 
 func (l *HeaderList) Height() int {
@@ -45,14 +77,60 @@ func (l *HeaderList) Len() int {
 }
 
 type Chain struct {
+	// lock serializes adding blocks with reading the unspent outputs
+	lock       sync.RWMutex
 	blockStore BlockStorer
+	utxoStore  UTXOStorer
 	headers    *HeaderList
+	engine     consensus.Engine
+	// undo holds the outputs spent by every block of the chain by block hash,
+	// to revert the block when the chain switches to a competing block
+	undo map[string][]*UTXO
+	// activity counts the outputs of the chain paid to every address by hex
+	// encoded address, spent or not. Multisig outputs count for the
+	// addresses of all their keys, locking scripts don't name an address.
+	activity map[string]int
+	// totalDifficulty holds the sum of the difficulties of every known block
+	// and the blocks before it by block hash. The chain follows the branch
+	// with the most work.
+	totalDifficulty map[string]*big.Int
+	// finalized is the height of the last final block, the genesis block is
+	// final by definition
+	finalized int
+
+	// validatorLock guards slashed and epochs, it is separate from lock so
+	// the consensus engine can look up the validators while a block is added
+	validatorLock sync.RWMutex
+	validators    *consensus.ValidatorSet
+	epochLength   int32
+	// epochs holds the validators bonded at the start of every epoch after
+	// the first one by epoch
+	epochs map[int32][]*crypto.PublicKey
+	// slashed holds the height of the block including the evidence against a
+	// validator by the hex encoded public key of the validator
+	slashed map[string]int32
 }
 
-func NewChain(bs BlockStorer) *Chain {
+// NewChain returns an empty chain. The validators of the genesis block stay
+// validators, the validators bonding stake join them at the start of the
+// next epoch of epochLength blocks.
+func NewChain(bs BlockStorer, us UTXOStorer, engine consensus.Engine, validators *consensus.ValidatorSet, epochLength int32) *Chain {
+	if validators == nil {
+		validators = consensus.NewValidatorSet(nil)
+	}
+
 	return &Chain{
-		blockStore: bs,
-		headers:    NewHeaderlist(),
+		blockStore:      bs,
+		utxoStore:       us,
+		headers:         NewHeaderlist(),
+		engine:          engine,
+		undo:            map[string][]*UTXO{},
+		activity:        map[string]int{},
+		totalDifficulty: map[string]*big.Int{},
+		validators:      validators,
+		epochLength:     epochLength,
+		epochs:          map[int32][]*crypto.PublicKey{},
+		slashed:         map[string]int32{},
 	}
 }
 
@@ -60,12 +138,592 @@ func (c *Chain) Height() int {
 	return c.headers.Height()
 }
 
+// Tip returns the header of the last block of the chain or nil if the chain
+// has no blocks yet
+func (c *Chain) Tip() *proto.Header {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.Height() < 0 {
+		return nil
+	}
+	return c.headers.Get(c.Height())
+}
+
+// HeaderByHeight returns the header of the chain at the given height, it
+// implements consensus.ChainReader. It doesn't take the lock of the chain so
+// the consensus engine can use it while a block is added.
+func (c *Chain) HeaderByHeight(height int32) (*proto.Header, error) {
+	header, ok := c.headers.Lookup(int(height))
+	if !ok {
+		return nil, fmt.Errorf("no header at height %d", height)
+	}
+	return header, nil
+}
+
+// ValidatorsAt returns the validators of the block at the given height: the
+// validators of the genesis block and the validators bonded at the start of
+// its epoch, without the ones slashed by evidence in a block before it. The
+// validators are only known up to the epoch after the tip of the chain. It
+// implements consensus.ChainReader.
+func (c *Chain) ValidatorsAt(height int32) (*consensus.ValidatorSet, error) {
+	c.validatorLock.RLock()
+	defer c.validatorLock.RUnlock()
+
+	keys := c.validators.Validators()
+	if epoch := height / c.epochLength; epoch > 0 {
+		bonded, ok := c.epochs[epoch]
+		if !ok {
+			return nil, fmt.Errorf("validators at height %d are not known yet", height)
+		}
+		keys = append(keys, bonded...)
+	}
+
+	validators := []*crypto.PublicKey{}
+	for _, key := range consensus.NewValidatorSet(keys).Validators() {
+		if slashed, ok := c.slashed[hex.EncodeToString(key.Bytes())]; ok && slashed < height {
+			continue
+		}
+		validators = append(validators, key)
+	}
+
+	return consensus.NewValidatorSet(validators), nil
+}
+
+// bondedValidators returns the validators with at least the minimum stake
+// bonded to them in the unspent outputs of the chain, ordered by key
+func (c *Chain) bondedValidators() ([]*crypto.PublicKey, error) {
+	utxos, err := c.utxoStore.List()
+	if err != nil {
+		return nil, err
+	}
+
+	stakes := map[string]int64{}
+	for _, utxo := range utxos {
+		if types.IsStake(utxo.Output) {
+			stakes[string(utxo.Output.Validator)] += utxo.Output.Amount
+		}
+	}
+
+	bonded := []*crypto.PublicKey{}
+	for validator, stake := range stakes {
+		if stake >= types.MinValidatorStake {
+			bonded = append(bonded, crypto.MustPublicKeyFromBytes([]byte(validator)))
+		}
+	}
+	sort.Slice(bonded, func(i, j int) bool {
+		return bytes.Compare(bonded[i].Bytes(), bonded[j].Bytes()) < 0
+	})
+
+	return bonded, nil
+}
+
+// HasBlock returns whether the block with the given hash is known, either as
+// part of the chain or as a competing block
+func (c *Chain) HasBlock(hash []byte) bool {
+	_, err := c.GetBlockByHash(hash)
+	return err == nil
+}
+
+// AddBlock validates a block and adds it to the chain. The first block is the
+// genesis block, every later block has to pass the rules of the consensus
+// engine and build on a known block. A block extending the tip is appended,
+// a block competing with blocks of the chain that aren't final yet is kept
+// until its branch has more work than the chain or Finalize switches the
+// chain over to it.
 func (c *Chain) AddBlock(block *proto.Block) error {
+	_, err := c.ImportBlock(block)
+	return err
+}
+
+// ImportBlock adds a block like AddBlock and returns the blocks removed from
+// the chain when the branch of the block has more work than the chain. The
+// work of a branch is the sum of the difficulties of its blocks, of branches
+// with the same work the chain keeps the one it had first.
+func (c *Chain) ImportBlock(block *proto.Block) ([]*proto.Block, error) {
+	// validation
+	if err := types.VerifyBlock(block); err != nil {
+		return nil, err
+	}
+
+	hash, err := types.HashBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	key := hex.EncodeToString(hash)
+	difficulty := new(big.Int).SetUint64(block.Header.Difficulty)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// The stored body of a known block is never replaced
+	if _, err := c.blockStore.Get(key); err == nil {
+		return nil, nil
+	}
+
+	if c.Height() < 0 {
+		if block.Header.Height != 0 {
+			return nil, fmt.Errorf("genesis block has height %d", block.Header.Height)
+		}
+		if err := c.connect(newUTXOView(c.utxoStore), block); err != nil {
+			return nil, err
+		}
+		c.totalDifficulty[key] = difficulty
+		return nil, nil
+	}
+
+	parent, err := c.parentOf(block.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.engine.VerifyBlock(branchReader{Chain: c, tip: parent}, block, parent); err != nil {
+		return nil, err
+	}
+
+	parentWork, ok := c.totalDifficulty[hex.EncodeToString(block.Header.PrevHash)]
+	if !ok {
+		return nil, fmt.Errorf("unknown work of the parent of block at height %d", block.Header.Height)
+	}
+	work := new(big.Int).Add(parentWork, difficulty)
+
+	if c.onChain(block.Header.PrevHash, c.Height()) {
+		if err := c.connect(newUTXOView(c.utxoStore), block); err != nil {
+			return nil, err
+		}
+		c.totalDifficulty[key] = work
+		return nil, nil
+	}
+
+	branch, err := c.branchOf(block)
+	if err != nil {
+		return nil, err
+	}
+	if height := int(branch[0].Header.Height); height <= c.finalized {
+		return nil, fmt.Errorf("block conflicts with the finalized block at height %d", height)
+	}
+
+	tipWork := c.totalDifficulty[hex.EncodeToString(types.MustHashHeader(c.headers.Get(c.Height())))]
+	// VerifyBlock tied the body to the header hash, so the block stored is
+	// the one its producer signed. Its transactions are checked against the
+	// state of its branch once the chain switches over to it.
+	if work.Cmp(tipWork) <= 0 {
+		if err := c.blockStore.Put(block); err != nil {
+			return nil, err
+		}
+		c.totalDifficulty[key] = work
+		return nil, nil
+	}
+
+	// The work of the connected blocks is known before the chain switches
+	c.totalDifficulty[key] = work
+	removed, err := c.reorganize(branch)
+	if err != nil {
+		delete(c.totalDifficulty, key)
+		return nil, err
+	}
+	return removed, nil
+}
+
+// Finalize marks the block of a commit and all blocks before it as final and
+// stores the commit with the block. When the block competes with the block
+// of the chain at its height the chain switches over to it, the blocks
+// removed from the chain by the switch are returned. The signatures of the
+// commit must have been verified by the caller.
+func (c *Chain) Finalize(commit *proto.Commit) ([]*proto.Block, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	block, err := c.GetBlockByHash(commit.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	height := int(block.Header.Height)
+	if commit.Height != block.Header.Height {
+		return nil, fmt.Errorf("commit for height %d holds a block at height %d", commit.Height, height)
+	}
+	if height > c.Height() {
+		return nil, fmt.Errorf("block at height %d is not connected to the chain", height)
+	}
+
+	current, err := types.HashHeader(c.headers.Get(height))
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []*proto.Block
+	if !bytes.Equal(current, commit.BlockHash) {
+		branch, err := c.branchOf(block)
+		if err != nil {
+			return nil, err
+		}
+		if int(branch[0].Header.Height) <= c.finalized {
+			return nil, fmt.Errorf("commit conflicts with the finalized block at height %d", branch[0].Header.Height)
+		}
+		if removed, err = c.reorganize(branch); err != nil {
+			return nil, err
+		}
+	}
+
+	// The stored block may still be in use by its sender, attach the commit
+	// to a copy
+	final := pb.Clone(block).(*proto.Block)
+	final.Commit = commit
+	if err := c.blockStore.Put(final); err != nil {
+		return nil, err
+	}
+
+	if height > c.finalized {
+		c.finalized = height
+	}
+
+	return removed, nil
+}
+
+// Finalized returns the height of the last final block
+func (c *Chain) Finalized() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.finalized
+}
+
+// parentOf returns the header of the known block the header builds on,
+// either a block of the chain or a competing block
+func (c *Chain) parentOf(header *proto.Header) (*proto.Header, error) {
+	parent, err := c.GetBlockByHash(header.PrevHash)
+	if err != nil || parent.Header.Height != header.Height-1 {
+		return nil, fmt.Errorf("block at height %d does not build on a known block: %w", header.Height, ErrUnknownParent)
+	}
+
+	return parent.Header, nil
+}
+
+// onChain returns whether the block with the hash is the block of the chain
+// at the height
+func (c *Chain) onChain(hash []byte, height int) bool {
+	header, ok := c.headers.Lookup(height)
+	if !ok {
+		return false
+	}
+	return bytes.Equal(types.MustHashHeader(header), hash)
+}
+
+// branchOf returns the known blocks from the first block that isn't part of
+// the chain up to block, in order
+func (c *Chain) branchOf(block *proto.Block) ([]*proto.Block, error) {
+	branch := []*proto.Block{block}
+	for {
+		header := branch[0].Header
+		if c.onChain(header.PrevHash, int(header.Height)-1) {
+			return branch, nil
+		}
+
+		parent, err := c.GetBlockByHash(header.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+		branch = append([]*proto.Block{parent}, branch...)
+	}
+}
+
+// reorganize replaces the blocks of the chain from the height of the first
+// block of the branch on with the branch, the first block of the branch must
+// build on the block of the chain before it. The transactions of the whole
+// branch are validated before the chain changes, when the evidence of a
+// block of the branch turns out to be invalid the chain goes back to its
+// blocks. It returns the blocks removed from the chain.
+func (c *Chain) reorganize(branch []*proto.Block) ([]*proto.Block, error) {
+	height := int(branch[0].Header.Height)
+	removed, err := c.blocksFrom(height)
+	if err != nil {
+		return nil, err
+	}
+
+	// check holds the chain with the branch on top of the blocks it keeps
+	check := newUTXOView(c.utxoStore)
+	for _, b := range removed {
+		check.revert(b, c.undo[hex.EncodeToString(types.MustHashBlock(b))])
+	}
+	for _, b := range branch {
+		if _, err := c.apply(check, b); err != nil {
+			return nil, fmt.Errorf("invalid block at height %d: %w", b.Header.Height, err)
+		}
+	}
+
+	if err := c.disconnect(removed); err != nil {
+		return nil, err
+	}
+
+	for i, b := range branch {
+		if err := c.connect(newUTXOView(c.utxoStore), b); err != nil {
+			err = fmt.Errorf("invalid block at height %d: %w", b.Header.Height, err)
+			if restoreErr := c.restore(branch[:i], removed); restoreErr != nil {
+				return nil, errors.Join(err, restoreErr)
+			}
+			return nil, err
+		}
+	}
+
+	return removed, nil
+}
+
+// restore puts the removed blocks back on the chain in place of the
+// connected blocks of a branch, both starting at the same height
+func (c *Chain) restore(connected, removed []*proto.Block) error {
+	var reverted []*proto.Block
+	for i := len(connected) - 1; i >= 0; i-- {
+		reverted = append(reverted, connected[i])
+	}
+	if err := c.disconnect(reverted); err != nil {
+		return err
+	}
+
+	for i := len(removed) - 1; i >= 0; i-- {
+		if err := c.connect(newUTXOView(c.utxoStore), removed[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blocksFrom returns the blocks of the chain from the tip down to the height
+func (c *Chain) blocksFrom(height int) ([]*proto.Block, error) {
+	blocks := []*proto.Block{}
+	for h := c.Height(); h >= height; h-- {
+		b, err := c.GetBlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// disconnect removes the blocks from the tip of the chain, given tip first,
+// along with their outputs, undo data and slashings
+func (c *Chain) disconnect(blocks []*proto.Block) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	view := newUTXOView(c.utxoStore)
+	for _, b := range blocks {
+		view.revert(b, c.undo[hex.EncodeToString(types.MustHashBlock(b))])
+	}
+	if err := view.commit(); err != nil {
+		return err
+	}
+
+	height := int(blocks[len(blocks)-1].Header.Height)
+	c.headers.Truncate(height)
+	for _, b := range blocks {
+		delete(c.undo, hex.EncodeToString(types.MustHashBlock(b)))
+		c.track(b, -1)
+	}
+
+	c.validatorLock.Lock()
+	defer c.validatorLock.Unlock()
+	for key, slashed := range c.slashed {
+		if int(slashed) >= height {
+			delete(c.slashed, key)
+		}
+	}
+	// The epochs starting after the removed blocks are recomputed when the
+	// chain reaches their start again
+	for epoch := range c.epochs {
+		if int(epoch*c.epochLength) > height {
+			delete(c.epochs, epoch)
+		}
+	}
+
+	return nil
+}
+
+// connect validates the transactions and evidence of a block on top of the
+// chain and appends the block
+func (c *Chain) connect(view *utxoView, block *proto.Block) error {
+	spent, err := c.apply(view, block)
+	if err != nil {
+		return err
+	}
+
+	offenders, err := c.offenders(block)
+	if err != nil {
+		return err
+	}
+
+	return c.append(view, block, spent, offenders)
+}
+
+// offenders validates the evidence of a block against the validators of the
+// chain up to the parent of the block and returns the validators to slash.
+// Every offender must have been a validator at the height of the double
+// signing and still be one at the height of the block.
+func (c *Chain) offenders(block *proto.Block) ([]*crypto.PublicKey, error) {
+	current, err := c.ValidatorsAt(block.Header.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	offenders := []*crypto.PublicKey{}
+	seen := map[string]bool{}
+	for i, ev := range block.Evidence {
+		offender, err := types.EvidenceOffender(ev)
+		if err != nil {
+			return nil, fmt.Errorf("invalid evidence at index %d: %w", i, err)
+		}
+
+		validators, err := c.ValidatorsAt(ev.First.Header.Height)
+		if err != nil {
+			return nil, err
+		}
+
+		key := hex.EncodeToString(offender.Bytes())
+		if seen[key] || !current.Contains(offender) || !validators.Contains(offender) {
+			return nil, fmt.Errorf("evidence at index %d against %s can't slash a validator", i, key)
+		}
+		seen[key] = true
+
+		offenders = append(offenders, offender)
+	}
+
+	return offenders, nil
+}
+
+// apply validates the transactions of a block and applies them to view,
+// which must hold the outputs of the chain up to the parent of the block. It
+// returns the outputs spent by the block.
+func (c *Chain) apply(view *utxoView, block *proto.Block) ([]*UTXO, error) {
+	spent := []*UTXO{}
+	for i, tx := range block.Transactions {
+		// Only the genesis block may create outputs out of thin air
+		if block.Header.Height > 0 || len(tx.Inputs) > 0 {
+			if err := types.VerifyTransaction(tx, view, block.Header); err != nil {
+				return nil, fmt.Errorf("invalid transaction at index %d: %w", i, err)
+			}
+		}
+		spent = append(spent, view.apply(tx, block.Header)...)
+	}
+	return spent, nil
+}
+
+// append stores a block applied to view, slashes the offenders of its
+// evidence and makes it the tip of the chain
+func (c *Chain) append(view *utxoView, block *proto.Block, spent []*UTXO, offenders []*crypto.PublicKey) error {
+	hash, err := types.HashBlock(block)
+	if err != nil {
+		return err
+	}
+
+	if err := c.blockStore.Put(block); err != nil {
+		return err
+	}
+
+	if err := view.commit(); err != nil {
+		return err
+	}
+
+	c.undo[hex.EncodeToString(hash)] = spent
+	c.track(block, 1)
+
+	// The last block of an epoch determines the validators of the next one
+	var bonded []*crypto.PublicKey
+	next := block.Header.Height + 1
+	if next%c.epochLength == 0 {
+		if bonded, err = c.bondedValidators(); err != nil {
+			return err
+		}
+	}
+
+	c.validatorLock.Lock()
+	for _, offender := range offenders {
+		c.slashed[hex.EncodeToString(offender.Bytes())] = block.Header.Height
+	}
+	if bonded != nil {
+		c.epochs[next/c.epochLength] = bonded
+	}
+	c.validatorLock.Unlock()
+
 	// add the header to the list of headers
 	c.headers.Add(block.Header)
 
-	// validation
-	return c.blockStore.Put(block)
+	return nil
+}
+
+// SelectTransactions splits txs into the transactions that can be included in
+// a block with the given header, in order, and the ones that are invalid on
+// top of the chain or conflict with a transaction selected before them.
+func (c *Chain) SelectTransactions(header *proto.Header, txs []*proto.Transaction) (valid, invalid []*proto.Transaction) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	view := newUTXOView(c.utxoStore)
+	for _, tx := range txs {
+		if len(tx.Inputs) == 0 || types.VerifyTransaction(tx, view, header) != nil {
+			invalid = append(invalid, tx)
+			continue
+		}
+		view.apply(tx, header)
+		valid = append(valid, tx)
+	}
+
+	return valid, invalid
+}
+
+// GetOutput returns an unspent output of the chain, it implements
+// types.OutputSource
+func (c *Chain) GetOutput(txHash []byte, index uint32) (*types.SpendableOutput, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	utxo, err := c.utxoStore.Get(utxoKey(hex.EncodeToString(txHash), index))
+	if err != nil {
+		return nil, err
+	}
+	return utxo.spendable(), nil
+}
+
+// HasActivity returns whether an output of a block of the chain was paid to
+// the address or to a multisig policy of its key, it implements
+// wallet.ActivityChecker
+func (c *Chain) HasActivity(addr crypto.Address) (bool, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.activity[addr.String()] > 0, nil
+}
+
+// track adds delta to the activity of every address the outputs of block
+// are paid to
+func (c *Chain) track(block *proto.Block, delta int) {
+	for _, tx := range block.Transactions {
+		for _, output := range tx.Outputs {
+			for _, addr := range outputAddresses(output) {
+				c.activity[addr] += delta
+				if c.activity[addr] <= 0 {
+					delete(c.activity, addr)
+				}
+			}
+		}
+	}
+}
+
+// outputAddresses returns the hex encoded addresses an output is paid to
+func outputAddresses(output *proto.TxOutput) []string {
+	if len(output.Address) > 0 {
+		return []string{hex.EncodeToString(output.Address)}
+	}
+
+	addrs := []string{}
+	for _, key := range output.Multisig.GetPublicKeys() {
+		pubKey, err := crypto.PublicKeyFromBytes(key)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, pubKey.Address().String())
+	}
+	return addrs
 }
 
 func (c *Chain) GetBlockByHash(hash []byte) (*proto.Block, error) {
@@ -78,8 +736,152 @@ func (c *Chain) GetBlockByHeight(height int) (*proto.Block, error) {
 		return nil, fmt.Errorf("provided height [%d] higher than chain height", height)
 	}
 
+	if height < 0 {
+		return nil, fmt.Errorf("provided height [%d] must not be negative", height)
+	}
+
 	header := c.headers.Get(height)
 
-	hash := types.MustHashHeader(header)
+	hash, err := types.HashHeader(header)
+	if err != nil {
+		return nil, err
+	}
 	return c.GetBlockByHash(hash)
 }
+
+// branchReader gives the consensus engine the headers of the branch ending
+// in tip, which may compete with the chain
+type branchReader struct {
+	*Chain
+	tip *proto.Header
+}
+
+// HeaderByHeight returns the header of the branch at the given height
+func (r branchReader) HeaderByHeight(height int32) (*proto.Header, error) {
+	header := r.tip
+	if height > header.Height {
+		return nil, fmt.Errorf("no header at height %d", height)
+	}
+
+	for header.Height > height {
+		// The branch joins the chain at this header
+		if r.onChain(types.MustHashHeader(header), int(header.Height)) {
+			return r.Chain.HeaderByHeight(height)
+		}
+
+		parent, err := r.GetBlockByHash(header.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+		header = parent.Header
+	}
+
+	return header, nil
+}
+
+// utxoView overlays the changes of a block that is being validated on top of
+// the unspent outputs of the chain, so transactions can spend outputs created
+// earlier in the same block and nothing is written before the whole block is
+// known to be valid.
+type utxoView struct {
+	store   UTXOStorer
+	created map[string]*UTXO
+	spent   map[string]bool
+}
+
+func newUTXOView(store UTXOStorer) *utxoView {
+	return &utxoView{
+		store:   store,
+		created: map[string]*UTXO{},
+		spent:   map[string]bool{},
+	}
+}
+
+func (v *utxoView) GetOutput(txHash []byte, index uint32) (*types.SpendableOutput, error) {
+	utxo, err := v.get(utxoKey(hex.EncodeToString(txHash), index))
+	if err != nil {
+		return nil, err
+	}
+	return utxo.spendable(), nil
+}
+
+func (v *utxoView) get(key string) (*UTXO, error) {
+	if v.spent[key] {
+		return nil, fmt.Errorf("utxo [%s] already spent", key)
+	}
+
+	if utxo, ok := v.created[key]; ok {
+		return utxo, nil
+	}
+
+	return v.store.Get(key)
+}
+
+// apply spends the inputs and creates the outputs of a transaction, which
+// must be valid on top of the view. It returns the spent outputs.
+func (v *utxoView) apply(tx *proto.Transaction, header *proto.Header) []*UTXO {
+	spent := make([]*UTXO, 0, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		key := utxoKey(hex.EncodeToString(input.PrevTxHash), input.PrevOutIndex)
+		if utxo, err := v.get(key); err == nil {
+			spent = append(spent, utxo)
+		}
+		v.spent[key] = true
+		delete(v.created, key)
+	}
+
+	hash := hex.EncodeToString(types.MustHashTransaction(tx))
+	for i, output := range tx.Outputs {
+		utxo := &UTXO{
+			Hash:      hash,
+			OutIndex:  uint32(i),
+			Output:    output,
+			Height:    header.Height,
+			Timestamp: header.Timestamp,
+		}
+		key := utxoKey(hash, utxo.OutIndex)
+		v.created[key] = utxo
+		// the output may have been removed by reverting a block holding the
+		// same transaction
+		delete(v.spent, key)
+	}
+
+	return spent
+}
+
+// revert undoes applying the transactions of a block, restoring the outputs
+// they spent
+func (v *utxoView) revert(block *proto.Block, spent []*UTXO) {
+	for _, utxo := range spent {
+		key := utxoKey(utxo.Hash, utxo.OutIndex)
+		delete(v.spent, key)
+		v.created[key] = utxo
+	}
+
+	// Outputs created and spent within the block are restored above, remove
+	// them again with the other outputs of the block
+	for _, tx := range block.Transactions {
+		hash := hex.EncodeToString(types.MustHashTransaction(tx))
+		for i := range tx.Outputs {
+			key := utxoKey(hash, uint32(i))
+			v.spent[key] = true
+			delete(v.created, key)
+		}
+	}
+}
+
+func (v *utxoView) commit() error {
+	for key := range v.spent {
+		if err := v.store.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	for _, utxo := range v.created {
+		if err := v.store.Put(utxo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,32 +1,102 @@
 package node
 
 import (
+	"bytes"
+	"context"
+	"math/big"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/consensus"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
 	"github.com/webstradev/blockstra/types"
-	"github.com/webstradev/blockstra/util"
 )
 
+const testBlockTime = time.Second
+
+// newTestChain returns an empty chain with a single validator
+func newTestChain() (*Chain, *crypto.PrivateKey) {
+	validator := crypto.MustGeneratePrivateKey()
+	var (
+		engine     = consensus.NewProofOfAuthority(testBlockTime, validator)
+		validators = consensus.NewValidatorSet([]*crypto.PublicKey{validator.Public()})
+	)
+	return NewChain(NewMemoryBlockStore(), NewMemoryUTXOStore(), engine, validators, 1), validator
+}
+
+// testGenesis returns a genesis block an hour in the past, leaving room for
+// plenty of blocks after it
+func testGenesis(allocations ...types.GenesisAllocation) *proto.Block {
+	return types.NewGenesisBlock(time.Now().Add(-time.Hour).UnixNano(), allocations)
+}
+
+// nextBlock returns a block with the given transactions on top of the tip of
+// the chain, sealed by its validator
+func nextBlock(t *testing.T, chain *Chain, txx ...*proto.Transaction) *proto.Block {
+	return newBlock(t, chain, chain.Tip(), 0, txx...)
+}
+
+// newBlock returns a block with the given transactions on top of parent,
+// proposed in the given round and sealed by the validator of the chain
+func newBlock(t *testing.T, chain *Chain, parent *proto.Header, round int64, txx ...*proto.Transaction) *proto.Block {
+	block := &proto.Block{
+		Header: &proto.Header{
+			Version:   "1",
+			Height:    parent.Height + 1,
+			PrevHash:  types.MustHashHeader(parent),
+			RootHash:  types.MustCalculateRootHash(txx),
+			Timestamp: parent.Timestamp + (round+1)*int64(testBlockTime),
+		},
+		Transactions: txx,
+	}
+	assert.NoError(t, chain.engine.Seal(context.Background(), block))
+	return block
+}
+
+// sealAsProposer seals block with whichever of keys proposes it in the given
+// round
+func sealAsProposer(t *testing.T, chain *Chain, keys []*crypto.PrivateKey, block *proto.Block, round int64) {
+	set, err := chain.ValidatorsAt(block.Header.Height)
+	assert.NoError(t, err)
+
+	proposer := set.Proposer(block.Header.Height, round)
+	for _, key := range keys {
+		if bytes.Equal(key.Public().Bytes(), proposer.Bytes()) {
+			assert.NoError(t, consensus.NewProofOfAuthority(testBlockTime, key).Seal(context.Background(), block))
+			return
+		}
+	}
+	t.Fatalf("no key of proposer %x", proposer.Bytes())
+}
+
 func TestChainHeight(t *testing.T) {
-	chain := NewChain(NewMemoryBlockStore())
-	for i := 0; i > 100; i++ {
-		b := util.RandomBlock()
+	chain, _ := newTestChain()
+	assert.Equal(t, -1, chain.Height())
+	assert.Nil(t, chain.Tip())
+
+	assert.NoError(t, chain.AddBlock(testGenesis()))
+	assert.Equal(t, 0, chain.Height())
+
+	for i := 1; i < 100; i++ {
+		b := nextBlock(t, chain)
 		assert.NoError(t, chain.AddBlock(b))
 		assert.Equal(t, chain.Height(), i)
+		assert.Equal(t, b.Header, chain.Tip())
 	}
 }
 
 func TestAddBlock(t *testing.T) {
-	var (
-		chain = NewChain(NewMemoryBlockStore())
-	)
+	chain, _ := newTestChain()
+	assert.NoError(t, chain.AddBlock(testGenesis()))
 
-	for i := 0; i > 100; i++ {
-		block := util.RandomBlock()
+	for i := 1; i < 100; i++ {
+		block := nextBlock(t, chain)
 		blockHash := types.MustHashBlock(block)
 
 		assert.Nil(t, chain.AddBlock(block))
+		assert.True(t, chain.HasBlock(blockHash))
 
 		fetchedBlock, err := chain.GetBlockByHash(blockHash)
 		assert.NoError(t, err)
@@ -41,3 +111,676 @@ func TestAddBlock(t *testing.T) {
 		assert.Error(t, err)
 	}
 }
+
+func TestAddBlockRejectsUnlinkedBlocks(t *testing.T) {
+	chain, validator := newTestChain()
+
+	// The first block must be a genesis block
+	genesis := testGenesis()
+	genesis.Header.Height = 1
+	assert.Error(t, chain.AddBlock(genesis))
+	assert.NoError(t, chain.AddBlock(testGenesis()))
+
+	block := nextBlock(t, chain)
+	block.Header.Height = 2
+	block.Signature = types.MustSignBlock(validator, block).Bytes()
+	assert.ErrorIs(t, chain.AddBlock(block), ErrUnknownParent)
+
+	block = nextBlock(t, chain)
+	block.Header.PrevHash = types.MustHashBlock(block)
+	block.Signature = types.MustSignBlock(validator, block).Bytes()
+	assert.ErrorIs(t, chain.AddBlock(block), ErrUnknownParent)
+
+	assert.Equal(t, 0, chain.Height())
+}
+
+func TestAddBlockRejectsWrongProposer(t *testing.T) {
+	chain, _ := newTestChain()
+	assert.NoError(t, chain.AddBlock(testGenesis()))
+
+	other := crypto.MustGeneratePrivateKey()
+	block := nextBlock(t, chain)
+	block.PublicKey = other.Public().Bytes()
+	block.Signature = types.MustSignBlock(other, block).Bytes()
+	assert.Error(t, chain.AddBlock(block))
+
+	block = nextBlock(t, chain)
+	block.Signature = nil
+	assert.Error(t, chain.AddBlock(block))
+
+	assert.Equal(t, 0, chain.Height())
+}
+
+func TestAddBlockSpendsOutputs(t *testing.T) {
+	var (
+		chain, _ = newTestChain()
+		privKey  = crypto.MustGeneratePrivateKey()
+		address  = privKey.Public().Address()
+		genesis  = testGenesis(types.GenesisAllocation{Address: address, Amount: 100})
+	)
+
+	assert.NoError(t, chain.AddBlock(genesis))
+
+	genesisHash := types.MustHashTransaction(genesis.Transactions[0])
+	output, err := chain.GetOutput(genesisHash, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), output.Output.Amount)
+
+	spend := func(prevHash []byte, amount int64) *proto.Transaction {
+		tx := &proto.Transaction{
+			Version: 1,
+			Inputs:  []*proto.TxInput{{PrevTxHash: prevHash, PublicKey: privKey.Public().Bytes()}},
+			Outputs: []*proto.TxOutput{{Amount: amount, Address: address.Bytes()}},
+		}
+		tx.Inputs[0].Signature = types.MustSignTransaction(privKey, tx).Bytes()
+		return tx
+	}
+
+	// A transaction spending an output created earlier in the same block
+	tx1 := spend(genesisHash, 100)
+	tx2 := spend(types.MustHashTransaction(tx1), 90)
+
+	block := nextBlock(t, chain, tx1, tx2)
+	assert.NoError(t, chain.AddBlock(block))
+
+	_, err = chain.GetOutput(genesisHash, 0)
+	assert.Error(t, err)
+	_, err = chain.GetOutput(types.MustHashTransaction(tx1), 0)
+	assert.Error(t, err)
+	_, err = chain.GetOutput(types.MustHashTransaction(tx2), 0)
+	assert.NoError(t, err)
+
+	// Spending the genesis output again is a double spend
+	block = nextBlock(t, chain, spend(genesisHash, 50))
+	assert.Error(t, chain.AddBlock(block))
+	assert.Equal(t, 1, chain.Height())
+
+	// Input-less transactions are only allowed in the genesis block
+	block = nextBlock(t, chain, testGenesis(types.GenesisAllocation{Address: address, Amount: 1}).Transactions[0])
+	assert.Error(t, chain.AddBlock(block))
+}
+
+func TestSelectTransactions(t *testing.T) {
+	var (
+		chain, _ = newTestChain()
+		privKey  = crypto.MustGeneratePrivateKey()
+		address  = privKey.Public().Address()
+		genesis  = testGenesis(types.GenesisAllocation{Address: address, Amount: 100})
+	)
+	assert.NoError(t, chain.AddBlock(genesis))
+
+	spend := func(amount int64) *proto.Transaction {
+		tx := &proto.Transaction{
+			Version: 1,
+			Inputs:  []*proto.TxInput{{PrevTxHash: types.MustHashTransaction(genesis.Transactions[0]), PublicKey: privKey.Public().Bytes()}},
+			Outputs: []*proto.TxOutput{{Amount: amount, Address: address.Bytes()}},
+		}
+		tx.Inputs[0].Signature = types.MustSignTransaction(privKey, tx).Bytes()
+		return tx
+	}
+
+	// The second transaction double spends the output spent by the first
+	first, second := spend(100), spend(90)
+	valid, invalid := chain.SelectTransactions(nextBlock(t, chain).Header, []*proto.Transaction{first, second})
+	assert.Equal(t, []*proto.Transaction{first}, valid)
+	assert.Equal(t, []*proto.Transaction{second}, invalid)
+}
+
+func TestAddBlockEnforcesTimeLocks(t *testing.T) {
+	var (
+		chain, _ = newTestChain()
+		privKey  = crypto.MustGeneratePrivateKey()
+		address  = privKey.Public().Address()
+		genesis  = testGenesis(types.GenesisAllocation{Address: address, Amount: 100})
+	)
+
+	assert.NoError(t, chain.AddBlock(genesis))
+
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs: []*proto.TxInput{
+			{PrevTxHash: types.MustHashTransaction(genesis.Transactions[0]), PublicKey: privKey.Public().Bytes()},
+		},
+		Outputs:  []*proto.TxOutput{{Amount: 100, Address: address.Bytes()}},
+		LockTime: &proto.TimeLock{Height: 2},
+	}
+	tx.Inputs[0].Signature = types.MustSignTransaction(privKey, tx).Bytes()
+
+	assert.Error(t, chain.AddBlock(nextBlock(t, chain, tx)))
+
+	assert.NoError(t, chain.AddBlock(nextBlock(t, chain)))
+	assert.NoError(t, chain.AddBlock(nextBlock(t, chain, tx)))
+}
+
+func TestFinalize(t *testing.T) {
+	var (
+		chain, _ = newTestChain()
+		privKey  = crypto.MustGeneratePrivateKey()
+		address  = privKey.Public().Address()
+		genesis  = testGenesis(types.GenesisAllocation{Address: address, Amount: 100})
+	)
+	assert.NoError(t, chain.AddBlock(genesis))
+	assert.Equal(t, 0, chain.Finalized())
+
+	spend := func(prevHash []byte, amount int64) *proto.Transaction {
+		tx := &proto.Transaction{
+			Version: 1,
+			Inputs:  []*proto.TxInput{{PrevTxHash: prevHash, PublicKey: privKey.Public().Bytes()}},
+			Outputs: []*proto.TxOutput{{Amount: amount, Address: address.Bytes()}},
+		}
+		tx.Inputs[0].Signature = types.MustSignTransaction(privKey, tx).Bytes()
+		return tx
+	}
+
+	var (
+		genesisHash = types.MustHashTransaction(genesis.Transactions[0])
+		tx1         = spend(genesisHash, 100)
+		tx2         = spend(types.MustHashTransaction(tx1), 90)
+		// conflicts with tx1
+		tx3 = spend(genesisHash, 80)
+	)
+
+	block1 := nextBlock(t, chain, tx1)
+	assert.NoError(t, chain.AddBlock(block1))
+	block2 := nextBlock(t, chain, tx2)
+	assert.NoError(t, chain.AddBlock(block2))
+
+	// A competing block at height 1 is kept without changing the chain
+	competing := newBlock(t, chain, genesis.Header, 1, tx3)
+	competingHash := types.MustHashBlock(competing)
+	assert.NoError(t, chain.AddBlock(competing))
+	assert.True(t, chain.HasBlock(competingHash))
+	assert.Equal(t, 2, chain.Height())
+	assert.Equal(t, block2.Header, chain.Tip())
+
+	// Finalizing the competing block switches the chain over to it
+	commit := &proto.Commit{Height: 1, BlockHash: competingHash}
+	removed, err := chain.Finalize(commit)
+	assert.NoError(t, err)
+	assert.Equal(t, []*proto.Block{block2, block1}, removed)
+	assert.Equal(t, 1, chain.Height())
+	assert.Equal(t, 1, chain.Finalized())
+	assert.Equal(t, competing.Header, chain.Tip())
+
+	stored, err := chain.GetBlockByHeight(1)
+	assert.NoError(t, err)
+	assert.Equal(t, commit, stored.Commit)
+
+	_, err = chain.GetOutput(genesisHash, 0)
+	assert.Error(t, err)
+	_, err = chain.GetOutput(types.MustHashTransaction(tx1), 0)
+	assert.Error(t, err)
+	_, err = chain.GetOutput(types.MustHashTransaction(tx2), 0)
+	assert.Error(t, err)
+	_, err = chain.GetOutput(types.MustHashTransaction(tx3), 0)
+	assert.NoError(t, err)
+
+	// The chain refuses to replace finalized blocks
+	_, err = chain.Finalize(&proto.Commit{Height: 1, BlockHash: types.MustHashBlock(block1)})
+	assert.Error(t, err)
+	assert.Error(t, chain.AddBlock(newBlock(t, chain, genesis.Header, 2)))
+
+	// Finalizing a block of the chain again changes nothing
+	removed, err = chain.Finalize(commit)
+	assert.NoError(t, err)
+	assert.Empty(t, removed)
+
+	// The chain keeps growing on top of the finalized block
+	block := nextBlock(t, chain)
+	assert.NoError(t, chain.AddBlock(block))
+	removed, err = chain.Finalize(&proto.Commit{Height: 2, BlockHash: types.MustHashBlock(block)})
+	assert.NoError(t, err)
+	assert.Empty(t, removed)
+	assert.Equal(t, 2, chain.Finalized())
+}
+
+func TestAddBlockRejectsMutatedCopies(t *testing.T) {
+	var (
+		chain, _ = newTestChain()
+		privKey  = crypto.MustGeneratePrivateKey()
+		genesis  = testGenesis(types.GenesisAllocation{Address: privKey.Public().Address(), Amount: 100})
+	)
+	assert.NoError(t, chain.AddBlock(genesis))
+	assert.NoError(t, chain.AddBlock(nextBlock(t, chain)))
+
+	txx := []*proto.Transaction{}
+	for amount := int64(1); amount <= 3; amount++ {
+		tx := &proto.Transaction{
+			Version: 1,
+			Inputs:  []*proto.TxInput{{PrevTxHash: types.MustHashTransaction(genesis.Transactions[0]), PublicKey: privKey.Public().Bytes()}},
+			Outputs: []*proto.TxOutput{{Amount: amount, Address: privKey.Public().Address().Bytes()}},
+		}
+		tx.Inputs[0].Signature = types.MustSignTransaction(privKey, tx).Bytes()
+		txx = append(txx, tx)
+	}
+
+	// A copy of a competing block repeating its last transaction has the same
+	// hash, it is rejected and doesn't keep the block out
+	competing := newBlock(t, chain, genesis.Header, 1, txx...)
+	mutated := &proto.Block{
+		Header:       competing.Header,
+		Transactions: append(append([]*proto.Transaction{}, txx...), txx[2]),
+	}
+	assert.Equal(t, types.MustHashBlock(competing), types.MustHashBlock(mutated))
+	assert.Error(t, chain.AddBlock(mutated))
+	assert.False(t, chain.HasBlock(types.MustHashBlock(competing)))
+
+	assert.NoError(t, chain.AddBlock(competing))
+	stored, err := chain.GetBlockByHash(types.MustHashBlock(competing))
+	assert.NoError(t, err)
+	assert.Equal(t, txx, stored.Transactions)
+}
+
+func TestFinalizeRejectsInvalidCommits(t *testing.T) {
+	chain, _ := newTestChain()
+	assert.NoError(t, chain.AddBlock(testGenesis()))
+
+	block := nextBlock(t, chain)
+	assert.NoError(t, chain.AddBlock(block))
+
+	_, err := chain.Finalize(&proto.Commit{Height: 1, BlockHash: types.MustHashBlock(nextBlock(t, chain))})
+	assert.Error(t, err)
+
+	_, err = chain.Finalize(&proto.Commit{Height: 2, BlockHash: types.MustHashBlock(block)})
+	assert.Error(t, err)
+
+	assert.Equal(t, 0, chain.Finalized())
+}
+
+// newProofOfWorkChain returns a chain mined with proof of work holding a
+// genesis block of difficulty 64
+func newProofOfWorkChain(t *testing.T) (*Chain, *proto.Block) {
+	var (
+		chain   = NewChain(NewMemoryBlockStore(), NewMemoryUTXOStore(), consensus.NewProofOfWork(testBlockTime, 5), nil, 1)
+		genesis = testGenesis()
+	)
+	genesis.Header.Difficulty = 64
+	assert.NoError(t, chain.AddBlock(genesis))
+	return chain, genesis
+}
+
+// mineBlock returns a block with the given transactions on top of parent,
+// mined delay after a block time following it
+func mineBlock(t *testing.T, chain *Chain, parent *proto.Header, delay time.Duration, txx ...*proto.Transaction) *proto.Block {
+	block := &proto.Block{
+		Header: &proto.Header{
+			Version:   "1",
+			Height:    parent.Height + 1,
+			PrevHash:  types.MustHashHeader(parent),
+			RootHash:  types.MustCalculateRootHash(txx),
+			Timestamp: parent.Timestamp + int64(testBlockTime+delay),
+		},
+		Transactions: txx,
+	}
+	reader := branchReader{Chain: chain, tip: parent}
+	assert.NoError(t, chain.engine.Prepare(reader, block.Header, parent))
+	assert.NoError(t, chain.engine.Seal(context.Background(), block))
+	return block
+}
+
+func TestAddBlockProofOfWork(t *testing.T) {
+	chain, _ := newProofOfWorkChain(t)
+
+	mine := func() *proto.Block {
+		return mineBlock(t, chain, chain.Tip(), 0)
+	}
+
+	for i := 1; i < 12; i++ {
+		assert.NoError(t, chain.AddBlock(mine()))
+	}
+	assert.Equal(t, 11, chain.Height())
+
+	// Blocks found exactly every block time keep the difficulty
+	assert.Equal(t, uint64(64), chain.Tip().Difficulty)
+
+	// A block without enough work is rejected
+	block := mine()
+	target := consensus.Target(block.Header.Difficulty)
+	for new(big.Int).SetBytes(types.MustHashHeader(block.Header)).Cmp(target) <= 0 {
+		block.Header.Nonce++
+	}
+	assert.Error(t, chain.AddBlock(block))
+	assert.Equal(t, 11, chain.Height())
+}
+
+func TestAddBlockFollowsMostWork(t *testing.T) {
+	chain, genesis := newProofOfWorkChain(t)
+
+	block1 := mineBlock(t, chain, genesis.Header, 0)
+	assert.NoError(t, chain.AddBlock(block1))
+	block2 := mineBlock(t, chain, block1.Header, 0)
+	assert.NoError(t, chain.AddBlock(block2))
+
+	// A branch with the same work is kept without changing the chain
+	competing1 := mineBlock(t, chain, genesis.Header, time.Millisecond)
+	assert.NoError(t, chain.AddBlock(competing1))
+	competing2 := mineBlock(t, chain, competing1.Header, 0)
+	removed, err := chain.ImportBlock(competing2)
+	assert.NoError(t, err)
+	assert.Empty(t, removed)
+	assert.True(t, chain.HasBlock(types.MustHashBlock(competing2)))
+	assert.Equal(t, block2.Header, chain.Tip())
+
+	// A branch with more work replaces the blocks of the chain
+	competing3 := mineBlock(t, chain, competing2.Header, 0)
+	removed, err = chain.ImportBlock(competing3)
+	assert.NoError(t, err)
+	assert.Equal(t, []*proto.Block{block2, block1}, removed)
+	assert.Equal(t, 3, chain.Height())
+	assert.Equal(t, competing3.Header, chain.Tip())
+
+	header, err := chain.HeaderByHeight(1)
+	assert.NoError(t, err)
+	assert.Equal(t, competing1.Header, header)
+
+	// The former chain switches back once it has more work again
+	block3 := mineBlock(t, chain, block2.Header, 0)
+	assert.NoError(t, chain.AddBlock(block3))
+	assert.Equal(t, competing3.Header, chain.Tip())
+	block4 := mineBlock(t, chain, block3.Header, 0)
+	removed, err = chain.ImportBlock(block4)
+	assert.NoError(t, err)
+	assert.Equal(t, []*proto.Block{competing3, competing2, competing1}, removed)
+	assert.Equal(t, block4.Header, chain.Tip())
+}
+
+func TestHasActivityFollowsChain(t *testing.T) {
+	var (
+		chain    = NewChain(NewMemoryBlockStore(), NewMemoryUTXOStore(), consensus.NewProofOfWork(testBlockTime, 5), nil, 1)
+		privKey  = crypto.MustGeneratePrivateKey()
+		address  = privKey.Public().Address()
+		cosigner = crypto.MustGeneratePrivateKey().Public()
+		genesis  = testGenesis(types.GenesisAllocation{Address: address, Amount: 100})
+	)
+	genesis.Header.Difficulty = 64
+	assert.NoError(t, chain.AddBlock(genesis))
+
+	active := func(addr crypto.Address) bool {
+		ok, err := chain.HasActivity(addr)
+		assert.NoError(t, err)
+		return ok
+	}
+	assert.True(t, active(address))
+	assert.False(t, active(cosigner.Address()))
+
+	// Every key of a multisig output is active
+	output, err := types.NewMultisigOutput(100, 1, []*crypto.PublicKey{privKey.Public(), cosigner})
+	assert.NoError(t, err)
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs:  []*proto.TxInput{{PrevTxHash: types.MustHashTransaction(genesis.Transactions[0]), PublicKey: privKey.Public().Bytes()}},
+		Outputs: []*proto.TxOutput{output},
+	}
+	tx.Inputs[0].Signature = types.MustSignTransaction(privKey, tx).Bytes()
+
+	block := mineBlock(t, chain, genesis.Header, 0, tx)
+	assert.NoError(t, chain.AddBlock(block))
+	assert.True(t, active(cosigner.Address()))
+	// Spent outputs still count
+	assert.True(t, active(address))
+
+	// The activity of replaced blocks is forgotten
+	competing1 := mineBlock(t, chain, genesis.Header, time.Millisecond)
+	assert.NoError(t, chain.AddBlock(competing1))
+	removed, err := chain.ImportBlock(mineBlock(t, chain, competing1.Header, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, []*proto.Block{block}, removed)
+	assert.False(t, active(cosigner.Address()))
+	assert.True(t, active(address))
+}
+
+func TestAddBlockKeepsFinalizedBlocks(t *testing.T) {
+	chain, genesis := newProofOfWorkChain(t)
+
+	block1 := mineBlock(t, chain, genesis.Header, 0)
+	assert.NoError(t, chain.AddBlock(block1))
+	_, err := chain.Finalize(&proto.Commit{Height: 1, BlockHash: types.MustHashBlock(block1)})
+	assert.NoError(t, err)
+
+	// A branch conflicting with the finalized block is rejected whatever its
+	// work
+	competing := mineBlock(t, chain, genesis.Header, time.Millisecond)
+	assert.Error(t, chain.AddBlock(competing))
+	assert.Equal(t, block1.Header, chain.Tip())
+}
+
+func TestAddBlockRejectsInvalidBranches(t *testing.T) {
+	chain, genesis := newProofOfWorkChain(t)
+
+	block1 := mineBlock(t, chain, genesis.Header, 0)
+	assert.NoError(t, chain.AddBlock(block1))
+
+	// A competing block spending an unknown output is only checked once its
+	// branch has more work
+	privKey := crypto.MustGeneratePrivateKey()
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs:  []*proto.TxInput{{PrevTxHash: make([]byte, 32), PublicKey: privKey.Public().Bytes()}},
+		Outputs: []*proto.TxOutput{{Amount: 10, Address: privKey.Public().Address().Bytes()}},
+	}
+	tx.Inputs[0].Signature = types.MustSignTransaction(privKey, tx).Bytes()
+
+	competing1 := mineBlock(t, chain, genesis.Header, time.Millisecond, tx)
+	assert.NoError(t, chain.AddBlock(competing1))
+	competing2 := mineBlock(t, chain, competing1.Header, 0)
+	removed, err := chain.ImportBlock(competing2)
+	assert.Error(t, err)
+	assert.Empty(t, removed)
+	assert.Equal(t, block1.Header, chain.Tip())
+
+	// The chain keeps working
+	block2 := mineBlock(t, chain, block1.Header, 0)
+	assert.NoError(t, chain.AddBlock(block2))
+	assert.Equal(t, block2.Header, chain.Tip())
+}
+
+func TestAddBlockRestoresChainAfterInvalidEvidence(t *testing.T) {
+	chain, genesis := newProofOfWorkChain(t)
+
+	block1 := mineBlock(t, chain, genesis.Header, 0)
+	assert.NoError(t, chain.AddBlock(block1))
+	block2 := mineBlock(t, chain, block1.Header, 0)
+	assert.NoError(t, chain.AddBlock(block2))
+
+	// Evidence against a key that isn't a validator is well formed but can't
+	// slash anyone
+	var (
+		key    = crypto.MustGeneratePrivateKey()
+		signed = func(timestamp int64) *proto.Block {
+			b := &proto.Block{Header: &proto.Header{Version: "1", Height: 1, Timestamp: timestamp}}
+			b.PublicKey, b.Signature = key.Public().Bytes(), types.MustSignBlock(key, b).Bytes()
+			return b
+		}
+	)
+	ev, err := types.NewEvidence(signed(1), signed(2))
+	assert.NoError(t, err)
+
+	competing1 := mineBlock(t, chain, genesis.Header, time.Millisecond)
+	assert.NoError(t, chain.AddBlock(competing1))
+	competing2 := &proto.Block{
+		Header: &proto.Header{
+			Version:      "1",
+			Height:       2,
+			PrevHash:     types.MustHashHeader(competing1.Header),
+			Timestamp:    competing1.Header.Timestamp + int64(testBlockTime),
+			EvidenceHash: types.MustCalculateEvidenceHash([]*proto.Evidence{ev}),
+		},
+		Evidence: []*proto.Evidence{ev},
+	}
+	assert.NoError(t, chain.engine.Prepare(branchReader{Chain: chain, tip: competing1.Header}, competing2.Header, competing1.Header))
+	assert.NoError(t, chain.engine.Seal(context.Background(), competing2))
+	assert.NoError(t, chain.AddBlock(competing2))
+
+	// The branch has more work, but its evidence is only found invalid after
+	// its first block was connected, the chain goes back to its blocks
+	competing3 := mineBlock(t, chain, competing2.Header, 0)
+	removed, err := chain.ImportBlock(competing3)
+	assert.ErrorContains(t, err, "can't slash a validator")
+	assert.Empty(t, removed)
+	assert.Equal(t, 2, chain.Height())
+	assert.Equal(t, block2.Header, chain.Tip())
+	header, err := chain.HeaderByHeight(1)
+	assert.NoError(t, err)
+	assert.Equal(t, block1.Header, header)
+
+	block3 := mineBlock(t, chain, block2.Header, 0)
+	assert.NoError(t, chain.AddBlock(block3))
+	assert.Equal(t, block3.Header, chain.Tip())
+}
+
+func TestAddBlockSlashesDoubleSigners(t *testing.T) {
+	var (
+		keys       = []*crypto.PrivateKey{crypto.MustGeneratePrivateKey(), crypto.MustGeneratePrivateKey(), crypto.MustGeneratePrivateKey()}
+		validators = consensus.NewValidatorSet([]*crypto.PublicKey{keys[0].Public(), keys[1].Public(), keys[2].Public()})
+		chain      = NewChain(NewMemoryBlockStore(), NewMemoryUTXOStore(), consensus.NewProofOfAuthority(testBlockTime, nil), validators, 1)
+		genesis    = testGenesis()
+	)
+	assert.NoError(t, chain.AddBlock(genesis))
+
+	// propose returns a block on top of parent sealed by the proposer of the
+	// round, offset moves the timestamp within the round
+	propose := func(parent *proto.Header, round, offset int64, evidence ...*proto.Evidence) *proto.Block {
+		block := &proto.Block{
+			Header: &proto.Header{
+				Version:      "1",
+				Height:       parent.Height + 1,
+				PrevHash:     types.MustHashHeader(parent),
+				Timestamp:    parent.Timestamp + (round+1)*int64(testBlockTime) + offset,
+				EvidenceHash: types.MustCalculateEvidenceHash(evidence),
+			},
+			Evidence: evidence,
+		}
+		sealAsProposer(t, chain, keys, block, round)
+		return block
+	}
+
+	// The proposer of height 1 signs two blocks
+	block1 := propose(genesis.Header, 0, 0)
+	ev, err := types.NewEvidence(block1, propose(genesis.Header, 0, 1))
+	assert.NoError(t, err)
+	offender, err := types.EvidenceOffender(ev)
+	assert.NoError(t, err)
+	assert.NoError(t, chain.AddBlock(block1))
+
+	// Evidence of a validator double signing twice in a block is rejected
+	assert.Error(t, chain.AddBlock(propose(block1.Header, 0, 0, ev, ev)))
+
+	block2 := propose(block1.Header, 0, 0, ev)
+	assert.NoError(t, chain.AddBlock(block2))
+
+	// The offender is removed from the validators after the block
+	set, err := chain.ValidatorsAt(2)
+	assert.NoError(t, err)
+	assert.True(t, set.Contains(offender))
+	set, err = chain.ValidatorsAt(3)
+	assert.NoError(t, err)
+	assert.False(t, set.Contains(offender))
+	assert.Equal(t, 2, set.Len())
+
+	// A validator can only be slashed once
+	assert.Error(t, chain.AddBlock(propose(block2.Header, 0, 0, ev)))
+
+	// The offender doesn't get a turn anymore
+	for round := int64(0); round < 4; round++ {
+		assert.NotEqual(t, offender.Bytes(), set.Proposer(3, round).Bytes())
+	}
+	assert.NoError(t, chain.AddBlock(propose(block2.Header, 0, 0)))
+
+	// Switching to a block without the evidence restores the offender
+	competing := propose(block1.Header, 1, 0)
+	assert.NoError(t, chain.AddBlock(competing))
+	_, err = chain.Finalize(&proto.Commit{Height: 2, BlockHash: types.MustHashBlock(competing)})
+	assert.NoError(t, err)
+	set, err = chain.ValidatorsAt(3)
+	assert.NoError(t, err)
+	assert.True(t, set.Contains(offender))
+}
+
+func TestValidatorsFollowStake(t *testing.T) {
+	var (
+		keys       = []*crypto.PrivateKey{crypto.MustGeneratePrivateKey(), crypto.MustGeneratePrivateKey(), crypto.MustGeneratePrivateKey()}
+		owner      = crypto.MustGeneratePrivateKey()
+		address    = owner.Public().Address()
+		validators = consensus.NewValidatorSet([]*crypto.PublicKey{keys[0].Public()})
+		chain      = NewChain(NewMemoryBlockStore(), NewMemoryUTXOStore(), consensus.NewProofOfAuthority(testBlockTime, nil), validators, 3)
+		genesis    = testGenesis(types.GenesisAllocation{Address: address, Amount: 1000})
+	)
+	assert.NoError(t, chain.AddBlock(genesis))
+
+	// propose adds a block with the given transactions on top of the chain
+	propose := func(txx ...*proto.Transaction) {
+		tip := chain.Tip()
+		block := &proto.Block{
+			Header: &proto.Header{
+				Version:   "1",
+				Height:    tip.Height + 1,
+				PrevHash:  types.MustHashHeader(tip),
+				RootHash:  types.MustCalculateRootHash(txx),
+				Timestamp: tip.Timestamp + int64(testBlockTime),
+			},
+			Transactions: txx,
+		}
+		sealAsProposer(t, chain, keys, block, 0)
+		assert.NoError(t, chain.AddBlock(block))
+	}
+
+	spend := func(typ proto.TxType, prevHash []byte, outputs ...*proto.TxOutput) *proto.Transaction {
+		tx := &proto.Transaction{
+			Version: 1,
+			Type:    typ,
+			Inputs:  []*proto.TxInput{{PrevTxHash: prevHash, PublicKey: owner.Public().Bytes()}},
+			Outputs: outputs,
+		}
+		tx.Inputs[0].Signature = types.MustSignTransaction(owner, tx).Bytes()
+		return tx
+	}
+
+	assertValidators := func(height int32, expected ...*crypto.PrivateKey) {
+		set, err := chain.ValidatorsAt(height)
+		assert.NoError(t, err)
+		pubKeys := []*crypto.PublicKey{}
+		for _, key := range expected {
+			pubKeys = append(pubKeys, key.Public())
+		}
+		assert.ElementsMatch(t, pubKeys, set.Validators())
+	}
+
+	// Stake below the minimum doesn't make a validator
+	bond := spend(proto.TxType_BOND, types.MustHashTransaction(genesis.Transactions[0]),
+		types.NewStakeOutput(types.MinValidatorStake, address, keys[1].Public()),
+		types.NewStakeOutput(types.MinValidatorStake-1, address, keys[2].Public()),
+	)
+	propose(bond)
+
+	// The bonded validator joins with the next epoch
+	_, err := chain.ValidatorsAt(3)
+	assert.Error(t, err)
+	propose()
+	assertValidators(2, keys[0])
+	assertValidators(3, keys[0], keys[1])
+
+	// Rotating the key replaces the validator in the epoch after
+	rotate := spend(proto.TxType_ROTATE_KEY, types.MustHashTransaction(bond),
+		types.NewStakeOutput(types.MinValidatorStake, address, keys[2].Public()),
+	)
+	propose(rotate)
+	propose()
+	propose()
+	assertValidators(5, keys[0], keys[1])
+	assertValidators(6, keys[0], keys[2])
+
+	// Unbonding leaves the validators with the next epoch
+	unbond := spend(proto.TxType_UNBOND, types.MustHashTransaction(rotate), types.NewUnbondOutput(types.MinValidatorStake, address))
+	propose(unbond)
+	propose()
+	propose()
+	assertValidators(8, keys[0], keys[2])
+	assertValidators(9, keys[0])
+
+	// The unbonded stake stays locked for the unbonding delay
+	withdraw := spend(proto.TxType_TRANSFER, types.MustHashTransaction(unbond), &proto.TxOutput{Amount: types.MinValidatorStake, Address: address.Bytes()})
+	valid, _ := chain.SelectTransactions(&proto.Header{Height: 6 + types.UnbondingDelay - 1, Timestamp: chain.Tip().Timestamp}, []*proto.Transaction{withdraw})
+	assert.Empty(t, valid)
+	valid, _ = chain.SelectTransactions(&proto.Header{Height: 6 + types.UnbondingDelay, Timestamp: chain.Tip().Timestamp}, []*proto.Transaction{withdraw})
+	assert.Equal(t, []*proto.Transaction{withdraw}, valid)
+}
@@ -0,0 +1,118 @@
+package node
+
+import (
+	"encoding/hex"
+	"sync"
+
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+)
+
+// evidenceWindow is how many heights below the tip of the chain signed
+// headers are remembered to detect double signing
+const evidenceWindow = 100
+
+type signerKey struct {
+	height int32
+	pubKey string
+}
+
+// EvidencePool remembers the headers signed by validators and holds the
+// evidence of double signing that isn't included in a block yet
+type EvidencePool struct {
+	lock sync.RWMutex
+	// headers holds the first block seen of every validator at a height,
+	// without transactions
+	headers map[signerKey]*proto.Block
+	pending map[string]*proto.Evidence
+}
+
+func NewEvidencePool() *EvidencePool {
+	return &EvidencePool{
+		headers: map[signerKey]*proto.Block{},
+		pending: map[string]*proto.Evidence{},
+	}
+}
+
+// Observe records the header of a block, whose signature must have been
+// verified. It returns the evidence of double signing when the proposer
+// signed a different block at the same height before, nil otherwise.
+func (p *EvidencePool) Observe(block *proto.Block) *proto.Evidence {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := signerKey{height: block.Header.Height, pubKey: hex.EncodeToString(block.PublicKey)}
+	known, ok := p.headers[key]
+	if !ok {
+		p.headers[key] = &proto.Block{Header: block.Header, PublicKey: block.PublicKey, Signature: block.Signature}
+		return nil
+	}
+
+	// NewEvidence fails for the same header signed twice
+	ev, err := types.NewEvidence(known, block)
+	if err != nil {
+		return nil
+	}
+	return ev
+}
+
+func (p *EvidencePool) Has(ev *proto.Evidence) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	_, ok := p.pending[hex.EncodeToString(types.MustHashEvidence(ev))]
+	return ok
+}
+
+// Add adds verified evidence to the pending evidence and returns whether it
+// is new
+func (p *EvidencePool) Add(ev *proto.Evidence) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	hash := hex.EncodeToString(types.MustHashEvidence(ev))
+	if _, ok := p.pending[hash]; ok {
+		return false
+	}
+	p.pending[hash] = ev
+	return true
+}
+
+// List returns the pending evidence in no particular order
+func (p *EvidencePool) List() []*proto.Evidence {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	evidence := make([]*proto.Evidence, 0, len(p.pending))
+	for _, ev := range p.pending {
+		evidence = append(evidence, ev)
+	}
+	return evidence
+}
+
+func (p *EvidencePool) Len() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return len(p.pending)
+}
+
+func (p *EvidencePool) Remove(evidence ...*proto.Evidence) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, ev := range evidence {
+		delete(p.pending, hex.EncodeToString(types.MustHashEvidence(ev)))
+	}
+}
+
+// Prune forgets the headers below the given height
+func (p *EvidencePool) Prune(height int32) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for key := range p.headers {
+		if key.height < height {
+			delete(p.headers, key)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+	"github.com/webstradev/blockstra/util"
+)
+
+func signedBlock(privKey *crypto.PrivateKey, height int32) *proto.Block {
+	block := util.RandomBlock()
+	block.Header.Height = height
+	block.PublicKey = privKey.Public().Bytes()
+	block.Signature = types.MustSignBlock(privKey, block).Bytes()
+	return block
+}
+
+func TestEvidencePool(t *testing.T) {
+	var (
+		pool    = NewEvidencePool()
+		privKey = crypto.MustGeneratePrivateKey()
+		block   = signedBlock(privKey, 5)
+	)
+
+	assert.Nil(t, pool.Observe(block))
+	assert.Nil(t, pool.Observe(block))
+
+	// Blocks at other heights or of other validators don't conflict
+	assert.Nil(t, pool.Observe(signedBlock(privKey, 6)))
+	assert.Nil(t, pool.Observe(signedBlock(crypto.MustGeneratePrivateKey(), 5)))
+
+	ev := pool.Observe(signedBlock(privKey, 5))
+	assert.NotNil(t, ev)
+	assert.NoError(t, types.VerifyEvidence(ev))
+
+	assert.True(t, pool.Add(ev))
+	assert.False(t, pool.Add(ev))
+	assert.True(t, pool.Has(ev))
+	assert.Equal(t, []*proto.Evidence{ev}, pool.List())
+
+	pool.Remove(ev)
+	assert.Equal(t, 0, pool.Len())
+
+	// Forgotten headers can't be used as evidence anymore
+	pool.Prune(6)
+	assert.Nil(t, pool.Observe(signedBlock(privKey, 5)))
+	assert.NotNil(t, pool.Observe(signedBlock(privKey, 6)))
+}
@@ -0,0 +1,237 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/consensus"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startNode starts the node and stops it at the end of the test
+func startNode(t *testing.T, n *Node) {
+	go n.Start(context.Background())
+	t.Cleanup(func() {
+		assert.NoError(t, n.Stop())
+	})
+}
+
+// TestNetwork runs three validators connected over gRPC, best run with
+// -race as every node serves RPCs, gossips and proposes concurrently
+func TestNetwork(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a network of nodes")
+	}
+
+	var (
+		faucet  = crypto.MustGeneratePrivateKey()
+		keys    = []*crypto.PrivateKey{crypto.MustGeneratePrivateKey(), crypto.MustGeneratePrivateKey(), crypto.MustGeneratePrivateKey()}
+		genesis = &types.Genesis{
+			Timestamp:   time.Now().UnixNano(),
+			Allocations: []types.GenesisAllocation{{Address: faucet.Public().Address(), Amount: 1000}},
+			Validators:  []*crypto.PublicKey{keys[0].Public(), keys[1].Public(), keys[2].Public()},
+		}
+		nodes = []*Node{}
+	)
+
+	// Every node bootstraps from the nodes started before it
+	bootstrap := []string{}
+	for _, key := range keys {
+		cfg := ServerConfig{
+			ListenAddr: freeAddr(t),
+			PrivateKey: key,
+			Genesis:    genesis,
+			Consensus:  consensus.Config{BlockTime: 200 * time.Millisecond},
+		}
+		n := New(cfg, zap.NewNop().Sugar(), append([]string{}, bootstrap...))
+		startNode(t, n)
+
+		nodes = append(nodes, n)
+		bootstrap = append(bootstrap, cfg.ListenAddr)
+	}
+
+	assert.Eventually(t, func() bool {
+		for _, n := range nodes {
+			if len(n.getPeerList()) != len(nodes)-1 {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// Send a transaction to the first node over gRPC
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs: []*proto.TxInput{
+			{PrevTxHash: types.MustHashTransaction(genesis.Block().Transactions[0]), PublicKey: faucet.Public().Bytes()},
+		},
+		Outputs: []*proto.TxOutput{
+			{Amount: 1000, Address: crypto.MustGeneratePrivateKey().Public().Address().Bytes()},
+		},
+	}
+	tx.Inputs[0].Signature = types.MustSignTransaction(faucet, tx).Bytes()
+
+	conn, err := grpc.Dial(nodes[0].ListenAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = proto.NewNodeClient(conn).HandleTransaction(context.Background(), tx)
+	assert.NoError(t, err)
+
+	// Every node finalizes the same blocks and the transaction
+	assert.Eventually(t, func() bool {
+		for _, n := range nodes {
+			if n.chain.Finalized() < 3 {
+				return false
+			}
+			if _, err := n.chain.GetOutput(types.MustHashTransaction(tx), 0); err != nil {
+				return false
+			}
+		}
+		return true
+	}, 20*time.Second, 50*time.Millisecond)
+
+	for height := 1; height <= 3; height++ {
+		var hash []byte
+		for _, n := range nodes {
+			block, err := n.chain.GetBlockByHeight(height)
+			assert.NoError(t, err)
+			if hash == nil {
+				hash = types.MustHashBlock(block)
+			}
+			assert.True(t, bytes.Equal(hash, types.MustHashBlock(block)), "nodes disagree on block %d", height)
+		}
+	}
+}
+
+// TestAddrBookFallback restarts a node whose bootstrap node is gone, it
+// connects to the nodes of its address book instead
+func TestAddrBookFallback(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a network of nodes")
+	}
+
+	var (
+		key     = crypto.MustGeneratePrivateKey()
+		genesis = &types.Genesis{
+			Timestamp:  time.Now().UnixNano(),
+			Validators: []*crypto.PublicKey{key.Public()},
+		}
+		remote = New(ServerConfig{ListenAddr: freeAddr(t), PrivateKey: key, Genesis: genesis}, zap.NewNop().Sugar(), []string{})
+		path   = filepath.Join(t.TempDir(), "addrs.json")
+	)
+	startNode(t, remote)
+
+	book := NewAddrBook(path)
+	book.Good(remote.ListenAddr)
+	assert.NoError(t, book.Save())
+
+	cfg := ServerConfig{ListenAddr: freeAddr(t), Genesis: genesis, AddrBookPath: path}
+	n := New(cfg, zap.NewNop().Sugar(), []string{freeAddr(t)})
+	startNode(t, n)
+
+	assert.Eventually(t, func() bool {
+		return len(n.getPeerList()) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, []string{remote.ListenAddr}, n.getPeerList())
+}
+
+func TestStartStop(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts nodes")
+	}
+
+	var (
+		path  = filepath.Join(t.TempDir(), "addrs.json")
+		other = newTestNode(ServerConfig{ListenAddr: freeAddr(t)})
+		n     = newTestNode(ServerConfig{ListenAddr: freeAddr(t), AdminListenAddr: freeAddr(t), AddrBookPath: path})
+	)
+	startNode(t, other)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- n.Start(ctx)
+	}()
+
+	// The other node connects to the node over a stream
+	assert.Eventually(t, func() bool {
+		client, version, err := other.dialRemoteNode(n.ListenAddr)
+		if err != nil {
+			return false
+		}
+		return other.addPeer(client, version, "") == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Cancelling the context stops the node, which disconnects its peers,
+	// stops listening and stores the address book
+	cancel()
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("node didn't stop")
+	}
+	assert.Empty(t, n.getPeerList())
+	assert.Eventually(t, func() bool {
+		return len(other.getPeerList()) == 0
+	}, time.Second, 10*time.Millisecond)
+	for _, addr := range []string{n.ListenAddr, n.AdminListenAddr} {
+		_, err := net.Dial("tcp", addr)
+		assert.Error(t, err)
+	}
+	_, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	// A stopped node stays stopped
+	assert.NoError(t, n.Stop())
+	assert.ErrorIs(t, n.Start(context.Background()), errStopped)
+	assert.ErrorIs(t, n.addPeer(&testClient{}, testVersion(freeAddr(t)), ""), errStopped)
+}
+
+func TestStartReturnsListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	n := newTestNode(ServerConfig{ListenAddr: ln.Addr().String()})
+	assert.Error(t, n.Start(context.Background()))
+	assert.NoError(t, n.Stop())
+}
+
+func TestServeStoppedServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A node stopped before it serves stops its servers first
+	server := grpc.NewServer()
+	server.Stop()
+	assert.NoError(t, serve(server, ln))
+}
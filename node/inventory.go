@@ -0,0 +1,284 @@
+package node
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+	"google.golang.org/grpc/codes"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// New transactions are announced to the peers by hash every
+// inventoryInterval. Inventories hold at most maxInventory hashes and the
+// node remembers the last maxKnownInventory hashes every peer knows.
+const (
+	inventoryInterval = time.Millisecond * 100
+	maxInventory      = 1000
+	maxKnownInventory = 10000
+)
+
+// inventorySet remembers up to size hashes, the oldest are forgotten first
+type inventorySet struct {
+	lock   sync.Mutex
+	size   int
+	hashes map[string]bool
+	order  []string
+}
+
+func newInventorySet(size int) *inventorySet {
+	return &inventorySet{
+		size:   size,
+		hashes: map[string]bool{},
+	}
+}
+
+// Add adds the hash and returns whether it is new
+func (s *inventorySet) Add(hash string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.hashes[hash] {
+		return false
+	}
+	s.hashes[hash] = true
+	s.order = append(s.order, hash)
+
+	if len(s.order) > s.size {
+		delete(s.hashes, s.order[0])
+		s.order = s.order[1:]
+	}
+	return true
+}
+
+// Has returns whether the hash is in the set
+func (s *inventorySet) Has(hash string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.hashes[hash]
+}
+
+// HandleInventory requests the announced transactions the node doesn't know
+// and doesn't request from another peer already from the announcing peer
+func (n *Node) HandleInventory(ctx context.Context, inv *proto.Inventory) (*proto.Ack, error) {
+	if err := validateInventory(inv); err != nil {
+		n.misbehaved(ctx, penaltyMalformed, err)
+		return nil, status.Errorf(codes.InvalidArgument, "invalid inventory: %v", err)
+	}
+
+	p, ok := n.senderOf(ctx)
+	if !ok {
+		return nil, status.Error(codes.FailedPrecondition, "inventory of a node that isn't a peer")
+	}
+
+	var missing [][]byte
+	for _, hash := range inv.TxHashes {
+		key := hex.EncodeToString(hash)
+		p.known.Add(key)
+		if _, ok := n.memPool.Get(key); !ok && n.startFetching(key) {
+			missing = append(missing, hash)
+		}
+	}
+
+	if len(missing) > 0 {
+		// The transactions of the peer are checked after the RPC returned,
+		// the context keeps identifying the peer for penalties
+		fetchCtx := n.ctx
+		if remote, ok := grpcpeer.FromContext(ctx); ok {
+			fetchCtx = grpcpeer.NewContext(fetchCtx, remote)
+		}
+		n.run(func() { n.fetchTransactions(fetchCtx, p, missing) })
+	}
+
+	return &proto.Ack{}, nil
+}
+
+// startFetching marks the hash as requested and returns whether it wasn't
+// requested already
+func (n *Node) startFetching(hash string) bool {
+	n.invLock.Lock()
+	defer n.invLock.Unlock()
+
+	if n.fetching[hash] {
+		return false
+	}
+	n.fetching[hash] = true
+	return true
+}
+
+// doneFetching allows the hashes to be requested again
+func (n *Node) doneFetching(hashes [][]byte) {
+	n.invLock.Lock()
+	defer n.invLock.Unlock()
+
+	for _, hash := range hashes {
+		delete(n.fetching, hex.EncodeToString(hash))
+	}
+}
+
+// GetData returns the requested transactions of the mempool, unknown ones
+// are left out
+func (n *Node) GetData(ctx context.Context, inv *proto.Inventory) (*proto.Transactions, error) {
+	if err := validateInventory(inv); err != nil {
+		n.misbehaved(ctx, penaltyMalformed, err)
+		return nil, status.Errorf(codes.InvalidArgument, "invalid inventory: %v", err)
+	}
+
+	txx := []*proto.Transaction{}
+	for _, hash := range inv.TxHashes {
+		if tx, ok := n.memPool.Get(hex.EncodeToString(hash)); ok {
+			txx = append(txx, tx)
+		}
+	}
+
+	return &proto.Transactions{Transactions: txx}, nil
+}
+
+func validateInventory(inv *proto.Inventory) error {
+	if len(inv.TxHashes) > maxInventory {
+		return fmt.Errorf("%d hashes, at most %d are allowed", len(inv.TxHashes), maxInventory)
+	}
+	for _, hash := range inv.TxHashes {
+		if len(hash) != 32 {
+			return errors.New("invalid transaction hash")
+		}
+	}
+	return nil
+}
+
+// fetchTransactions requests transactions from a peer and adds the ones
+// that were asked for to the mempool, ctx identifies the peer to penalize it
+// for invalid transactions. The hashes can be requested again afterwards.
+func (n *Node) fetchTransactions(ctx context.Context, p *peer, hashes [][]byte) {
+	defer n.doneFetching(hashes)
+
+	reqCtx, cancel := context.WithTimeout(ctx, broadcastTimeout)
+	defer cancel()
+
+	resp, err := p.client.GetData(reqCtx, &proto.Inventory{TxHashes: hashes})
+	if err != nil {
+		n.logger.Debugw("failed to get transactions", "addr", p.version.ListenAddr, "err", err)
+		return
+	}
+
+	requested := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		requested[hex.EncodeToString(hash)] = true
+	}
+
+	for _, tx := range resp.Transactions {
+		hash := hex.EncodeToString(types.MustHashTransaction(tx))
+		if !requested[hash] {
+			continue
+		}
+		delete(requested, hash)
+
+		if err := n.addTransaction(ctx, tx); err != nil {
+			n.logger.Debugw("peer sent invalid transaction", "addr", p.version.ListenAddr, "hash", hash, "err", err)
+		}
+	}
+}
+
+// queueInventory queues the announcement of a new transaction to the peers
+func (n *Node) queueInventory(hash string) {
+	n.invLock.Lock()
+	defer n.invLock.Unlock()
+	n.pendingInv = append(n.pendingInv, hash)
+}
+
+// inventoryLoop regularly announces the queued transactions to the peers
+func (n *Node) inventoryLoop() {
+	ticker := time.NewTicker(inventoryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.ctx.Done():
+			return
+		}
+		n.announceInventory()
+	}
+}
+
+// announceInventory sends the hashes of the queued transactions each peer
+// doesn't know yet to the peers concurrently. Peers that don't take
+// inventories are sent the transactions themselves.
+func (n *Node) announceInventory() {
+	n.invLock.Lock()
+	hashes := n.pendingInv
+	n.pendingInv = nil
+	n.invLock.Unlock()
+
+	if len(hashes) == 0 {
+		return
+	}
+
+	n.peerLock.RLock()
+	peers := make([]*peer, 0, len(n.peers))
+	for _, p := range n.peers {
+		peers = append(peers, p)
+	}
+	n.peerLock.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p *peer) {
+			defer wg.Done()
+			if err := n.announce(p, hashes); err != nil {
+				n.logger.Debugw("failed to announce transactions", "addr", p.version.ListenAddr, "err", err)
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// announce sends the hashes the peer doesn't know yet to the peer
+func (n *Node) announce(p *peer, hashes []string) error {
+	var unknown [][]byte
+	for _, hash := range hashes {
+		if !p.known.Add(hash) {
+			continue
+		}
+
+		if !p.has(FeatureInventory) {
+			tx, ok := n.memPool.Get(hash)
+			if !ok {
+				continue
+			}
+			if err := n.send(p.client, tx); err != nil {
+				return err
+			}
+			continue
+		}
+
+		b, err := hex.DecodeString(hash)
+		if err != nil {
+			return err
+		}
+		unknown = append(unknown, b)
+	}
+
+	for len(unknown) > 0 {
+		batch := unknown
+		if len(batch) > maxInventory {
+			batch = batch[:maxInventory]
+		}
+		unknown = unknown[len(batch):]
+
+		ctx, cancel := context.WithTimeout(n.ctx, broadcastTimeout)
+		_, err := p.client.HandleInventory(ctx, &proto.Inventory{TxHashes: batch})
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package node
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+	"google.golang.org/grpc/codes"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// fromAddr returns the context of an RPC sent from addr
+func fromAddr(t *testing.T, addr string) context.Context {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	assert.NoError(t, err)
+	return grpcpeer.NewContext(context.Background(), &grpcpeer.Peer{Addr: tcpAddr})
+}
+
+// spendGenesis returns a node with a genesis block paying to a new key and a
+// valid transaction spending the payment
+func spendGenesis(t *testing.T) (*Node, *proto.Transaction) {
+	var (
+		n       = newTestNode(ServerConfig{ListenAddr: ":3000"})
+		privKey = crypto.MustGeneratePrivateKey()
+		genesis = types.NewGenesisBlock(0, []types.GenesisAllocation{
+			{Address: privKey.Public().Address(), Amount: 100},
+		})
+	)
+	assert.NoError(t, n.chain.AddBlock(genesis))
+
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs: []*proto.TxInput{
+			{PrevTxHash: types.MustHashTransaction(genesis.Transactions[0]), PublicKey: privKey.Public().Bytes()},
+		},
+		Outputs: []*proto.TxOutput{
+			{Amount: 99, Address: privKey.Public().Address().Bytes()},
+		},
+	}
+	tx.Inputs[0].Signature = types.MustSignTransaction(privKey, tx).Bytes()
+	return n, tx
+}
+
+func TestInventorySet(t *testing.T) {
+	set := newInventorySet(3)
+
+	for i := 0; i < 4; i++ {
+		assert.True(t, set.Add(fmt.Sprint(i)))
+	}
+	assert.False(t, set.Add("3"))
+
+	// The oldest hash is forgotten first
+	assert.False(t, set.Has("0"))
+	assert.True(t, set.Has("1"))
+	assert.True(t, set.Has("3"))
+}
+
+func TestAnnounceInventory(t *testing.T) {
+	var (
+		n, tx   = spendGenesis(t)
+		hash    = types.MustHashTransaction(tx)
+		current = &testClient{}
+		old     = &testClient{}
+		sender  = &testClient{}
+	)
+	n.addPeer(current, testVersion(":4000"), "")
+	v := testVersion(":5000")
+	v.Features = uint64(supportedFeatures &^ FeatureInventory)
+	n.addPeer(old, v, "")
+	n.addPeer(sender, testVersion(":6000"), "10.0.0.1:50000")
+
+	// The peer announcing the transaction knows it already
+	_, err := n.HandleInventory(fromAddr(t, "10.0.0.1:50000"), &proto.Inventory{TxHashes: [][]byte{hash}})
+	assert.NoError(t, err)
+
+	_, err = n.HandleTransaction(context.Background(), tx)
+	assert.NoError(t, err)
+	n.announceInventory()
+
+	assert.Equal(t, [][]byte{hash}, current.announced)
+	assert.Equal(t, []*proto.Transaction{tx}, old.txx)
+	assert.Empty(t, sender.announced)
+
+	// Transactions are announced once
+	n.queueInventory(hex.EncodeToString(hash))
+	n.announceInventory()
+	assert.Len(t, current.announced, 1)
+	assert.Len(t, old.txx, 1)
+}
+
+func TestHandleInventoryFetchesUnknown(t *testing.T) {
+	var (
+		n, tx  = spendGenesis(t)
+		hash   = types.MustHashTransaction(tx)
+		other  = &proto.Transaction{Version: 2}
+		sender = &testClient{serves: []*proto.Transaction{tx, other}}
+		ctx    = fromAddr(t, "10.0.0.1:50000")
+	)
+	n.addPeer(sender, testVersion("10.0.0.1:4000"), "")
+
+	// Transactions requested from another peer already aren't requested
+	// again
+	assert.True(t, n.startFetching(hex.EncodeToString(hash)))
+	_, err := n.HandleInventory(ctx, &proto.Inventory{TxHashes: [][]byte{hash}})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sender.requests)
+	n.doneFetching([][]byte{hash})
+
+	_, err = n.HandleInventory(ctx, &proto.Inventory{TxHashes: [][]byte{hash}})
+	assert.NoError(t, err)
+
+	// Only the requested transaction is taken
+	assert.Eventually(t, func() bool {
+		return n.memPool.Has(tx)
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, n.memPool.Len())
+	assert.Equal(t, 1, sender.requests)
+
+	resp, err := n.GetData(context.Background(), &proto.Inventory{TxHashes: [][]byte{hash, types.MustHashTransaction(other)}})
+	assert.NoError(t, err)
+	assert.Equal(t, []*proto.Transaction{tx}, resp.Transactions)
+}
+
+func TestHandleInventoryRejectsInvalid(t *testing.T) {
+	n := newTestNode(ServerConfig{ListenAddr: ":3000"})
+	n.addPeer(&testClient{}, testVersion("10.0.0.1:4000"), "")
+
+	_, err := n.HandleInventory(fromAddr(t, "10.0.0.1:50000"), &proto.Inventory{TxHashes: [][]byte{{1, 2}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = n.GetData(context.Background(), &proto.Inventory{TxHashes: make([][]byte, maxInventory+1)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Only peers are asked for transactions, the sender is told by the
+	// connection
+	_, err = n.HandleInventory(fromAddr(t, "10.0.0.2:50000"), &proto.Inventory{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// Of several peers on a host only the one connected from the address
+	// sent the inventory
+	n.addPeer(&testClient{}, testVersion("10.0.0.1:5000"), "")
+	_, err = n.HandleInventory(fromAddr(t, "10.0.0.1:50000"), &proto.Inventory{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	n.addPeer(&testClient{}, testVersion("10.0.0.1:6000"), "10.0.0.1:50000")
+	_, err = n.HandleInventory(fromAddr(t, "10.0.0.1:50000"), &proto.Inventory{})
+	assert.NoError(t, err)
+}
+
+func TestHandleInventoryPenalizesInvalidTransactions(t *testing.T) {
+	var (
+		n       = newTestNode(ServerConfig{ListenAddr: ":3000"})
+		invalid = &proto.Transaction{
+			Inputs: []*proto.TxInput{{PublicKey: []byte{1, 2}, Signature: []byte{3}}},
+		}
+		sender = &testClient{serves: []*proto.Transaction{invalid}}
+	)
+	n.addPeer(sender, testVersion("10.0.0.1:4000"), "")
+
+	_, err := n.HandleInventory(fromAddr(t, "10.0.0.1:50000"), &proto.Inventory{TxHashes: [][]byte{types.MustHashTransaction(invalid)}})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return n.scores.Get("10.0.0.1:50000") == penaltyMalformed
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,284 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Penalties added to the misbehaviour score of a peer. Malformed messages
+// can't be the result of an honest peer seeing another chain, invalid ones
+// can, so they weigh less.
+const (
+	penaltyMalformed = 20
+	penaltyInvalid   = 2
+	penaltySpam      = 1
+)
+
+// A peer is banned once its score reaches banThreshold. Scores lose a point
+// every scoreDecay, so honest peers sending the odd invalid message never get
+// there. Peers sending more than spamLimit messages a second are spamming.
+const (
+	banThreshold       = 100
+	scoreDecay         = time.Second * 10
+	spamLimit          = 500
+	defaultBanDuration = time.Hour * 24
+)
+
+// Scores tracks the misbehaviour and the message rate of peers by the host
+// they send from, so a peer can't start over by reconnecting from another
+// port. Scores that decayed to zero are dropped.
+type Scores struct {
+	lock   sync.Mutex
+	scores map[string]*score
+}
+
+type score struct {
+	points  int
+	updated time.Time
+
+	// messages counts the messages received in the second starting at window
+	messages int
+	window   time.Time
+}
+
+func NewScores() *Scores {
+	return &Scores{
+		scores: map[string]*score{},
+	}
+}
+
+// Add adds penalty points to the score of the host of the address and returns
+// the score
+func (s *Scores) Add(addr string, points int) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sc := s.get(addr)
+	sc.points += points
+	return sc.points
+}
+
+// Get returns the score of the host of the address
+func (s *Scores) Get(addr string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	host := hostOf(addr)
+	sc, ok := s.scores[host]
+	if !ok {
+		return 0
+	}
+
+	now := time.Now()
+	sc.decay(now)
+	if sc.idle(now) {
+		delete(s.scores, host)
+	}
+	return sc.points
+}
+
+// Count counts a message of the host of the address and returns the messages
+// the host sent in the current second
+func (s *Scores) Count(addr string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sc, now := s.get(addr), time.Now()
+	if now.Sub(sc.window) >= time.Second {
+		sc.window, sc.messages = now, 0
+	}
+	sc.messages++
+	return sc.messages
+}
+
+// Remove forgets the score of the host
+func (s *Scores) Remove(host string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.scores, host)
+}
+
+// Prune drops the scores that decayed to zero of hosts that didn't send a
+// message in the current second
+func (s *Scores) Prune() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	for host, sc := range s.scores {
+		sc.decay(now)
+		if sc.idle(now) {
+			delete(s.scores, host)
+		}
+	}
+}
+
+// get returns the decayed score of the host of the address, the caller has to
+// hold lock
+func (s *Scores) get(addr string) *score {
+	host, now := hostOf(addr), time.Now()
+	sc, ok := s.scores[host]
+	if !ok {
+		sc = &score{updated: now}
+		s.scores[host] = sc
+	}
+	sc.decay(now)
+	return sc
+}
+
+// idle returns whether the score holds no points and no messages of the
+// current second
+func (sc *score) idle(now time.Time) bool {
+	return sc.points == 0 && now.Sub(sc.window) >= time.Second
+}
+
+func (sc *score) decay(now time.Time) {
+	decayed := int(now.Sub(sc.updated) / scoreDecay)
+	if decayed == 0 {
+		return
+	}
+
+	sc.points -= decayed
+	if sc.points < 0 {
+		sc.points = 0
+	}
+	sc.updated = sc.updated.Add(time.Duration(decayed) * scoreDecay)
+}
+
+// Ban keeps a host from connecting until it expires
+type Ban struct {
+	Host  string    `json:"host"`
+	Until time.Time `json:"until"`
+}
+
+// BanList holds the banned hosts. With a path the bans are stored in a file
+// and survive restarts.
+type BanList struct {
+	lock sync.RWMutex
+	path string
+	bans map[string]time.Time
+}
+
+func NewBanList(path string) *BanList {
+	return &BanList{
+		path: path,
+		bans: map[string]time.Time{},
+	}
+}
+
+// Load reads the bans stored in the file of the ban list, a missing file
+// holds no bans
+func (b *BanList) Load() error {
+	if b.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var bans []Ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, ban := range bans {
+		b.bans[ban.Host] = ban.Until
+	}
+	return nil
+}
+
+// Ban bans the host until the given time
+func (b *BanList) Ban(host string, until time.Time) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.bans[host] = until
+	return b.save()
+}
+
+// Unban lifts the ban of the host and returns whether it was banned
+func (b *BanList) Unban(host string) (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.bans[host]; !ok {
+		return false, nil
+	}
+	delete(b.bans, host)
+	return true, b.save()
+}
+
+// IsBanned returns whether the host is banned
+func (b *BanList) IsBanned(host string) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	until, ok := b.bans[host]
+	return ok && time.Now().Before(until)
+}
+
+// List returns the bans that didn't expire yet ordered by host
+func (b *BanList) List() []Ban {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	now := time.Now()
+	bans := []Ban{}
+	for host, until := range b.bans {
+		if now.Before(until) {
+			bans = append(bans, Ban{Host: host, Until: until})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Host < bans[j].Host
+	})
+	return bans
+}
+
+// save writes the bans that didn't expire yet to the file of the ban list,
+// expired bans are dropped. The caller has to hold lock.
+func (b *BanList) save() error {
+	now := time.Now()
+	bans := []Ban{}
+	for host, until := range b.bans {
+		if !now.Before(until) {
+			delete(b.bans, host)
+			continue
+		}
+		bans = append(bans, Ban{Host: host, Until: until})
+	}
+
+	if b.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(b.path, data)
+}
+
+// hostOf returns the host of an address with or without port
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
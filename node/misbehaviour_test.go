@@ -0,0 +1,94 @@
+package node
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScores(t *testing.T) {
+	scores := NewScores()
+
+	assert.Equal(t, 0, scores.Get("10.0.0.1:5000"))
+	assert.Equal(t, penaltyMalformed, scores.Add("10.0.0.1:5000", penaltyMalformed))
+	assert.Equal(t, penaltyMalformed+penaltyInvalid, scores.Add("10.0.0.1:5000", penaltyInvalid))
+	assert.Equal(t, 0, scores.Get("10.0.0.2:5000"))
+
+	// Reconnecting from another port keeps the score
+	assert.Equal(t, penaltyMalformed+penaltyInvalid, scores.Get("10.0.0.1:6000"))
+
+	// Scores lose a point every scoreDecay
+	scores.scores["10.0.0.1"].updated = time.Now().Add(-5 * scoreDecay)
+	assert.Equal(t, penaltyMalformed+penaltyInvalid-5, scores.Get("10.0.0.1:5000"))
+	scores.scores["10.0.0.1"].updated = time.Now().Add(-100 * scoreDecay)
+	assert.Equal(t, 0, scores.Get("10.0.0.1:5000"))
+	assert.Empty(t, scores.scores)
+
+	scores.Add("10.0.0.1:5000", penaltyInvalid)
+	scores.Remove("10.0.0.1")
+	assert.Equal(t, 0, scores.Get("10.0.0.1:5000"))
+}
+
+func TestScoresPrune(t *testing.T) {
+	scores := NewScores()
+
+	scores.Add("10.0.0.1:5000", penaltyInvalid)
+	scores.Add("10.0.0.2:5000", penaltyInvalid)
+	scores.Count("10.0.0.3:5000")
+	scores.scores["10.0.0.1"].updated = time.Now().Add(-100 * scoreDecay)
+	scores.scores["10.0.0.3"].window = time.Now().Add(-time.Second)
+
+	// Only the score of the host that still holds points is kept
+	scores.Prune()
+	assert.Len(t, scores.scores, 1)
+	assert.Equal(t, penaltyInvalid, scores.Get("10.0.0.2:5000"))
+}
+
+func TestScoresCount(t *testing.T) {
+	scores := NewScores()
+
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, i, scores.Count("10.0.0.1:5000"))
+	}
+	assert.Equal(t, 4, scores.Count("10.0.0.1:6000"))
+	assert.Equal(t, 1, scores.Count("10.0.0.2:5000"))
+
+	// A new second starts over
+	scores.scores["10.0.0.1"].window = time.Now().Add(-time.Second)
+	assert.Equal(t, 1, scores.Count("10.0.0.1:5000"))
+}
+
+func TestBanList(t *testing.T) {
+	var (
+		path = filepath.Join(t.TempDir(), "bans.json")
+		bans = NewBanList(path)
+	)
+	assert.NoError(t, bans.Load())
+
+	until := time.Now().Add(time.Hour).Round(0)
+	assert.NoError(t, bans.Ban("10.0.0.1", until))
+	assert.NoError(t, bans.Ban("10.0.0.2", time.Now().Add(-time.Second)))
+	assert.True(t, bans.IsBanned("10.0.0.1"))
+	assert.False(t, bans.IsBanned("10.0.0.2"))
+	assert.False(t, bans.IsBanned("10.0.0.3"))
+
+	// The bans survive a restart, expired ones are dropped
+	loaded := NewBanList(path)
+	assert.NoError(t, loaded.Load())
+	assert.True(t, loaded.IsBanned("10.0.0.1"))
+	assert.Len(t, loaded.List(), 1)
+	assert.True(t, until.Equal(loaded.List()[0].Until))
+
+	banned, err := loaded.Unban("10.0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, banned)
+	banned, err = loaded.Unban("10.0.0.1")
+	assert.NoError(t, err)
+	assert.False(t, banned)
+
+	loaded = NewBanList(path)
+	assert.NoError(t, loaded.Load())
+	assert.Empty(t, loaded.List())
+}
@@ -1,23 +1,66 @@
 package node
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/webstradev/blockstra/consensus"
 	"github.com/webstradev/blockstra/crypto"
-
 	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/script"
 	"github.com/webstradev/blockstra/types"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/peer"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Consensus defaults of chains that don't configure them
+const (
+	defaultBlockTime        = time.Second * 5
+	defaultRetargetInterval = 10
+	defaultEpochLength      = 10
+)
+
+// Peers are pinged every pingInterval and removed after failing
+// maxPingFailures pings in a row. Bootstrap nodes are dialed again with a
+// backoff doubling from minReconnectBackoff up to maxReconnectBackoff.
+const (
+	pingInterval        = time.Second * 5
+	pingTimeout         = time.Second * 2
+	maxPingFailures     = 3
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute
+)
+
+// broadcastTimeout bounds how long a peer may take to accept a broadcast
+const broadcastTimeout = time.Second * 5
+
+// Every discoveryInterval the node asks a peer for the addresses it knows and
+// dials addresses of its address book while outbound slots are free. Peers
+// are sent at most maxPeerAddrs addresses.
+const (
+	discoveryInterval = time.Second * 30
+	maxPeerAddrs      = 100
 )
 
-const blockTime = time.Second * 5
+// Peer limits of nodes that don't configure them
+const (
+	defaultMaxInboundPeers  = 32
+	defaultMaxOutboundPeers = 8
+)
 
 // this is probably going to be a BSTin future
 type MemPool struct {
@@ -32,19 +75,60 @@ func NewMemPool() *MemPool {
 }
 
 func (p *MemPool) Has(tx *proto.Transaction) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
 	hash := hex.EncodeToString(types.MustHashTransaction(tx))
 	_, ok := p.txx[hash]
 	return ok
 }
 
-func (p *MemPool) Add(tx *proto.Transaction) bool {
-	if p.Has(tx) {
-		return false
+// Get returns the transaction with the hex encoded hash
+func (p *MemPool) Get(hash string) (*proto.Transaction, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	tx, ok := p.txx[hash]
+	return tx, ok
+}
+
+func (p *MemPool) Len() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return len(p.txx)
+}
+
+// List returns the transactions in the pool in no particular order
+func (p *MemPool) List() []*proto.Transaction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	txx := make([]*proto.Transaction, 0, len(p.txx))
+	for _, tx := range p.txx {
+		txx = append(txx, tx)
 	}
+	return txx
+}
+
+func (p *MemPool) Remove(txx ...*proto.Transaction) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
+	for _, tx := range txx {
+		delete(p.txx, hex.EncodeToString(types.MustHashTransaction(tx)))
+	}
+}
+
+// Add adds a transaction to the pool and returns whether it is new
+func (p *MemPool) Add(tx *proto.Transaction) bool {
 	hash := hex.EncodeToString(types.MustHashTransaction(tx))
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.txx[hash]; ok {
+		return false
+	}
 	p.txx[hash] = tx
 	return true
 }
@@ -62,218 +146,1850 @@ type ServerConfig struct {
 	Version    string
 	ListenAddr string
 	PrivateKey *crypto.PrivateKey
+	// Network is the prefix of the addresses accepted by the node's RPCs,
+	// defaults to crypto.MainNet
+	Network crypto.Network
+	// Genesis holds the first block of the chain, added when the node
+	// starts, and the validators allowed to propose the blocks after it
+	Genesis *types.Genesis
+	// ChainID tells chains apart, nodes on another chain aren't accepted as
+	// peers. Defaults to the hash of the genesis block.
+	ChainID string
+	// Consensus selects the consensus engine of the chain, defaults to proof
+	// of authority
+	Consensus consensus.Config
+	// MaxInboundPeers limits the peers that connected to the node, defaults
+	// to 32. A new peer evicts one of them when the limit is reached.
+	MaxInboundPeers int
+	// MaxOutboundPeers limits the peers the node connected to, defaults to 8
+	MaxOutboundPeers int
+	// BanListPath is the file the banned peers are stored in, without it bans
+	// are forgotten on restart
+	BanListPath string
+	// BanDuration is how long misbehaving peers are banned, defaults to a day
+	BanDuration time.Duration
+	// AdminListenAddr is the address the Admin RPCs are served on, they
+	// aren't served without it
+	AdminListenAddr string
+	// AddrBookPath is the file the addresses of the nodes of the network are
+	// stored in, without it they are forgotten on restart
+	AddrBookPath string
+	// NodeKeyPath is the file the node key identifying the node to its peers
+	// is stored in, without it the node gets a new identity on every start
+	NodeKeyPath string
+	// TLS secures the connections between nodes, they aren't encrypted
+	// without it
+	TLS *TLSConfig
+}
+
+// peer is a node connected to the node
+type peer struct {
+	client  proto.NodeClient
+	version *proto.Version
+	// protocol is the protocol version both nodes agreed on
+	protocol uint32
+	// failures counts the pings the peer failed in a row
+	failures int
+	// inbound is set for peers that connected to the node
+	inbound     bool
+	connectedAt time.Time
+	// remoteAddr is the address inbound peers send their messages from
+	remoteAddr string
+	// useful counts the new transactions, blocks, votes and evidence the
+	// peer sent
+	useful int
+	// known holds the hashes of the transactions the peer knows
+	known *inventorySet
+}
+
+// has returns whether the peer announced the features in its handshake and
+// they are part of the protocol version agreed on with it
+func (p *peer) has(features Features) bool {
+	return Features(p.version.Features).Available(features, p.protocol)
+}
+
+// isOn returns whether the peer listens or connected from the host
+func (p *peer) isOn(host string) bool {
+	return hostOf(p.version.ListenAddr) == host || (p.remoteAddr != "" && hostOf(p.remoteAddr) == host)
 }
 
 type Node struct {
 	ServerConfig
 	logger *zap.SugaredLogger
+	// nodeKey identifies the node to its peers, id is its public key in hex
+	nodeKey *crypto.PrivateKey
+	id      string
+	// creds secure the connections to and from other nodes
+	creds credentials.TransportCredentials
 
 	bootstrapNodes []string
 
 	peerLock sync.RWMutex
-	peers    map[proto.NodeClient]*proto.Version
+	// peers are keyed by node id
+	peers map[string]*peer
+	// reconnecting holds the addresses of the bootstrap nodes being dialed
+	// again
+	reconnecting map[string]bool
 
-	memPool *MemPool
+	// pendingInv holds the hashes of the new transactions to announce to the
+	// peers, fetching the hashes of the transactions requested from peers
+	invLock    sync.Mutex
+	pendingInv []string
+	fetching   map[string]bool
+
+	scores   *Scores
+	banList  *BanList
+	addrBook *AddrBook
+
+	memPool      *MemPool
+	evidencePool *EvidencePool
+	chain        *Chain
+	engine       consensus.Engine
+	finality     *consensus.Finality
+
+	// ctx is cancelled when the node stops, wg waits for everything the node
+	// runs in the background then
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+	stopErr  error
+	// runLock orders starting background work with cancelling ctx
+	runLock sync.Mutex
+	// servers are the gRPC servers of the node and its admin RPCs
+	serverLock sync.Mutex
+	servers    []*grpc.Server
 
 	proto.UnimplementedNodeServer
 }
 
 func New(cfg ServerConfig, logger *zap.SugaredLogger, bootstrapNodes []string) *Node {
+	if cfg.Network == "" {
+		cfg.Network = crypto.MainNet
+	}
+	if cfg.Consensus.Kind == "" {
+		cfg.Consensus.Kind = consensus.KindProofOfAuthority
+	}
+	if cfg.Consensus.BlockTime == 0 {
+		cfg.Consensus.BlockTime = defaultBlockTime
+	}
+	if cfg.Consensus.RetargetInterval == 0 {
+		cfg.Consensus.RetargetInterval = defaultRetargetInterval
+	}
+	if cfg.Consensus.EpochLength == 0 {
+		cfg.Consensus.EpochLength = defaultEpochLength
+	}
+	if cfg.MaxInboundPeers == 0 {
+		cfg.MaxInboundPeers = defaultMaxInboundPeers
+	}
+	if cfg.MaxOutboundPeers == 0 {
+		cfg.MaxOutboundPeers = defaultMaxOutboundPeers
+	}
+	if cfg.BanDuration == 0 {
+		cfg.BanDuration = defaultBanDuration
+	}
+	if cfg.ChainID == "" {
+		cfg.ChainID = genesisChainID(cfg.Genesis)
+	}
+
+	var validators []*crypto.PublicKey
+	if cfg.Genesis != nil {
+		validators = cfg.Genesis.Validators
+	}
+	set := consensus.NewValidatorSet(validators)
+	engine := consensus.New(cfg.Consensus, cfg.PrivateKey)
+	// Replaced by the stored node key when the node starts
+	nodeKey := crypto.MustGeneratePrivateKey()
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Node{
 		ServerConfig: cfg,
 		logger:       logger.With("source", cfg.ListenAddr),
+		nodeKey:      nodeKey,
+		id:           nodeID(nodeKey.Public().Bytes()),
+		creds:        insecure.NewCredentials(),
 
 		bootstrapNodes: bootstrapNodes,
 
-		peers: map[proto.NodeClient]*proto.Version{},
+		peers:        map[string]*peer{},
+		reconnecting: map[string]bool{},
+		fetching:     map[string]bool{},
+
+		scores:   NewScores(),
+		banList:  NewBanList(cfg.BanListPath),
+		addrBook: NewAddrBook(cfg.AddrBookPath),
 
-		memPool: NewMemPool(),
+		memPool:      NewMemPool(),
+		evidencePool: NewEvidencePool(),
+		chain:        NewChain(NewMemoryBlockStore(), NewMemoryUTXOStore(), engine, set, cfg.Consensus.EpochLength),
+		engine:       engine,
+		// the genesis block is final by definition
+		finality: consensus.NewFinality(set, cfg.PrivateKey, 1),
+
+		ctx:    ctx,
+		cancel: cancel,
 	}
 }
 
-func (n *Node) Start() error {
+// errStopped is returned by a node that was stopped
+var errStopped = errors.New("node is stopped")
 
-	var (
-		opts       = []grpc.ServerOption{}
-		grpcServer = grpc.NewServer(opts...)
+// serve serves the listener until the server stops, a server stopped before
+// it got to serve returns without error as well
+func serve(server *grpc.Server, ln net.Listener) error {
+	if err := server.Serve(ln); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// Start serves the node and runs its background loops until ctx is done or
+// the node is stopped. It returns once the node stopped, a stopped node
+// can't be started again.
+func (n *Node) Start(ctx context.Context) error {
+	if n.ctx.Err() != nil {
+		return errStopped
+	}
+
+	if n.NodeKeyPath != "" {
+		key, err := LoadNodeKey(n.NodeKeyPath)
+		if err != nil {
+			return fmt.Errorf("invalid node key: %w", err)
+		}
+		n.nodeKey, n.id = key, nodeID(key.Public().Bytes())
+	}
+	if n.TLS != nil {
+		creds, err := n.transportCredentials()
+		if err != nil {
+			return fmt.Errorf("invalid TLS config: %w", err)
+		}
+		n.creds = creds
+	}
+
+	if err := n.Consensus.Validate(); err != nil {
+		return fmt.Errorf("invalid consensus config: %w", err)
+	}
+
+	if n.Genesis != nil {
+		// The validators only matter to proof of authority
+		if n.Consensus.Kind == consensus.KindProofOfAuthority {
+			if err := n.Genesis.Validate(); err != nil {
+				return fmt.Errorf("invalid genesis: %w", err)
+			}
+		}
+		if err := n.chain.AddBlock(n.Genesis.Block()); err != nil {
+			return fmt.Errorf("invalid genesis block: %w", err)
+		}
+	}
+
+	if err := n.banList.Load(); err != nil {
+		return fmt.Errorf("invalid ban list: %w", err)
+	}
+	if err := n.addrBook.Load(); err != nil {
+		return fmt.Errorf("invalid address book: %w", err)
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.Creds(n.creds),
+		grpc.UnaryInterceptor(n.guard),
+		grpc.StreamInterceptor(n.guardStream),
 	)
 
 	ln, err := net.Listen("tcp", n.ListenAddr)
 	if err != nil {
-		n.logger.Fatal(err)
+		return fmt.Errorf("failed to listen: %w", err)
 	}
 
 	proto.RegisterNodeServer(grpcServer, n)
 
+	var adminLn net.Listener
+	if n.AdminListenAddr != "" {
+		adminLn, err = net.Listen("tcp", n.AdminListenAddr)
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("failed to listen for admin RPCs: %w", err)
+		}
+	}
+
+	// Everything Stop waits for is started before Stop can see the servers
+	n.serverLock.Lock()
+	if n.ctx.Err() != nil {
+		n.serverLock.Unlock()
+		ln.Close()
+		if adminLn != nil {
+			adminLn.Close()
+		}
+		return nil
+	}
+	n.servers = append(n.servers, grpcServer)
+
+	if adminLn != nil {
+		adminGrpcServer := grpc.NewServer()
+		proto.RegisterAdminServer(adminGrpcServer, &adminServer{node: n})
+		n.servers = append(n.servers, adminGrpcServer)
+		n.run(func() {
+			if err := serve(adminGrpcServer, adminLn); err != nil {
+				n.logger.Errorw("admin server stopped", "err", err)
+			}
+		})
+	}
+
 	n.logger.Infow("Node Started", "port", n.ListenAddr)
 
 	// Bootstrap the network with a list of already known nodes
 	if len(n.bootstrapNodes) > 0 {
-		go n.bootstrapNetwork(n.bootstrapNodes)
+		n.run(func() { n.bootstrapNetwork(n.bootstrapNodes) })
 	}
+	n.run(n.healthLoop)
+	n.run(n.discoveryLoop)
+	n.run(n.inventoryLoop)
 
+	// The validators change when validators are slashed, so every node with a
+	// key checks whether it is its turn
 	if n.PrivateKey != nil {
-		go n.validatorLoop()
+		n.run(n.validatorLoop)
+		if n.Consensus.Kind == consensus.KindProofOfAuthority {
+			n.run(n.finalityLoop)
+		}
+	}
+	n.serverLock.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			n.Stop()
+		case <-n.ctx.Done():
+		}
+	}()
+
+	if err := serve(grpcServer, ln); err != nil {
+		n.Stop()
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	return n.Stop()
+}
+
+// Stop stops serving, disconnects the peers, waits for the RPCs in progress
+// and the background loops to return and stores the address book. Stopping
+// a stopped node returns the error of the first stop.
+func (n *Node) Stop() error {
+	n.stopOnce.Do(func() {
+		// run doesn't start anything after the node is cancelled, so the wait
+		// group isn't added to while Stop waits on it
+		n.runLock.Lock()
+		n.cancel()
+		n.runLock.Unlock()
+
+		n.serverLock.Lock()
+		servers := n.servers
+		n.serverLock.Unlock()
+
+		n.disconnectPeers()
+		for _, server := range servers {
+			server.GracefulStop()
+		}
+		n.wg.Wait()
+
+		if err := n.addrBook.Save(); err != nil {
+			n.stopErr = fmt.Errorf("failed to store address book: %w", err)
+		}
+		n.logger.Infow("Node Stopped", "port", n.ListenAddr)
+	})
+	return n.stopErr
+}
+
+// run runs f in the background, Stop waits for it to return. Nothing is run
+// once the node is stopping.
+func (n *Node) run(f func()) {
+	n.runLock.Lock()
+	defer n.runLock.Unlock()
+
+	if n.ctx.Err() != nil {
+		return
 	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		f()
+	}()
+}
 
-	return grpcServer.Serve(ln)
+// relay broadcasts the message to the peers in the background
+func (n *Node) relay(msg any) {
+	n.run(func() {
+		if err := n.broadcast(msg); err != nil {
+			n.logger.Errorw("broadcast error", "err", err)
+		}
+	})
 }
 
 func (n *Node) HandleTransaction(ctx context.Context, tx *proto.Transaction) (*proto.Ack, error) {
-	peer, _ := peer.FromContext(ctx)
+	if err := n.addTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+	return &proto.Ack{}, nil
+}
+
+// addTransaction adds a valid new transaction to the mempool and queues its
+// announcement to the peers
+func (n *Node) addTransaction(ctx context.Context, tx *proto.Transaction) error {
+	if err := types.ValidateTransaction(tx); err != nil {
+		n.misbehaved(ctx, penaltyMalformed, err)
+		return status.Errorf(codes.InvalidArgument, "invalid transaction: %v", err)
+	}
+
+	// The transaction has to be valid for inclusion in the next block
+	next := &proto.Header{
+		Height:    int32(n.chain.Height() + 1),
+		Timestamp: time.Now().UnixNano(),
+	}
+
+	if err := types.VerifyTransaction(tx, n.chain, next); err != nil {
+		if !relayRace(err) {
+			n.misbehaved(ctx, penaltyInvalid, err)
+		}
+		return status.Errorf(codes.InvalidArgument, "invalid transaction: %v", err)
+	}
+
 	hash := hex.EncodeToString(types.MustHashTransaction(tx))
 
 	if n.memPool.Add(tx) {
-		n.logger.Debugw("received tx from: ", "from", peer.Addr, "hash", hash)
-		go func() {
-			if err := n.broadcast(tx); err != nil {
-				n.logger.Errorw("broadcast error", "err", err)
-			}
-		}()
+		n.credit(ctx)
+		n.logger.Debugw("received tx from: ", "from", peerAddr(ctx), "hash", hash)
+		n.queueInventory(hash)
 	}
 
-	return &proto.Ack{}, nil
+	return nil
 }
 
-func (n *Node) validatorLoop() {
-	n.logger.Infow("starting validator loop", "pubkey", n.PrivateKey.Public(), "blockTime", blockTime)
-	ticker := time.NewTicker(blockTime)
-	for {
-		<-ticker.C
+// relayRace returns whether a transaction may have been valid when an honest
+// peer relayed it: its outputs may have been spent by a block since and its
+// time locks may have expired by the clock of the peer but not by ours
+func relayRace(err error) bool {
+	return errors.Is(err, types.ErrUnknownOutput) ||
+		errors.Is(err, types.ErrTimeLocked) ||
+		errors.Is(err, script.ErrLocked)
+}
 
-		n.logger.Debugw("time to create a new block", "lenTx", len(n.memPool.txx))
-		n.memPool.Clear()
+// HandleBlock adds a block proposed by a validator to the chain and relays
+// it to the peers of the node
+func (n *Node) HandleBlock(ctx context.Context, block *proto.Block) (*proto.Ack, error) {
+	hash, err := types.HashBlock(block)
+	if err == nil {
+		err = types.VerifyBlock(block)
+	}
+	if err != nil {
+		n.misbehaved(ctx, penaltyMalformed, err)
+		return nil, status.Errorf(codes.InvalidArgument, "invalid block: %v", err)
 	}
-}
 
-func (n *Node) broadcast(msg any) error {
-	for peer := range n.peers {
-		switch v := msg.(type) {
-		case *proto.Transaction:
-			_, err := peer.HandleTransaction(context.Background(), v)
-			if err != nil {
-				return err
-			}
+	// A block sent along with its commit is final right away
+	if block.Commit != nil {
+		if !bytes.Equal(block.Commit.BlockHash, hash) {
+			n.misbehaved(ctx, penaltyMalformed, errors.New("commit is for another block"))
+			return nil, status.Error(codes.InvalidArgument, "commit is for another block")
+		}
+		validators, err := n.chain.ValidatorsAt(block.Header.Height)
+		if err == nil {
+			err = consensus.VerifyCommit(block.Commit, validators)
+		}
+		if err != nil {
+			n.misbehaved(ctx, penaltyInvalid, err)
+			return nil, status.Errorf(codes.InvalidArgument, "invalid commit: %v", err)
 		}
 	}
-	return nil
+
+	if n.chain.HasBlock(hash) {
+		if block.Commit != nil && n.chain.Finalized() < int(block.Header.Height) {
+			n.finalizeReceived(block.Commit)
+		}
+		return &proto.Ack{}, nil
+	}
+
+	// The genesis block is part of the configuration of the node
+	if block.Header.Height == 0 {
+		n.misbehaved(ctx, penaltyInvalid, errors.New("sent a genesis block"))
+		return nil, status.Error(codes.InvalidArgument, "genesis block is not accepted from peers")
+	}
+
+	// Blocks the chain rejects, like ones conflicting with a final block, can
+	// still prove double signing
+	n.detectDoubleSign(block)
+
+	removed, err := n.chain.ImportBlock(block)
+	if errors.Is(err, ErrUnknownParent) {
+		return nil, status.Errorf(codes.FailedPrecondition, "missing blocks: %v", err)
+	} else if err != nil {
+		n.misbehaved(ctx, penaltyInvalid, err)
+		return nil, status.Errorf(codes.InvalidArgument, "invalid block: %v", err)
+	}
+
+	n.credit(ctx)
+	n.logger.Debugw("received block from: ", "from", peerAddr(ctx), "height", block.Header.Height, "hash", hex.EncodeToString(hash))
+
+	n.restoreRemoved(removed)
+
+	// Competing blocks don't change the chain until their branch has more
+	// work or is finalized
+	if bytes.Equal(types.MustHashHeader(n.chain.Tip()), hash) {
+		n.pruneMemPool(block)
+		n.pruneEvidencePool(block)
+	}
+
+	if block.Commit != nil {
+		n.finalizeReceived(block.Commit)
+	}
+	n.advanceFinality()
+
+	n.relay(block)
+
+	return &proto.Ack{}, nil
 }
 
-func (n *Node) Handshake(ctx context.Context, v *proto.Version) (*proto.Version, error) {
-	c, err := makeNodeClient(v.ListenAddr)
+// finalizeReceived finalizes the block of a verified commit received from a
+// peer, a failure is only logged since the commit stays valid
+func (n *Node) finalizeReceived(commit *proto.Commit) {
+	if err := n.finalize(commit); err != nil {
+		n.logger.Errorw("failed to finalize block", "height", commit.Height, "err", err)
+	}
+}
+
+// HandleVote records a vote of a validator for the finality of a block and
+// relays it to the peers of the node
+func (n *Node) HandleVote(ctx context.Context, v *proto.Vote) (*proto.Ack, error) {
+	if err := types.VerifyVote(v); err != nil {
+		n.misbehaved(ctx, penaltyMalformed, err)
+		return nil, status.Errorf(codes.InvalidArgument, "invalid vote: %v", err)
+	}
+
+	added, err := n.finality.AddVote(v)
 	if err != nil {
-		return nil, err
+		n.misbehaved(ctx, penaltyInvalid, err)
+		return nil, status.Errorf(codes.InvalidArgument, "invalid vote: %v", err)
 	}
 
-	n.addPeer(c, v)
+	if added {
+		n.credit(ctx)
+		n.relay(v)
+		n.advanceFinality()
+	}
 
-	return n.getVersion(), nil
+	return &proto.Ack{}, nil
 }
 
-func (n *Node) addPeer(client proto.NodeClient, version *proto.Version) {
-	n.peerLock.Lock()
-	defer n.peerLock.Unlock()
+// HandleEvidence adds evidence of a validator double signing to the evidence
+// to include in the next block and relays it to the peers of the node
+func (n *Node) HandleEvidence(ctx context.Context, ev *proto.Evidence) (*proto.Ack, error) {
+	if err := types.VerifyEvidence(ev); err != nil {
+		n.misbehaved(ctx, penaltyMalformed, err)
+		return nil, status.Errorf(codes.InvalidArgument, "invalid evidence: %v", err)
+	}
 
-	// handle the logic where we decide to accept or drop
-	// the incoming node connection
+	if n.evidencePool.Has(ev) {
+		return &proto.Ack{}, nil
+	}
 
-	n.peers[client] = version
-	n.logger.Debugw("new peer successfully connected", "addr", version.ListenAddr)
+	// Evidence against validators that are slashed already or weren't
+	// validators at the height is of no use
+	if !n.canSlash(ev, int32(n.chain.Height()+1)) {
+		return &proto.Ack{}, nil
+	}
 
-	// Connect to all peers in the received list of peers
-	if len(version.PeerList) > 0 {
-		go n.bootstrapNetwork(version.PeerList)
+	if n.evidencePool.Add(ev) {
+		n.credit(ctx)
+		n.logger.Infow("received evidence of double signing", "from", peerAddr(ctx), "height", ev.First.Header.Height, "validator", hex.EncodeToString(ev.First.PublicKey))
+		n.relay(ev)
 	}
+
+	return &proto.Ack{}, nil
 }
 
-func (n *Node) removePeer(c proto.NodeClient) {
-	n.peerLock.Lock()
-	defer n.peerLock.Unlock()
-	delete(n.peers, c)
+// detectDoubleSign remembers the header of a block signed by a validator and
+// adds and relays the evidence when the validator signed another block at the
+// same height
+func (n *Node) detectDoubleSign(block *proto.Block) {
+	// Blocks too old to include the evidence or far ahead of the chain aren't
+	// remembered, neither are blocks of unknown keys, so peers can't fill the
+	// pool with headers
+	height, tip := block.Header.Height, int32(n.chain.Height())
+	if height+evidenceWindow < tip || height > tip+1 {
+		return
+	}
+	if len(block.PublicKey) == 0 || types.VerifyBlockSignature(block) != nil {
+		return
+	}
+
+	validators, err := n.chain.ValidatorsAt(height)
+	if err != nil {
+		return
+	}
+	pubKey, err := crypto.PublicKeyFromBytes(block.PublicKey)
+	if err != nil || !validators.Contains(pubKey) {
+		return
+	}
+
+	ev := n.evidencePool.Observe(block)
+	if ev == nil || !n.evidencePool.Add(ev) {
+		return
+	}
+
+	n.logger.Warnw("validator double signed", "height", height, "validator", hex.EncodeToString(block.PublicKey))
+	n.relay(ev)
 }
 
-func (n *Node) bootstrapNetwork(addrs []string) error {
-	for _, addr := range addrs {
-		if addr == n.ListenAddr {
-			continue
-		}
+// canSlash returns whether evidence can slash its offender in a block at the
+// given height
+func (n *Node) canSlash(ev *proto.Evidence, height int32) bool {
+	offender, err := types.EvidenceOffender(ev)
+	if err != nil || ev.First.Header.Height > height {
+		return false
+	}
 
-		if !n.canConnectWith(addr) {
-			continue
+	for _, h := range []int32{ev.First.Header.Height, height} {
+		validators, err := n.chain.ValidatorsAt(h)
+		if err != nil || !validators.Contains(offender) {
+			return false
 		}
+	}
 
-		n.logger.Debugw("dialing remote nodes", "remote", addr)
-		client, version, err := n.dialRemoteNode(addr)
-		if err != nil {
-			n.logger.Error("dial error: ", err)
-			continue
-		}
+	return true
+}
 
-		n.addPeer(client, version)
+// GetOutput returns an unspent output of the chain
+func (n *Node) GetOutput(ctx context.Context, op *proto.OutPoint) (*proto.TxOutput, error) {
+	if len(op.TxHash) != sha256.Size {
+		n.misbehaved(ctx, penaltyMalformed, fmt.Errorf("invalid transaction hash length %d", len(op.TxHash)))
+		return nil, status.Errorf(codes.InvalidArgument, "invalid transaction hash length %d", len(op.TxHash))
 	}
 
-	return nil
+	utxo, err := n.chain.GetOutput(op.TxHash, op.Index)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	return utxo.Output, nil
 }
 
-func (n *Node) dialRemoteNode(addr string) (proto.NodeClient, *proto.Version, error) {
-	c, err := makeNodeClient(addr)
+// HasActivity returns whether outputs were paid to the address, which has to
+// be encoded for the network of the node
+func (n *Node) HasActivity(ctx context.Context, req *proto.ActivityRequest) (*proto.Activity, error) {
+	addr, err := crypto.ParseAddress(req.Address, n.Network)
 	if err != nil {
-		return nil, nil, err
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	v, err := c.Handshake(context.Background(), n.getVersion())
+	active, err := n.chain.HasActivity(addr)
 	if err != nil {
-		return nil, nil, err
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	return c, v, nil
+	return &proto.Activity{Active: active}, nil
 }
 
-func (n *Node) getVersion() *proto.Version {
-	return &proto.Version{
-		Version:    n.Version,
-		Height:     0,
-		ListenAddr: n.ListenAddr,
-		PeerList:   n.getPeerList(),
+// isValidator returns whether the node produces blocks. With proof of
+// authority its key has to be part of the validators of the next block, with
+// proof of work every node with a key mines.
+func (n *Node) isValidator() bool {
+	if n.PrivateKey == nil {
+		return false
 	}
+	if n.Consensus.Kind == consensus.KindProofOfWork {
+		return true
+	}
+
+	validators, err := n.chain.ValidatorsAt(int32(n.chain.Height() + 1))
+	return err == nil && validators.Contains(n.PrivateKey.Public())
 }
 
-func (n *Node) canConnectWith(addr string) bool {
-	// Don't attempt to connect with itself
-	if n.ListenAddr == addr {
-		return false
+// validatorLoop checks several times per round whether it is the turn of the
+// node to propose a block. Mining a proof of work block is given up after a
+// block time to pick up new transactions and blocks of other miners.
+func (n *Node) validatorLoop() {
+	n.logger.Infow("starting validator loop", "address", n.PrivateKey.Public().Address().Encode(n.Network), "consensus", n.Consensus.Kind, "blockTime", n.Consensus.BlockTime)
+	ticker := time.NewTicker(n.Consensus.BlockTime / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.ctx.Done():
+			return
+		}
+
+		block, err := n.proposeBlock()
+		if errors.Is(err, consensus.ErrNotProposer) || errors.Is(err, context.DeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			n.logger.Errorw("failed to propose block", "err", err)
+			continue
+		}
+
+		n.logger.Infow("proposed block", "height", block.Header.Height, "lenTx", len(block.Transactions))
+		n.relay(block)
+		n.advanceFinality()
 	}
+}
 
-	// Don't attempt to connect with already connected peers
-	connectedPeers := n.getPeerList()
-	for _, connectedAddr := range connectedPeers {
-		if addr == connectedAddr {
-			return false
+// finalityLoop moves the finality gadget on to the next round when a round
+// doesn't finalize the block at the current height in time. Every round
+// waits a block time longer than the one before, so validators whose rounds
+// drifted apart end up in the same round long enough to finalize.
+func (n *Node) finalityLoop() {
+	ticker := time.NewTicker(n.Consensus.BlockTime / 10)
+	height, round := n.finality.Height(), n.finality.Round()
+	since := time.Now()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.ctx.Done():
+			return
+		}
+
+		// A round only starts once the block to vote on is there
+		if height != n.finality.Height() || round != n.finality.Round() || n.chain.Height() < int(height) {
+			height, round = n.finality.Height(), n.finality.Round()
+			since = time.Now()
+			continue
+		}
+
+		// Only validators time out rounds voting on a block
+		if time.Since(since) >= roundTimeout(n.Consensus.BlockTime, round) && n.isValidator() {
+			n.finality.Timeout()
+			n.logger.Debugw("finality round timed out", "height", height, "round", round)
+			n.advanceFinality()
 		}
 	}
+}
 
-	return true
+// roundTimeout returns how long a finality round waits for the validators
+func roundTimeout(blockTime time.Duration, round int32) time.Duration {
+	return blockTime * time.Duration(round+1)
 }
 
-func (n *Node) getPeerList() []string {
-	n.peerLock.RLock()
-	defer n.peerLock.RUnlock()
+// advanceFinality hands the block at the height being finalized to the
+// finality gadget, sends the votes that became due and finalizes blocks once
+// the validators committed to them
+func (n *Node) advanceFinality() {
+	for {
+		height := n.finality.Height()
+		if block, err := n.chain.GetBlockByHeight(int(height)); err == nil {
+			n.finality.SetCandidate(height, types.MustHashBlock(block))
+		}
 
-	peers := []string{}
+		votes, commit := n.finality.Step()
+		for _, v := range votes {
+			n.relay(v)
+		}
+
+		if commit == nil {
+			return
+		}
 
-	for _, version := range n.peers {
-		peers = append(peers, version.ListenAddr)
+		if err := n.finalize(commit); err != nil {
+			n.logger.Errorw("failed to finalize block", "height", commit.Height, "err", err)
+			return
+		}
 	}
+}
 
-	return peers
+// finalize makes the block of a verified commit final and moves the finality
+// gadget on to the next height. Transactions of blocks the chain dropped for
+// it go back into the mempool if they are still valid.
+func (n *Node) finalize(commit *proto.Commit) error {
+	removed, err := n.chain.Finalize(commit)
+	if err != nil {
+		return err
+	}
+
+	validators, err := n.chain.ValidatorsAt(commit.Height + 1)
+	if err != nil {
+		return err
+	}
+	n.finality.Finalized(commit.Height, validators)
+
+	n.logger.Infow("finalized block", "height", commit.Height, "round", commit.Round, "hash", hex.EncodeToString(commit.BlockHash), "removed", len(removed))
+
+	if len(removed) == 0 {
+		return nil
+	}
+
+	block, err := n.chain.GetBlockByHash(commit.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	n.restoreRemoved(removed)
+	n.pruneMemPool(block)
+	n.pruneEvidencePool(block)
+
+	return nil
+}
+
+// restoreRemoved puts the transactions and evidence of blocks removed from
+// the chain back into the pools, transactions only if they are still valid
+func (n *Node) restoreRemoved(removed []*proto.Block) {
+	next := &proto.Header{
+		Height:    int32(n.chain.Height() + 1),
+		Timestamp: time.Now().UnixNano(),
+	}
+	for _, b := range removed {
+		valid, _ := n.chain.SelectTransactions(next, b.Transactions)
+		for _, tx := range valid {
+			n.memPool.Add(tx)
+		}
+		for _, ev := range b.Evidence {
+			n.evidencePool.Add(ev)
+		}
+	}
+}
+
+// proposeBlock builds a block of the transactions in the mempool on top of
+// the chain and adds it, if it is the turn of the node
+func (n *Node) proposeBlock() (*proto.Block, error) {
+	parent := n.chain.Tip()
+	if parent == nil {
+		return nil, fmt.Errorf("chain has no genesis block")
+	}
+
+	parentHash, err := types.HashHeader(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &proto.Header{
+		Version:   "1",
+		Height:    parent.Height + 1,
+		PrevHash:  parentHash,
+		Timestamp: time.Now().UnixNano(),
+	}
+	if err := n.engine.Prepare(n.chain, header, parent); err != nil {
+		return nil, err
+	}
+
+	txx, invalid := n.chain.SelectTransactions(header, n.memPool.List())
+	n.memPool.Remove(invalid...)
+
+	header.RootHash, err = types.CalculateRootHash(txx)
+	if err != nil {
+		return nil, err
+	}
+
+	evidence := n.selectEvidence(header.Height)
+	header.EvidenceHash, err = types.CalculateEvidenceHash(evidence)
+	if err != nil {
+		return nil, err
+	}
+
+	block := &proto.Block{
+		Header:       header,
+		Transactions: txx,
+		Evidence:     evidence,
+	}
+	ctx, cancel := context.WithTimeout(n.ctx, n.Consensus.BlockTime)
+	defer cancel()
+
+	if err := n.engine.Seal(ctx, block); err != nil {
+		return nil, err
+	}
+
+	if err := n.chain.AddBlock(block); err != nil {
+		return nil, err
+	}
+	n.memPool.Remove(txx...)
+	n.pruneEvidencePool(block)
+
+	return block, nil
+}
+
+// selectEvidence returns the pending evidence that can slash a validator in a
+// block at the given height, at most one per offender
+func (n *Node) selectEvidence(height int32) []*proto.Evidence {
+	var (
+		evidence  = []*proto.Evidence{}
+		offenders = map[string]bool{}
+	)
+
+	for _, ev := range n.evidencePool.List() {
+		offender := hex.EncodeToString(ev.First.PublicKey)
+		if offenders[offender] || !n.canSlash(ev, height) {
+			continue
+		}
+		offenders[offender] = true
+		evidence = append(evidence, ev)
+	}
+
+	return evidence
+}
+
+// pruneMemPool removes the transactions included in the block and the ones
+// it made invalid, like double spends, from the mempool
+func (n *Node) pruneMemPool(block *proto.Block) {
+	n.memPool.Remove(block.Transactions...)
+
+	next := &proto.Header{
+		Height:    block.Header.Height + 1,
+		Timestamp: time.Now().UnixNano(),
+	}
+	_, invalid := n.chain.SelectTransactions(next, n.memPool.List())
+	n.memPool.Remove(invalid...)
+}
+
+// pruneEvidencePool removes the evidence included in the block and the
+// evidence against validators that are slashed already from the pool, and
+// forgets headers too old for evidence
+func (n *Node) pruneEvidencePool(block *proto.Block) {
+	n.evidencePool.Remove(block.Evidence...)
+
+	next := block.Header.Height + 1
+	for _, ev := range n.evidencePool.List() {
+		if !n.canSlash(ev, next) {
+			n.evidencePool.Remove(ev)
+		}
+	}
+
+	n.evidencePool.Prune(block.Header.Height - evidenceWindow)
+}
+
+// broadcast sends the message to all peers that support it concurrently, a
+// slow or failing peer doesn't keep it from the others. The errors of all
+// peers are returned.
+func (n *Node) broadcast(msg any) error {
+	feature := featureOf(msg)
+
+	n.peerLock.RLock()
+	peers := make(map[proto.NodeClient]*proto.Version, len(n.peers))
+	for _, p := range n.peers {
+		if p.has(feature) {
+			peers[p.client] = p.version
+		}
+	}
+	n.peerLock.RUnlock()
+
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		errs []error
+	)
+	for client, version := range peers {
+		wg.Add(1)
+		go func(client proto.NodeClient, version *proto.Version) {
+			defer wg.Done()
+
+			err := n.send(client, msg)
+			// The peer missed the blocks before the block
+			if _, ok := msg.(*proto.Block); ok && status.Code(err) == codes.FailedPrecondition {
+				err = n.syncPeer(client, int(version.Height))
+			}
+			if err != nil {
+				lock.Lock()
+				errs = append(errs, fmt.Errorf("peer %s: %w", version.ListenAddr, err))
+				lock.Unlock()
+			}
+		}(client, version)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// send delivers a message to a peer within the broadcast timeout, unless the
+// node stops first
+func (n *Node) send(client proto.NodeClient, msg any) error {
+	ctx, cancel := context.WithTimeout(n.ctx, broadcastTimeout)
+	defer cancel()
+
+	var err error
+	switch v := msg.(type) {
+	case *proto.Transaction:
+		_, err = client.HandleTransaction(ctx, v)
+	case *proto.Block:
+		_, err = client.HandleBlock(ctx, v)
+	case *proto.Vote:
+		_, err = client.HandleVote(ctx, v)
+	case *proto.Evidence:
+		_, err = client.HandleEvidence(ctx, v)
+	default:
+		err = fmt.Errorf("can't broadcast %T", msg)
+	}
+	return err
+}
+
+// Ping answers the health checks of peers and signs their challenge, if any
+func (n *Node) Ping(ctx context.Context, hb *proto.Heartbeat) (*proto.Heartbeat, error) {
+	resp := &proto.Heartbeat{
+		Height: int32(n.chain.Height()),
+		NodeId: n.nodeKey.Public().Bytes(),
+	}
+	if len(hb.Challenge) > 0 {
+		resp.Signature = signChallenge(n.nodeKey, hb.Challenge)
+	}
+	return resp, nil
+}
+
+// healthLoop regularly checks whether the peers are still alive and drops the
+// scores of hosts that behave again
+func (n *Node) healthLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.ctx.Done():
+			return
+		}
+		n.checkPeers()
+		n.scores.Prune()
+	}
+}
+
+// checkPeers pings every peer once and removes the peers that failed
+// maxPingFailures pings in a row
+func (n *Node) checkPeers() {
+	n.peerLock.RLock()
+	clients := make(map[string]proto.NodeClient, len(n.peers))
+	for id, p := range n.peers {
+		clients[id] = p.client
+	}
+	n.peerLock.RUnlock()
+
+	height := int32(n.chain.Height())
+	for id, client := range clients {
+		ctx, cancel := context.WithTimeout(n.ctx, pingTimeout)
+		_, err := client.Ping(ctx, &proto.Heartbeat{Height: height})
+		cancel()
+
+		if n.recordPing(id, err) {
+			n.removePeer(id)
+		}
+	}
+}
+
+// recordPing records the outcome of pinging a peer and returns whether the
+// peer is considered dead
+func (n *Node) recordPing(id string, err error) bool {
+	n.peerLock.Lock()
+	defer n.peerLock.Unlock()
+
+	p, ok := n.peers[id]
+	if !ok {
+		return false
+	}
+
+	if err == nil {
+		p.failures = 0
+		return false
+	}
+
+	p.failures++
+	n.logger.Debugw("peer failed ping", "addr", p.version.ListenAddr, "failures", p.failures, "err", err)
+	return p.failures >= maxPingFailures
+}
+
+// Handshake adds a connecting node to the peers. The node proves its
+// identity by signing a challenge sent to its listen address, the response
+// proves ours by signing the challenge of the node.
+func (n *Node) Handshake(ctx context.Context, v *proto.Version) (*proto.Version, error) {
+	if err := n.checkVersion(ctx, v); err != nil {
+		return nil, err
+	}
+
+	c, err := n.verifyListenAddr(ctx, v)
+	if err != nil {
+		n.misbehaved(ctx, penaltyInvalid, err)
+		return nil, err
+	}
+
+	if err := n.addPeer(c, v, peerAddr(ctx)); err != nil {
+		closeClient(c)
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	n.addrBook.Good(v.ListenAddr)
+
+	resp := n.getVersion()
+	resp.Signature = signChallenge(n.nodeKey, v.Challenge)
+	return resp, nil
+}
+
+// checkVersion checks the version a connecting node sent in its handshake
+func (n *Node) checkVersion(ctx context.Context, v *proto.Version) error {
+	if v.ListenAddr == "" {
+		n.misbehaved(ctx, penaltyMalformed, errors.New("missing listen address"))
+		return status.Error(codes.InvalidArgument, "missing listen address")
+	}
+	if _, err := crypto.PublicKeyFromBytes(v.NodeId); err != nil {
+		n.misbehaved(ctx, penaltyMalformed, err)
+		return status.Errorf(codes.InvalidArgument, "invalid node id: %v", err)
+	}
+	if len(v.Challenge) != challengeLen {
+		n.misbehaved(ctx, penaltyMalformed, errors.New("invalid challenge"))
+		return status.Errorf(codes.InvalidArgument, "challenge must be %d bytes", challengeLen)
+	}
+	if nodeID(v.NodeId) == n.id {
+		return status.Error(codes.InvalidArgument, "can't connect to itself")
+	}
+	if err := n.checkCompatible(v); err != nil {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if n.TLS != nil && n.TLS.Mutual {
+		p, ok := grpcpeer.FromContext(ctx)
+		if !ok {
+			return status.Error(codes.PermissionDenied, "unknown remote address")
+		}
+		if err := n.verifyPeerCertificate(p.AuthInfo, v.NodeId); err != nil {
+			n.misbehaved(ctx, penaltyInvalid, err)
+			return status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+	return nil
+}
+
+// verifyListenAddr checks that the listen address a connecting node claims
+// is on the host it connects from and that the node answering there holds
+// the node key of the one that connected, before the address is dialed back
+// for good. A listen address without host is taken to be on the host the
+// node connects from. The client returned is connected to the address.
+func (n *Node) verifyListenAddr(ctx context.Context, v *proto.Version) (proto.NodeClient, error) {
+	host, port, err := net.SplitHostPort(v.ListenAddr)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid listen address: %v", err)
+	}
+
+	p, ok := grpcpeer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil, status.Error(codes.PermissionDenied, "unknown remote address")
+	}
+	remoteHost, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "invalid remote address: %v", err)
+	}
+
+	addr := v.ListenAddr
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		addr = net.JoinHostPort(remoteHost, port)
+	} else if !hostHasIP(ctx, host, net.ParseIP(remoteHost)) {
+		return nil, status.Errorf(codes.PermissionDenied, "listen address %s doesn't belong to %s", v.ListenAddr, p.Addr)
+	}
+
+	c, err := n.makeNodeClient(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := n.pingListenAddr(ctx, c, v); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// pingListenAddr pings the listen address of the node with the version and
+// checks that the node answering there proves it holds its node key
+func (n *Node) pingListenAddr(ctx context.Context, c proto.NodeClient, v *proto.Version) error {
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	challenge := newChallenge()
+	hb, err := c.Ping(pingCtx, &proto.Heartbeat{Height: int32(n.chain.Height()), Challenge: challenge})
+	if err != nil {
+		return status.Errorf(codes.PermissionDenied, "listen address %s is unreachable: %v", v.ListenAddr, err)
+	}
+	if !bytes.Equal(hb.NodeId, v.NodeId) {
+		return status.Errorf(codes.PermissionDenied, "listen address %s belongs to another node", v.ListenAddr)
+	}
+	if err := verifyChallenge(hb.NodeId, challenge, hb.Signature); err != nil {
+		return status.Errorf(codes.PermissionDenied, "node at %s failed to prove its identity: %v", v.ListenAddr, err)
+	}
+	return nil
+}
+
+// hostHasIP returns whether the host name or IP resolves to the IP
+func hostHasIP(ctx context.Context, host string, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addPeer adds a connected node, whose identity was verified, to the peers.
+// Peers that connected to the node have their remote address set, they evict
+// another inbound peer when all inbound slots are taken. Outbound peers are
+// only added while there are outbound slots left.
+func (n *Node) addPeer(client proto.NodeClient, version *proto.Version, remoteAddr string) error {
+	id := nodeID(version.NodeId)
+	if id == n.id {
+		return fmt.Errorf("can't connect to itself")
+	}
+
+	n.peerLock.Lock()
+	defer n.peerLock.Unlock()
+
+	// Stop disconnects the peers once, later ones would stay connected
+	if n.ctx.Err() != nil {
+		return errStopped
+	}
+
+	// Both nodes may dial each other at the same time, keep the first
+	// connection
+	if _, ok := n.peers[id]; ok {
+		return fmt.Errorf("already connected to %s", id)
+	}
+
+	inbound := remoteAddr != ""
+	if inbound {
+		if n.countPeers(true) >= n.MaxInboundPeers {
+			victim := n.evictionCandidate()
+			if victim == "" {
+				return fmt.Errorf("all inbound slots are taken")
+			}
+			n.logger.Debugw("evicting peer", "addr", n.peers[victim].version.ListenAddr, "for", version.ListenAddr)
+			closeClient(n.peers[victim].client)
+			delete(n.peers, victim)
+		}
+	} else if n.countPeers(false) >= n.MaxOutboundPeers {
+		return fmt.Errorf("all outbound slots are taken")
+	}
+
+	n.peers[id] = &peer{
+		client:      client,
+		version:     version,
+		protocol:    agreedVersion(version),
+		inbound:     inbound,
+		connectedAt: time.Now(),
+		remoteAddr:  remoteAddr,
+		known:       newInventorySet(maxKnownInventory),
+	}
+	n.logger.Debugw("new peer successfully connected", "id", id, "addr", version.ListenAddr, "inbound", inbound)
+
+	n.run(func() {
+		if err := n.syncPeer(client, int(version.Height)); err != nil {
+			n.logger.Debugw("failed to sync peer", "addr", version.ListenAddr, "err", err)
+		}
+	})
+
+	// Connect to all peers in the received list of peers
+	if len(version.PeerList) > 0 {
+		n.run(func() {
+			n.addrBook.Add(version.PeerList...)
+			n.bootstrapNetwork(version.PeerList)
+		})
+	}
+
+	return nil
+}
+
+// countPeers returns the number of inbound or outbound peers, the caller has
+// to hold peerLock
+func (n *Node) countPeers(inbound bool) int {
+	count := 0
+	for _, p := range n.peers {
+		if p.inbound == inbound {
+			count++
+		}
+	}
+	return count
+}
+
+// evictionCandidate returns the inbound peer to make room for a new one, if
+// any. Bootstrap nodes and the longest connected half of the peers are kept,
+// of the others the peer that sent the fewest useful messages goes, the most
+// recently connected one among equals. The caller has to hold peerLock.
+func (n *Node) evictionCandidate() string {
+	var candidates []string
+	for id, p := range n.peers {
+		if p.inbound && !n.isBootstrapNode(p.version.ListenAddr) {
+			candidates = append(candidates, id)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return n.peers[candidates[i]].connectedAt.Before(n.peers[candidates[j]].connectedAt)
+	})
+	candidates = candidates[len(candidates)/2:]
+
+	var victim string
+	for _, id := range candidates {
+		if victim == "" {
+			victim = id
+			continue
+		}
+		p, v := n.peers[id], n.peers[victim]
+		if p.useful < v.useful || (p.useful == v.useful && p.connectedAt.After(v.connectedAt)) {
+			victim = id
+		}
+	}
+	return victim
+}
+
+// credit counts a useful message towards the inbound peer that sent it
+func (n *Node) credit(ctx context.Context) {
+	addr := peerAddr(ctx)
+
+	n.peerLock.Lock()
+	defer n.peerLock.Unlock()
+
+	for _, p := range n.peers {
+		if p.remoteAddr == addr {
+			p.useful++
+			return
+		}
+	}
+}
+
+// senderOf returns the peer that sent an RPC. Messages on a stream are sent
+// by the peer of the stream, other RPCs by the inbound peer connected from
+// the same address or else the only peer on the host they come from.
+func (n *Node) senderOf(ctx context.Context) (*peer, bool) {
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+
+	if ps, ok := ctx.Value(peerStreamKey{}).(*peerStream); ok {
+		for _, p := range n.peers {
+			if p.client == proto.NodeClient(ps) {
+				return p, true
+			}
+		}
+		return nil, false
+	}
+
+	var (
+		addr   = peerAddr(ctx)
+		sender *peer
+		count  int
+	)
+	for _, p := range n.peers {
+		if p.remoteAddr == addr {
+			return p, true
+		}
+		if p.isOn(hostOf(addr)) {
+			sender = p
+			count++
+		}
+	}
+	return sender, count == 1
+}
+
+// syncPeer sends a peer the blocks it missed while it wasn't connected or
+// followed another chain, starting after height, the height the peer
+// reported. When the peer doesn't know the parent of the first block, because
+// its chain forked off below height, syncing starts over further back, twice
+// as far every time, down to the block after the genesis block.
+func (n *Node) syncPeer(client proto.NodeClient, height int) error {
+	// The genesis block is part of the configuration of every node
+	from := height + 1
+	if from > n.chain.Height() {
+		from = n.chain.Height()
+	}
+	for step := 1; ; step *= 2 {
+		if from < 1 {
+			from = 1
+		}
+
+		err := n.syncFrom(client, from)
+		if status.Code(err) != codes.FailedPrecondition || from == 1 {
+			return err
+		}
+		from -= step
+	}
+}
+
+// syncFrom sends a peer the blocks of the chain from the height on, the error
+// of the first block is returned as is
+func (n *Node) syncFrom(client proto.NodeClient, from int) error {
+	for height := from; height <= n.chain.Height(); height++ {
+		block, err := n.chain.GetBlockByHeight(height)
+		if err != nil {
+			return err
+		}
+
+		err = n.send(client, block)
+		if err != nil && height == from {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("sync block %d: %w", height, err)
+		}
+	}
+
+	return nil
+}
+
+// guard rejects the RPCs of banned peers and counts the messages of every
+// peer, a peer sending more than spamLimit messages a second misbehaves
+func (n *Node) guard(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := n.admit(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// guardStream rejects the streams of banned peers, the messages on the
+// stream are counted as they arrive
+func (n *Node) guardStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if n.banList.IsBanned(hostOf(peerAddr(ss.Context()))) {
+		return status.Error(codes.PermissionDenied, "banned")
+	}
+	return handler(srv, ss)
+}
+
+// admit returns why a message of the sender of an RPC is rejected, if it is
+func (n *Node) admit(ctx context.Context) error {
+	addr := peerAddr(ctx)
+	if n.banList.IsBanned(hostOf(addr)) {
+		return status.Error(codes.PermissionDenied, "banned")
+	}
+
+	if n.scores.Count(addr) > spamLimit {
+		n.misbehaved(ctx, penaltySpam, errors.New("too many messages"))
+		return status.Error(codes.ResourceExhausted, "too many messages")
+	}
+	return nil
+}
+
+// misbehaved adds penalty points to the score of the sender of an RPC and
+// bans its host once the score reaches banThreshold
+func (n *Node) misbehaved(ctx context.Context, points int, reason error) {
+	p, ok := grpcpeer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return
+	}
+
+	addr := p.Addr.String()
+	score := n.scores.Add(addr, points)
+	n.logger.Debugw("peer misbehaved", "addr", addr, "score", score, "reason", reason)
+
+	if score >= banThreshold {
+		if err := n.ban(hostOf(addr), n.BanDuration); err != nil {
+			n.logger.Errorw("failed to store ban", "addr", addr, "err", err)
+		}
+	}
+}
+
+// ban bans the host for the duration and disconnects from its peers
+func (n *Node) ban(host string, duration time.Duration) error {
+	err := n.banList.Ban(host, time.Now().Add(duration))
+	n.scores.Remove(host)
+
+	n.peerLock.RLock()
+	var ids []string
+	for id, p := range n.peers {
+		if p.isOn(host) {
+			ids = append(ids, id)
+		}
+	}
+	n.peerLock.RUnlock()
+
+	for _, id := range ids {
+		n.removePeer(id)
+	}
+
+	n.logger.Infow("banned peer", "host", host, "duration", duration)
+	return err
+}
+
+// removePeer disconnects from a peer, bootstrap nodes are dialed again until
+// they are back
+func (n *Node) removePeer(id string) {
+	n.peerLock.Lock()
+	p, ok := n.peers[id]
+	delete(n.peers, id)
+	n.peerLock.Unlock()
+
+	if !ok {
+		return
+	}
+	closeClient(p.client)
+
+	n.logger.Debugw("removed peer", "addr", p.version.ListenAddr)
+	if n.isBootstrapNode(p.version.ListenAddr) {
+		n.run(func() { n.reconnect(p.version.ListenAddr) })
+	}
+}
+
+// disconnectPeers closes the connections to all peers
+func (n *Node) disconnectPeers() {
+	n.peerLock.Lock()
+	peers := n.peers
+	n.peers = map[string]*peer{}
+	n.peerLock.Unlock()
+
+	for _, p := range peers {
+		closeClient(p.client)
+	}
+}
+
+func (n *Node) isBootstrapNode(addr string) bool {
+	for _, bootstrapAddr := range n.bootstrapNodes {
+		if addr == bootstrapAddr {
+			return true
+		}
+	}
+	return false
+}
+
+// reconnect dials a bootstrap node until it is connected again, waiting
+// longer after every failed attempt. Only one reconnect per address runs at a
+// time.
+func (n *Node) reconnect(addr string) {
+	n.peerLock.Lock()
+	if n.reconnecting[addr] {
+		n.peerLock.Unlock()
+		return
+	}
+	n.reconnecting[addr] = true
+	n.peerLock.Unlock()
+
+	defer func() {
+		n.peerLock.Lock()
+		delete(n.reconnecting, addr)
+		n.peerLock.Unlock()
+	}()
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-time.After(reconnectBackoff(attempt)):
+		case <-n.ctx.Done():
+			return
+		}
+
+		// The node may have connected to us in the meantime
+		if !n.canConnectWith(addr) {
+			return
+		}
+
+		client, version, err := n.dialRemoteNode(addr)
+		if err != nil {
+			n.logger.Debugw("reconnect failed", "remote", addr, "attempt", attempt, "err", err)
+			continue
+		}
+
+		if err := n.addPeer(client, version, ""); err != nil {
+			n.logger.Debugw("dropped peer", "remote", addr, "err", err)
+			closeClient(client)
+		}
+		return
+	}
+}
+
+// reconnectBackoff returns how long to wait before the given attempt to
+// reconnect to a node
+func reconnectBackoff(attempt int) time.Duration {
+	backoff := minReconnectBackoff
+	for i := 0; i < attempt && backoff < maxReconnectBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxReconnectBackoff {
+		return maxReconnectBackoff
+	}
+	return backoff
+}
+
+func (n *Node) bootstrapNetwork(addrs []string) error {
+	for _, addr := range addrs {
+		if n.ctx.Err() != nil {
+			break
+		}
+		if addr == n.ListenAddr {
+			continue
+		}
+
+		if !n.canConnectWith(addr) {
+			continue
+		}
+
+		if !n.hasOutboundSlot() {
+			n.logger.Debugw("all outbound slots are taken", "remote", addr)
+			break
+		}
+
+		n.logger.Debugw("dialing remote nodes", "remote", addr)
+		client, version, err := n.dialRemoteNode(addr)
+		if err != nil {
+			n.logger.Error("dial error: ", err)
+			if n.isBootstrapNode(addr) {
+				n.run(func() { n.reconnect(addr) })
+			}
+			continue
+		}
+
+		if err := n.addPeer(client, version, ""); err != nil {
+			n.logger.Debugw("dropped peer", "remote", addr, "err", err)
+			closeClient(client)
+		}
+	}
+
+	return nil
+}
+
+func (n *Node) hasOutboundSlot() bool {
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+
+	return n.countPeers(false) < n.MaxOutboundPeers
+}
+
+// dialRemoteNode connects to a node, checks that it holds the node key it
+// claims and records the outcome in the address book. The node is talked to
+// over a Connect stream unless it doesn't serve them.
+func (n *Node) dialRemoteNode(addr string) (proto.NodeClient, *proto.Version, error) {
+	c, err := n.makeNodeClient(addr)
+	if err != nil {
+		n.addrBook.Attempt(addr)
+		return nil, nil, err
+	}
+
+	// The stream owns the connection, a node without streams is talked to
+	// over the connection itself
+	client, v, err := n.openStream(c)
+	if status.Code(err) == codes.Unimplemented {
+		client, v, err = n.handshake(c)
+	}
+	if err != nil {
+		c.Close()
+	}
+	if status.Code(err) == codes.FailedPrecondition || errors.Is(err, errIncompatible) {
+		// The node won't become compatible by dialing it again
+		n.addrBook.Remove(addr)
+		return nil, nil, fmt.Errorf("node at %s: %w", addr, err)
+	}
+	if err != nil {
+		n.addrBook.Attempt(addr)
+		return nil, nil, fmt.Errorf("node at %s: %w", addr, err)
+	}
+
+	n.addrBook.Good(addr)
+	return client, v, nil
+}
+
+// handshake adds the node of the client to the peers of the node over a
+// Handshake RPC, the node dials back to send its messages
+func (n *Node) handshake(c proto.NodeClient) (proto.NodeClient, *proto.Version, error) {
+	var (
+		version = n.getVersion()
+		remote  grpcpeer.Peer
+	)
+	version.Challenge = newChallenge()
+	v, err := c.Handshake(n.ctx, version, grpc.Peer(&remote))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := n.checkPeerVersion(v, version.Challenge, remote.AuthInfo); err != nil {
+		return nil, nil, err
+	}
+
+	return c, v, nil
+}
+
+// checkPeerVersion checks the version a node answered a handshake with
+func (n *Node) checkPeerVersion(v *proto.Version, challenge []byte, authInfo credentials.AuthInfo) error {
+	if err := n.checkCompatible(v); err != nil {
+		return err
+	}
+	if err := verifyChallenge(v.NodeId, challenge, v.Signature); err != nil {
+		return fmt.Errorf("node failed to prove its identity: %w", err)
+	}
+	if err := n.verifyPeerCertificate(authInfo, v.NodeId); err != nil {
+		return fmt.Errorf("node failed to prove its identity: %w", err)
+	}
+	return nil
+}
+
+// GetPeers returns addresses of nodes of the network, the connected peers
+// first and then the most recently seen addresses of the address book
+func (n *Node) GetPeers(ctx context.Context, req *proto.GetPeersRequest) (*proto.PeerAddrs, error) {
+	var (
+		addrs = []string{}
+		seen  = map[string]bool{}
+	)
+	for _, addr := range append(n.getPeerList(), n.addrBook.List(maxPeerAddrs)...) {
+		if len(addrs) == maxPeerAddrs {
+			break
+		}
+		if seen[addr] || n.banList.IsBanned(hostOf(addr)) {
+			continue
+		}
+		seen[addr] = true
+		addrs = append(addrs, addr)
+	}
+
+	return &proto.PeerAddrs{Addrs: addrs}, nil
+}
+
+// discoveryLoop regularly learns addresses from the peers, connects to the
+// nodes of the address book while outbound slots are free and stores the
+// book. It starts right away so a node whose bootstrap nodes are unreachable
+// connects to the nodes it knew before.
+func (n *Node) discoveryLoop() {
+	ticker := time.NewTicker(discoveryInterval)
+	defer ticker.Stop()
+	for {
+		n.exchangeAddrs()
+		n.dialFromAddrBook()
+		if err := n.addrBook.Save(); err != nil {
+			n.logger.Errorw("failed to store address book", "err", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
+
+// exchangeAddrs adds the addresses a random peer serving them knows to the
+// address book
+func (n *Node) exchangeAddrs() {
+	n.peerLock.RLock()
+	var client proto.NodeClient
+	for _, p := range n.peers {
+		if p.has(FeatureGetPeers) {
+			client = p.client
+			break
+		}
+	}
+	n.peerLock.RUnlock()
+
+	if client == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(n.ctx, pingTimeout)
+	defer cancel()
+
+	resp, err := client.GetPeers(ctx, &proto.GetPeersRequest{})
+	if err != nil {
+		n.logger.Debugw("failed to get peers", "err", err)
+		return
+	}
+
+	addrs := resp.Addrs
+	if len(addrs) > maxPeerAddrs {
+		addrs = addrs[:maxPeerAddrs]
+	}
+	n.addrBook.Add(addrs...)
+}
+
+// dialFromAddrBook connects to nodes of the address book until the outbound
+// slots are taken
+func (n *Node) dialFromAddrBook() {
+	n.peerLock.RLock()
+	free := n.MaxOutboundPeers - n.countPeers(false)
+	n.peerLock.RUnlock()
+
+	if free <= 0 {
+		return
+	}
+
+	for _, addr := range n.addrBook.Select(free, n.canConnectWith) {
+		n.logger.Debugw("dialing node of the address book", "remote", addr)
+		client, version, err := n.dialRemoteNode(addr)
+		if err != nil {
+			n.logger.Debugw("dial error", "remote", addr, "err", err)
+			continue
+		}
+
+		if err := n.addPeer(client, version, ""); err != nil {
+			n.logger.Debugw("dropped peer", "remote", addr, "err", err)
+			closeClient(client)
+		}
+	}
+}
+
+func (n *Node) getVersion() *proto.Version {
+	return &proto.Version{
+		Version:    n.Version,
+		Height:     int32(n.chain.Height()),
+		ListenAddr: n.ListenAddr,
+		PeerList:   n.getPeerList(),
+		NodeId:     n.nodeKey.Public().Bytes(),
+
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: minProtocolVersion,
+		ChainId:            n.ChainID,
+		Features:           uint64(supportedFeatures),
+	}
+}
+
+func (n *Node) canConnectWith(addr string) bool {
+	// Don't attempt to connect with itself
+	if n.ListenAddr == addr {
+		return false
+	}
+
+	if n.banList.IsBanned(hostOf(addr)) {
+		return false
+	}
+
+	// Don't attempt to connect with already connected peers
+	connectedPeers := n.getPeerList()
+	for _, connectedAddr := range connectedPeers {
+		if addr == connectedAddr {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (n *Node) getPeerList() []string {
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+
+	peers := []string{}
+
+	for _, p := range n.peers {
+		peers = append(peers, p.version.ListenAddr)
+	}
+
+	return peers
+}
+
+// peerAddr returns the remote address of the caller of an RPC, if known
+func peerAddr(ctx context.Context) string {
+	p, ok := grpcpeer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+	return p.Addr.String()
+}
+
+// closeClient closes the connection of a client, if it has one of its own
+func closeClient(client proto.NodeClient) {
+	if c, ok := client.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// nodeClient is a client of a node over a connection of its own, which is
+// closed with the client
+type nodeClient struct {
+	proto.NodeClient
+	conn *grpc.ClientConn
+}
+
+func (c *nodeClient) Close() error {
+	return c.conn.Close()
 }
 
-func makeNodeClient(listenAddr string) (proto.NodeClient, error) {
-	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
-	c, err := grpc.Dial(listenAddr, opts...)
+// makeNodeClient returns a client of the node at the address, connected
+// with the credentials of the node. The caller closes the client.
+func (n *Node) makeNodeClient(listenAddr string) (*nodeClient, error) {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(n.creds)}
+	conn, err := grpc.Dial(listenAddr, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	return proto.NewNodeClient(c), nil
+	return &nodeClient{NodeClient: proto.NewNodeClient(conn), conn: conn}, nil
 }
@@ -0,0 +1,1046 @@
+package node
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/consensus"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+	"github.com/webstradev/blockstra/util"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func newTestNode(cfg ServerConfig) *Node {
+	return New(cfg, zap.NewNop().Sugar(), []string{})
+}
+
+// testVersion returns the version of a node with a new node key listening at
+// addr
+func testVersion(addr string) *proto.Version {
+	return &proto.Version{
+		ListenAddr: addr,
+		NodeId:     crypto.MustGeneratePrivateKey().Public().Bytes(),
+		Challenge:  newChallenge(),
+
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: minProtocolVersion,
+		Features:           uint64(supportedFeatures),
+	}
+}
+
+// testClient is a peer that answers pings and takes blocks unless it is down
+type testClient struct {
+	proto.NodeClient
+
+	lock   sync.Mutex
+	down   bool
+	blocks []*proto.Block
+	votes  int
+	// txx holds the transactions sent to the peer, announced the hashes
+	// announced to it and serves the transactions it hands out over GetData
+	txx       []*proto.Transaction
+	announced [][]byte
+	serves    []*proto.Transaction
+	requests  int
+}
+
+func (c *testClient) setDown(down bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.down = down
+}
+
+func (c *testClient) Ping(ctx context.Context, hb *proto.Heartbeat, opts ...grpc.CallOption) (*proto.Heartbeat, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.down {
+		return nil, status.Error(codes.Unavailable, "peer is down")
+	}
+	return &proto.Heartbeat{}, nil
+}
+
+func (c *testClient) HandleVote(ctx context.Context, v *proto.Vote, opts ...grpc.CallOption) (*proto.Ack, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.votes++
+	return &proto.Ack{}, nil
+}
+
+func (c *testClient) HandleTransaction(ctx context.Context, tx *proto.Transaction, opts ...grpc.CallOption) (*proto.Ack, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.txx = append(c.txx, tx)
+	return &proto.Ack{}, nil
+}
+
+func (c *testClient) HandleInventory(ctx context.Context, inv *proto.Inventory, opts ...grpc.CallOption) (*proto.Ack, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.announced = append(c.announced, inv.TxHashes...)
+	return &proto.Ack{}, nil
+}
+
+func (c *testClient) GetData(ctx context.Context, inv *proto.Inventory, opts ...grpc.CallOption) (*proto.Transactions, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.requests++
+	return &proto.Transactions{Transactions: c.serves}, nil
+}
+
+func (c *testClient) HandleBlock(ctx context.Context, block *proto.Block, opts ...grpc.CallOption) (*proto.Ack, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.down {
+		return nil, status.Error(codes.Unavailable, "peer is down")
+	}
+	c.blocks = append(c.blocks, block)
+	return &proto.Ack{}, nil
+}
+
+func TestHandleTransactionRejectsMalformed(t *testing.T) {
+	n := newTestNode(ServerConfig{ListenAddr: ":3000"})
+
+	tx := &proto.Transaction{
+		Inputs: []*proto.TxInput{{PublicKey: []byte{1, 2}, Signature: []byte{3}}},
+	}
+
+	_, err := n.HandleTransaction(context.Background(), tx)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.False(t, n.memPool.Has(tx))
+}
+
+func TestHandleTransaction(t *testing.T) {
+	var (
+		n       = newTestNode(ServerConfig{ListenAddr: ":3000"})
+		privKey = crypto.MustGeneratePrivateKey()
+		genesis = types.NewGenesisBlock(0, []types.GenesisAllocation{
+			{Address: privKey.Public().Address(), Amount: 100},
+		})
+	)
+	assert.NoError(t, n.chain.AddBlock(genesis))
+
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs: []*proto.TxInput{
+			{PrevTxHash: types.MustHashTransaction(genesis.Transactions[0]), PublicKey: privKey.Public().Bytes()},
+		},
+		Outputs: []*proto.TxOutput{
+			{Amount: 99, Address: privKey.Public().Address().Bytes()},
+		},
+	}
+	tx.Inputs[0].Signature = types.MustSignTransaction(privKey, tx).Bytes()
+
+	_, err := n.HandleTransaction(context.Background(), tx)
+	assert.NoError(t, err)
+	assert.True(t, n.memPool.Has(tx))
+
+	// Spending an output that doesn't exist is rejected
+	tx.Inputs[0].PrevTxHash = util.RandomHash()
+	tx.Inputs[0].Signature = types.MustSignTransaction(privKey, tx).Bytes()
+
+	_, err = n.HandleTransaction(context.Background(), tx)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHandshakeRejectsMissingListenAddr(t *testing.T) {
+	n := newTestNode(ServerConfig{ListenAddr: ":3000"})
+
+	_, err := n.Handshake(context.Background(), &proto.Version{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHandleTransactionTimeLocked(t *testing.T) {
+	var (
+		n       = newTestNode(ServerConfig{ListenAddr: ":3000"})
+		privKey = crypto.MustGeneratePrivateKey()
+		genesis = types.NewGenesisBlock(0, []types.GenesisAllocation{
+			{Address: privKey.Public().Address(), Amount: 100},
+		})
+	)
+	assert.NoError(t, n.chain.AddBlock(genesis))
+
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs: []*proto.TxInput{
+			{PrevTxHash: types.MustHashTransaction(genesis.Transactions[0]), PublicKey: privKey.Public().Bytes()},
+		},
+		Outputs: []*proto.TxOutput{
+			{Amount: 99, Address: privKey.Public().Address().Bytes()},
+		},
+		LockTime: &proto.TimeLock{Timestamp: time.Now().Add(time.Hour).UnixNano()},
+	}
+	tx.Inputs[0].Signature = types.MustSignTransaction(privKey, tx).Bytes()
+
+	_, err := n.HandleTransaction(context.Background(), tx)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.False(t, n.memPool.Has(tx))
+}
+
+func TestHandleTransactionPenalizesOnlyPeersAtFault(t *testing.T) {
+	var (
+		n       = newTestNode(ServerConfig{ListenAddr: ":3000"})
+		privKey = crypto.MustGeneratePrivateKey()
+		genesis = types.NewGenesisBlock(0, []types.GenesisAllocation{
+			{Address: privKey.Public().Address(), Amount: 100},
+		})
+		ctx = fromAddr(t, "10.0.0.1:50000")
+	)
+	assert.NoError(t, n.chain.AddBlock(genesis))
+
+	spend := func(key *crypto.PrivateKey, prevHash []byte, lock *proto.TimeLock) *proto.Transaction {
+		tx := &proto.Transaction{
+			Version:  1,
+			Inputs:   []*proto.TxInput{{PrevTxHash: prevHash, PublicKey: key.Public().Bytes()}},
+			Outputs:  []*proto.TxOutput{{Amount: 99, Address: key.Public().Address().Bytes()}},
+			LockTime: lock,
+		}
+		tx.Inputs[0].Signature = types.MustSignTransaction(key, tx).Bytes()
+		return tx
+	}
+	genesisHash := types.MustHashTransaction(genesis.Transactions[0])
+
+	// Transactions that may have been valid when the peer relayed them are
+	// rejected without penalty
+	for _, tx := range []*proto.Transaction{
+		spend(privKey, make([]byte, 32), nil),
+		spend(privKey, genesisHash, &proto.TimeLock{Timestamp: time.Now().Add(time.Hour).UnixNano()}),
+	} {
+		_, err := n.HandleTransaction(ctx, tx)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+	assert.Equal(t, 0, n.scores.Get("10.0.0.1:50000"))
+
+	// Spending an output of another key never was valid
+	_, err := n.HandleTransaction(ctx, spend(crypto.MustGeneratePrivateKey(), genesisHash, nil))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, penaltyInvalid, n.scores.Get("10.0.0.1:50000"))
+}
+
+func TestGetOutput(t *testing.T) {
+	var (
+		n       = newTestNode(ServerConfig{ListenAddr: ":3000"})
+		address = crypto.MustGeneratePrivateKey().Public().Address()
+		genesis = types.NewGenesisBlock(0, []types.GenesisAllocation{{Address: address, Amount: 100}})
+		txHash  = types.MustHashTransaction(genesis.Transactions[0])
+	)
+	assert.NoError(t, n.chain.AddBlock(genesis))
+
+	output, err := n.GetOutput(context.Background(), &proto.OutPoint{TxHash: txHash, Index: 0})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), output.Amount)
+
+	_, err = n.GetOutput(context.Background(), &proto.OutPoint{TxHash: txHash, Index: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = n.GetOutput(context.Background(), &proto.OutPoint{TxHash: []byte{1}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHasActivity(t *testing.T) {
+	var (
+		n       = newTestNode(ServerConfig{ListenAddr: ":3000", Network: crypto.TestNet})
+		address = crypto.MustGeneratePrivateKey().Public().Address()
+		unused  = crypto.MustGeneratePrivateKey().Public().Address()
+	)
+	assert.NoError(t, n.chain.AddBlock(types.NewGenesisBlock(0, []types.GenesisAllocation{{Address: address, Amount: 100}})))
+
+	activity, err := n.HasActivity(context.Background(), &proto.ActivityRequest{Address: address.Encode(crypto.TestNet)})
+	assert.NoError(t, err)
+	assert.True(t, activity.Active)
+
+	activity, err = n.HasActivity(context.Background(), &proto.ActivityRequest{Address: unused.Encode(crypto.TestNet)})
+	assert.NoError(t, err)
+	assert.False(t, activity.Active)
+
+	// Addresses of other networks and hex addresses are rejected
+	_, err = n.HasActivity(context.Background(), &proto.ActivityRequest{Address: address.Encode(crypto.MainNet)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = n.HasActivity(context.Background(), &proto.ActivityRequest{Address: address.String()})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestProposeAndHandleBlock(t *testing.T) {
+	var (
+		validator = crypto.MustGeneratePrivateKey()
+		privKey   = crypto.MustGeneratePrivateKey()
+		genesis   = &types.Genesis{
+			Timestamp:   time.Now().Add(-time.Hour).UnixNano(),
+			Allocations: []types.GenesisAllocation{{Address: privKey.Public().Address(), Amount: 100}},
+			Validators:  []*crypto.PublicKey{validator.Public()},
+		}
+		proposer = newTestNode(ServerConfig{ListenAddr: ":3000", PrivateKey: validator, Genesis: genesis})
+		follower = newTestNode(ServerConfig{ListenAddr: ":4000", Genesis: genesis})
+	)
+	assert.True(t, proposer.isValidator())
+	assert.False(t, follower.isValidator())
+
+	for _, n := range []*Node{proposer, follower} {
+		assert.NoError(t, n.chain.AddBlock(genesis.Block()))
+	}
+
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs: []*proto.TxInput{
+			{PrevTxHash: types.MustHashTransaction(genesis.Block().Transactions[0]), PublicKey: privKey.Public().Bytes()},
+		},
+		Outputs: []*proto.TxOutput{
+			{Amount: 99, Address: privKey.Public().Address().Bytes()},
+		},
+	}
+	tx.Inputs[0].Signature = types.MustSignTransaction(privKey, tx).Bytes()
+
+	for _, n := range []*Node{proposer, follower} {
+		_, err := n.HandleTransaction(context.Background(), tx)
+		assert.NoError(t, err)
+	}
+
+	// A node that isn't a validator never proposes
+	_, err := follower.proposeBlock()
+	assert.ErrorIs(t, err, consensus.ErrNotProposer)
+
+	block, err := proposer.proposeBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, []*proto.Transaction{tx}, block.Transactions)
+	assert.Equal(t, 1, proposer.chain.Height())
+	assert.Equal(t, 0, proposer.memPool.Len())
+
+	_, err = follower.HandleBlock(context.Background(), block)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, follower.chain.Height())
+	assert.Equal(t, 0, follower.memPool.Len())
+
+	// Known blocks are acknowledged without being added again
+	_, err = follower.HandleBlock(context.Background(), block)
+	assert.NoError(t, err)
+
+	// Peers can't replace the genesis block
+	_, err = follower.HandleBlock(context.Background(), types.NewGenesisBlock(1, genesis.Allocations))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = follower.HandleBlock(context.Background(), &proto.Block{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHandleBlockRejectsUnsigned(t *testing.T) {
+	var (
+		genesis = &types.Genesis{
+			Timestamp:  time.Now().Add(-time.Hour).UnixNano(),
+			Validators: []*crypto.PublicKey{crypto.MustGeneratePrivateKey().Public()},
+		}
+		n = newTestNode(ServerConfig{ListenAddr: ":3000", Genesis: genesis})
+	)
+	assert.NoError(t, n.chain.AddBlock(genesis.Block()))
+
+	tip := n.chain.Tip()
+	block := &proto.Block{
+		Header: &proto.Header{
+			Version:   "1",
+			Height:    1,
+			PrevHash:  types.MustHashHeader(tip),
+			Timestamp: tip.Timestamp + int64(defaultBlockTime),
+		},
+	}
+
+	_, err := n.HandleBlock(context.Background(), block)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, 0, n.chain.Height())
+}
+
+func TestFinalizeBlocks(t *testing.T) {
+	var (
+		validator = crypto.MustGeneratePrivateKey()
+		genesis   = &types.Genesis{
+			Timestamp:  time.Now().Add(-time.Hour).UnixNano(),
+			Validators: []*crypto.PublicKey{validator.Public()},
+		}
+		proposer = newTestNode(ServerConfig{ListenAddr: ":3000", PrivateKey: validator, Genesis: genesis})
+		follower = newTestNode(ServerConfig{ListenAddr: ":4000", Genesis: genesis})
+	)
+
+	for _, n := range []*Node{proposer, follower} {
+		assert.NoError(t, n.chain.AddBlock(genesis.Block()))
+	}
+
+	// The only validator finalizes its own blocks right away
+	block, err := proposer.proposeBlock()
+	assert.NoError(t, err)
+	proposer.advanceFinality()
+	assert.Equal(t, 1, proposer.chain.Finalized())
+	assert.Equal(t, int32(2), proposer.finality.Height())
+
+	// The follower finalizes the block once it sees the precommit
+	_, err = follower.HandleBlock(context.Background(), block)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, follower.chain.Finalized())
+
+	final, err := proposer.chain.GetBlockByHeight(1)
+	assert.NoError(t, err)
+	assert.NotNil(t, final.Commit)
+
+	for _, v := range final.Commit.Precommits {
+		_, err := follower.HandleVote(context.Background(), v)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, follower.chain.Finalized())
+
+	// Votes of unknown validators are rejected
+	v := &proto.Vote{Height: 2, BlockHash: util.RandomHash(), PublicKey: crypto.MustGeneratePrivateKey().Public().Bytes()}
+	_, err = follower.HandleVote(context.Background(), v)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHandleBlockWithCommit(t *testing.T) {
+	var (
+		validator = crypto.MustGeneratePrivateKey()
+		genesis   = &types.Genesis{
+			Timestamp:  time.Now().Add(-time.Hour).UnixNano(),
+			Validators: []*crypto.PublicKey{validator.Public()},
+		}
+		proposer = newTestNode(ServerConfig{ListenAddr: ":3000", PrivateKey: validator, Genesis: genesis})
+		follower = newTestNode(ServerConfig{ListenAddr: ":4000", Genesis: genesis})
+	)
+
+	for _, n := range []*Node{proposer, follower} {
+		assert.NoError(t, n.chain.AddBlock(genesis.Block()))
+	}
+
+	_, err := proposer.proposeBlock()
+	assert.NoError(t, err)
+	proposer.advanceFinality()
+
+	final, err := proposer.chain.GetBlockByHeight(1)
+	assert.NoError(t, err)
+
+	// A forged commit is rejected
+	forged := pb.Clone(final).(*proto.Block)
+	forged.Commit.Precommits = nil
+	_, err = follower.HandleBlock(context.Background(), forged)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = follower.HandleBlock(context.Background(), final)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, follower.chain.Finalized())
+	assert.Equal(t, int32(2), follower.finality.Height())
+}
+
+func TestProposeBlockProofOfWork(t *testing.T) {
+	var (
+		genesis = &types.Genesis{Timestamp: time.Now().Add(-time.Hour).UnixNano(), Difficulty: 16}
+		miner   = newTestNode(ServerConfig{
+			ListenAddr: ":3000",
+			PrivateKey: crypto.MustGeneratePrivateKey(),
+			Genesis:    genesis,
+			Consensus:  consensus.Config{Kind: consensus.KindProofOfWork},
+		})
+		follower = newTestNode(ServerConfig{
+			ListenAddr: ":4000",
+			Genesis:    genesis,
+			Consensus:  consensus.Config{Kind: consensus.KindProofOfWork},
+		})
+	)
+	assert.True(t, miner.isValidator())
+	assert.False(t, follower.isValidator())
+
+	for _, n := range []*Node{miner, follower} {
+		assert.NoError(t, n.chain.AddBlock(genesis.Block()))
+	}
+
+	block, err := miner.proposeBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(16), block.Header.Difficulty)
+
+	_, err = follower.HandleBlock(context.Background(), block)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, follower.chain.Height())
+}
+
+func TestSlashDoubleSigner(t *testing.T) {
+	var (
+		keys    = []*crypto.PrivateKey{crypto.MustGeneratePrivateKey(), crypto.MustGeneratePrivateKey()}
+		genesis = &types.Genesis{
+			Timestamp:  time.Now().Add(-testBlockTime * 5 / 2).UnixNano(),
+			Validators: []*crypto.PublicKey{keys[0].Public(), keys[1].Public()},
+		}
+		cfg = consensus.Config{BlockTime: testBlockTime}
+		// The second validator proposes height 1, the first one height 2
+		offender = consensus.NewProofOfAuthority(testBlockTime, keys[1])
+		proposer = newTestNode(ServerConfig{ListenAddr: ":3000", PrivateKey: keys[0], Genesis: genesis, Consensus: cfg})
+		follower = newTestNode(ServerConfig{ListenAddr: ":4000", Genesis: genesis, Consensus: cfg})
+	)
+
+	for _, n := range []*Node{proposer, follower} {
+		assert.NoError(t, n.chain.AddBlock(genesis.Block()))
+	}
+
+	tip := proposer.chain.Tip()
+	blocks := []*proto.Block{}
+	for i := 0; i < 2; i++ {
+		block := &proto.Block{
+			Header: &proto.Header{
+				Version:   "1",
+				Height:    1,
+				PrevHash:  types.MustHashHeader(tip),
+				Timestamp: tip.Timestamp + int64(testBlockTime) + int64(i),
+			},
+		}
+		assert.NoError(t, offender.Seal(context.Background(), block))
+		blocks = append(blocks, block)
+	}
+
+	// The second block competes with the first one and proves the double
+	// signing
+	for _, block := range blocks {
+		_, err := proposer.HandleBlock(context.Background(), block)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, proposer.evidencePool.Len())
+	ev := proposer.evidencePool.List()[0]
+
+	block, err := proposer.proposeBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, []*proto.Evidence{ev}, block.Evidence)
+	assert.Equal(t, 0, proposer.evidencePool.Len())
+
+	validators, err := proposer.chain.ValidatorsAt(3)
+	assert.NoError(t, err)
+	assert.False(t, validators.Contains(keys[1].Public()))
+	assert.True(t, proposer.isValidator())
+
+	// Peers relay the evidence until it is included
+	_, err = follower.HandleEvidence(context.Background(), ev)
+	assert.NoError(t, err)
+	assert.True(t, follower.evidencePool.Has(ev))
+
+	_, err = follower.HandleBlock(context.Background(), blocks[0])
+	assert.NoError(t, err)
+	_, err = follower.HandleBlock(context.Background(), block)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, follower.evidencePool.Len())
+
+	// Evidence against a slashed validator is of no use anymore
+	_, err = follower.HandleEvidence(context.Background(), ev)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, follower.evidencePool.Len())
+
+	_, err = follower.HandleEvidence(context.Background(), &proto.Evidence{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCheckPeersRemovesDeadPeers(t *testing.T) {
+	var (
+		n     = newTestNode(ServerConfig{ListenAddr: ":3000"})
+		alive = &testClient{}
+		dead  = &testClient{down: true}
+	)
+	n.addPeer(alive, testVersion(":4000"), "")
+	n.addPeer(dead, testVersion(":5000"), "")
+
+	for i := 0; i < maxPingFailures-1; i++ {
+		n.checkPeers()
+	}
+	assert.ElementsMatch(t, []string{":4000", ":5000"}, n.getPeerList())
+
+	// Answering a ping resets the failures
+	dead.setDown(false)
+	n.checkPeers()
+	dead.setDown(true)
+	for i := 0; i < maxPingFailures-1; i++ {
+		n.checkPeers()
+	}
+	assert.ElementsMatch(t, []string{":4000", ":5000"}, n.getPeerList())
+
+	n.checkPeers()
+	assert.Equal(t, []string{":4000"}, n.getPeerList())
+}
+
+func TestBroadcastContinuesPastFailures(t *testing.T) {
+	var (
+		n     = newTestNode(ServerConfig{ListenAddr: ":3000"})
+		alive = []*testClient{{}, {}}
+		block = util.RandomBlock()
+	)
+	n.addPeer(alive[0], testVersion(":4000"), "")
+	n.addPeer(&testClient{down: true}, testVersion(":5000"), "")
+	n.addPeer(alive[1], testVersion(":6000"), "")
+
+	assert.Error(t, n.broadcast(block))
+	for _, c := range alive {
+		assert.Equal(t, []*proto.Block{block}, c.blocks)
+	}
+}
+
+// chainClient is a peer that takes only blocks building on a block it knows
+type chainClient struct {
+	testClient
+	known map[string]bool
+}
+
+func (c *chainClient) HandleBlock(ctx context.Context, block *proto.Block, opts ...grpc.CallOption) (*proto.Ack, error) {
+	if !c.known[hex.EncodeToString(block.Header.PrevHash)] {
+		return nil, status.Error(codes.FailedPrecondition, "unknown parent")
+	}
+	c.known[hex.EncodeToString(types.MustHashBlock(block))] = true
+	return c.testClient.HandleBlock(ctx, block, opts...)
+}
+
+func TestSyncPeer(t *testing.T) {
+	var (
+		validator = crypto.MustGeneratePrivateKey()
+		genesis   = &types.Genesis{
+			Timestamp:  time.Now().Add(-time.Hour).UnixNano(),
+			Validators: []*crypto.PublicKey{validator.Public()},
+		}
+		cfg = consensus.Config{BlockTime: testBlockTime}
+		n   = newTestNode(ServerConfig{ListenAddr: ":3000", PrivateKey: validator, Genesis: genesis, Consensus: cfg})
+	)
+	assert.NoError(t, n.chain.AddBlock(genesis.Block()))
+
+	blocks := []*proto.Block{}
+	for i := 0; i < 5; i++ {
+		block := nextBlock(t, n.chain)
+		assert.NoError(t, n.chain.AddBlock(block))
+		blocks = append(blocks, block)
+	}
+	commit := &proto.Commit{Height: 4, BlockHash: types.MustHashBlock(blocks[3])}
+	_, err := n.chain.Finalize(commit)
+	assert.NoError(t, err)
+	// The final block is sent along with its commit
+	blocks[3].Commit = commit
+
+	newPeer := func(hashes ...[]byte) *chainClient {
+		c := &chainClient{known: map[string]bool{}}
+		c.known[hex.EncodeToString(types.MustHashBlock(genesis.Block()))] = true
+		for _, hash := range hashes {
+			c.known[hex.EncodeToString(hash)] = true
+		}
+		return c
+	}
+
+	// A peer far behind the last final block gets every block it missed
+	behind := newPeer()
+	assert.NoError(t, n.syncPeer(behind, 0))
+	assert.Equal(t, blocks, behind.blocks)
+
+	// A peer only gets the blocks after its height
+	current := newPeer(types.MustHashBlock(blocks[0]), types.MustHashBlock(blocks[1]))
+	assert.NoError(t, n.syncPeer(current, 2))
+	assert.Equal(t, blocks[2:], current.blocks)
+
+	// A peer whose chain forked off below its height is synced from further
+	// back, stepping back one block and then two
+	forked := newPeer(types.MustHashBlock(blocks[0]), types.MustHashBlock(blocks[1]))
+	assert.NoError(t, n.syncPeer(forked, 4))
+	assert.Equal(t, blocks[1:], forked.blocks)
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	assert.Equal(t, minReconnectBackoff, reconnectBackoff(0))
+	assert.Equal(t, 4*minReconnectBackoff, reconnectBackoff(2))
+	assert.Equal(t, maxReconnectBackoff, reconnectBackoff(10))
+	assert.Equal(t, maxReconnectBackoff, reconnectBackoff(1000))
+}
+
+// stallingClient is a peer that only answers requests for transactions once
+// they are cancelled
+type stallingClient struct {
+	testClient
+	fetching chan struct{}
+}
+
+func (c *stallingClient) GetData(ctx context.Context, inv *proto.Inventory, opts ...grpc.CallOption) (*proto.Transactions, error) {
+	c.fetching <- struct{}{}
+	<-ctx.Done()
+	return nil, status.FromContextError(ctx.Err()).Err()
+}
+
+func TestStopWaitsForBackgroundWork(t *testing.T) {
+	var (
+		bootstrap = freeAddr(t)
+		n         = New(ServerConfig{ListenAddr: ":3000"}, zap.NewNop().Sugar(), []string{bootstrap})
+		v         = testVersion(bootstrap)
+		client    = &stallingClient{fetching: make(chan struct{}, 1)}
+	)
+	assert.NoError(t, n.addPeer(client, v, "10.0.0.1:50000"))
+
+	_, err := n.HandleInventory(fromAddr(t, "10.0.0.1:50000"), &proto.Inventory{TxHashes: [][]byte{make([]byte, 32)}})
+	assert.NoError(t, err)
+	<-client.fetching
+
+	// Losing the bootstrap node starts reconnecting to it
+	n.removePeer(nodeID(v.NodeId))
+	assert.Eventually(t, func() bool {
+		n.peerLock.RLock()
+		defer n.peerLock.RUnlock()
+		return n.reconnecting[bootstrap]
+	}, time.Second, 10*time.Millisecond)
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- n.Stop()
+	}()
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("node didn't stop")
+	}
+
+	// The fetch and the reconnect returned before Stop did and nothing new
+	// starts
+	n.peerLock.RLock()
+	assert.Empty(t, n.reconnecting)
+	n.peerLock.RUnlock()
+	n.invLock.Lock()
+	assert.Empty(t, n.fetching)
+	n.invLock.Unlock()
+
+	n.removePeer(nodeID(v.NodeId))
+	n.run(func() { t.Error("ran after stop") })
+}
+
+func TestRoundTimeout(t *testing.T) {
+	assert.Equal(t, time.Second, roundTimeout(time.Second, 0))
+	assert.Equal(t, 3*time.Second, roundTimeout(time.Second, 2))
+}
+
+func TestHandshakeRejectsForeignListenAddr(t *testing.T) {
+	n := newTestNode(ServerConfig{ListenAddr: ":3000"})
+	ctx := grpcpeer.NewContext(context.Background(), &grpcpeer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000},
+	})
+
+	_, err := n.Handshake(ctx, testVersion("127.0.0.1:4000"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// The remote address is needed to verify the listen address
+	_, err = n.Handshake(context.Background(), testVersion("127.0.0.1:4000"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	self := testVersion("10.0.0.1:4000")
+	self.NodeId = n.nodeKey.Public().Bytes()
+	_, err = n.Handshake(ctx, self)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHandshakeRejectsMissingIdentity(t *testing.T) {
+	n := newTestNode(ServerConfig{ListenAddr: ":3000"})
+
+	v := testVersion("10.0.0.1:4000")
+	v.NodeId = []byte{1, 2, 3}
+	_, err := n.Handshake(context.Background(), v)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	v = testVersion("10.0.0.1:4000")
+	v.Challenge = nil
+	_, err = n.Handshake(context.Background(), v)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHandshakeRejectsOtherNodeAtListenAddr(t *testing.T) {
+	var (
+		other = newTestNode(ServerConfig{ListenAddr: freeAddr(t)})
+		n     = newTestNode(ServerConfig{ListenAddr: ":3000"})
+	)
+	startNode(t, other)
+
+	host, _, _ := net.SplitHostPort(other.ListenAddr)
+	ctx := grpcpeer.NewContext(context.Background(), &grpcpeer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(host), Port: 50000},
+	})
+
+	// The node answering at the listen address has another node key
+	_, err := n.Handshake(ctx, testVersion(other.ListenAddr))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Empty(t, n.getPeerList())
+}
+
+func TestHandshakeProvesIdentity(t *testing.T) {
+	var (
+		path  = filepath.Join(t.TempDir(), "node.key")
+		other = newTestNode(ServerConfig{ListenAddr: freeAddr(t), NodeKeyPath: path})
+		n     = newTestNode(ServerConfig{ListenAddr: freeAddr(t)})
+	)
+	startNode(t, other)
+	startNode(t, n)
+
+	// The node key is stored and stays the same across restarts
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	key, err := LoadNodeKey(path)
+	assert.NoError(t, err)
+
+	client, err := n.makeNodeClient(other.ListenAddr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+	assert.Eventually(t, func() bool {
+		_, err := client.Ping(context.Background(), &proto.Heartbeat{})
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, version, err := n.dialRemoteNode(other.ListenAddr)
+	if assert.NoError(t, err) {
+		assert.Equal(t, key.Public().Bytes(), version.NodeId)
+	}
+
+	// The node verified our identity and keyed us by it
+	assert.Eventually(t, func() bool {
+		other.peerLock.RLock()
+		defer other.peerLock.RUnlock()
+		_, ok := other.peers[n.id]
+		return ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestVerifyChallenge(t *testing.T) {
+	var (
+		key       = crypto.MustGeneratePrivateKey()
+		challenge = newChallenge()
+		signature = signChallenge(key, challenge)
+	)
+	assert.NoError(t, verifyChallenge(key.Public().Bytes(), challenge, signature))
+	assert.Error(t, verifyChallenge(key.Public().Bytes(), newChallenge(), signature))
+	assert.Error(t, verifyChallenge(crypto.MustGeneratePrivateKey().Public().Bytes(), challenge, signature))
+	assert.Error(t, verifyChallenge(key.Public().Bytes(), challenge, nil))
+
+	// A signature of the challenge itself isn't accepted
+	assert.Error(t, verifyChallenge(key.Public().Bytes(), challenge, key.Sign(challenge).Bytes()))
+}
+
+func TestOutboundPeerLimit(t *testing.T) {
+	n := newTestNode(ServerConfig{ListenAddr: ":3000", MaxOutboundPeers: 1})
+
+	v := testVersion(":4000")
+	assert.NoError(t, n.addPeer(&testClient{}, v, ""))
+	assert.Error(t, n.addPeer(&testClient{}, testVersion(":5000"), ""))
+	// Peers are connected to once, whichever side dialed
+	assert.Error(t, n.addPeer(&testClient{}, v, "10.0.0.1:50000"))
+	assert.False(t, n.hasOutboundSlot())
+
+	// Inbound peers have slots of their own
+	assert.NoError(t, n.addPeer(&testClient{}, testVersion(":6000"), "10.0.0.2:50000"))
+	assert.ElementsMatch(t, []string{":4000", ":6000"}, n.getPeerList())
+}
+
+func TestInboundPeerEviction(t *testing.T) {
+	n := newTestNode(ServerConfig{ListenAddr: ":3000", MaxInboundPeers: 4})
+
+	addrs := []string{":4000", ":5000", ":6000", ":7000"}
+	for i, addr := range addrs {
+		remote := fmt.Sprintf("10.0.0.%d:50000", i+1)
+		assert.NoError(t, n.addPeer(&testClient{}, testVersion(addr), remote))
+		n.peers[n.idOf(addr)].connectedAt = time.Now().Add(time.Duration(i-len(addrs)) * time.Minute)
+	}
+
+	// The longest connected half is kept, of the others the youngest goes
+	// unless it was more useful
+	ctx := grpcpeer.NewContext(context.Background(), &grpcpeer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.4"), Port: 50000},
+	})
+	n.credit(ctx)
+
+	assert.NoError(t, n.addPeer(&testClient{}, testVersion(":8000"), "10.0.0.5:50000"))
+	assert.ElementsMatch(t, []string{":4000", ":5000", ":7000", ":8000"}, n.getPeerList())
+}
+
+// idOf returns the node id of the peer listening at addr
+func (n *Node) idOf(addr string) string {
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+
+	for id, p := range n.peers {
+		if p.version.ListenAddr == addr {
+			return id
+		}
+	}
+	return ""
+}
+
+func TestMisbehavingPeerIsBanned(t *testing.T) {
+	var (
+		n   = newTestNode(ServerConfig{ListenAddr: ":3000"})
+		ctx = grpcpeer.NewContext(context.Background(), &grpcpeer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000},
+		})
+		tx = &proto.Transaction{
+			Inputs: []*proto.TxInput{{PublicKey: []byte{1, 2}, Signature: []byte{3}}},
+		}
+	)
+	assert.NoError(t, n.addPeer(&testClient{}, testVersion("10.0.0.1:4000"), "10.0.0.1:50000"))
+	assert.NoError(t, n.addPeer(&testClient{}, testVersion("10.0.0.2:4000"), ""))
+
+	for i := 0; i < banThreshold/penaltyMalformed; i++ {
+		assert.False(t, n.banList.IsBanned("10.0.0.1"))
+		_, err := n.HandleTransaction(ctx, tx)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	assert.True(t, n.banList.IsBanned("10.0.0.1"))
+	assert.Equal(t, []string{"10.0.0.2:4000"}, n.getPeerList())
+	assert.False(t, n.canConnectWith("10.0.0.1:4000"))
+
+	handler := func(ctx context.Context, req any) (any, error) { return &proto.Ack{}, nil }
+	_, err := n.guard(ctx, tx, &grpc.UnaryServerInfo{}, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestGuardPenalizesSpam(t *testing.T) {
+	var (
+		n   = newTestNode(ServerConfig{ListenAddr: ":3000"})
+		ctx = grpcpeer.NewContext(context.Background(), &grpcpeer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000},
+		})
+		handler = func(ctx context.Context, req any) (any, error) { return &proto.Ack{}, nil }
+	)
+
+	for i := 0; i < spamLimit; i++ {
+		_, err := n.guard(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+		assert.NoError(t, err)
+	}
+
+	_, err := n.guard(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, penaltySpam, n.scores.Get("10.0.0.1:50000"))
+}
+
+func TestAdminBanPeer(t *testing.T) {
+	var (
+		path  = filepath.Join(t.TempDir(), "bans.json")
+		n     = newTestNode(ServerConfig{ListenAddr: ":3000", BanListPath: path})
+		admin = &adminServer{node: n}
+		ctx   = context.Background()
+	)
+	assert.NoError(t, n.addPeer(&testClient{}, testVersion("10.0.0.1:4000"), "10.0.0.1:50000"))
+	assert.NoError(t, n.addPeer(&testClient{}, testVersion("10.0.0.2:4000"), ""))
+
+	peers, err := admin.ListPeers(ctx, &proto.ListPeersRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, peers.Peers, 2)
+	assert.Equal(t, "10.0.0.1:4000", peers.Peers[0].ListenAddr)
+	assert.True(t, peers.Peers[0].Inbound)
+	assert.Empty(t, peers.Bans)
+
+	_, err = admin.BanPeer(ctx, &proto.BanRequest{Addr: "10.0.0.2:4000"})
+	assert.NoError(t, err)
+
+	peers, err = admin.ListPeers(ctx, &proto.ListPeersRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, peers.Peers, 1)
+	assert.Len(t, peers.Bans, 1)
+	assert.Equal(t, "10.0.0.2", peers.Bans[0].Host)
+
+	// The ban is stored
+	bans := NewBanList(path)
+	assert.NoError(t, bans.Load())
+	assert.True(t, bans.IsBanned("10.0.0.2"))
+
+	_, err = admin.UnbanPeer(ctx, &proto.UnbanRequest{Addr: "10.0.0.2"})
+	assert.NoError(t, err)
+	assert.False(t, n.banList.IsBanned("10.0.0.2"))
+
+	_, err = admin.UnbanPeer(ctx, &proto.UnbanRequest{Addr: "10.0.0.2"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = admin.BanPeer(ctx, &proto.BanRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHandshakeRejectsIncompatibleNodes(t *testing.T) {
+	n := newTestNode(ServerConfig{ListenAddr: ":3000", ChainID: "test"})
+
+	old := testVersion("10.0.0.1:4000")
+	old.ChainId, old.ProtocolVersion, old.MinProtocolVersion = "test", minProtocolVersion-1, 0
+	_, err := n.Handshake(context.Background(), old)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, err.Error(), "protocol version")
+
+	newer := testVersion("10.0.0.1:4000")
+	newer.ChainId, newer.ProtocolVersion, newer.MinProtocolVersion = "test", ProtocolVersion+2, ProtocolVersion+1
+	_, err = n.Handshake(context.Background(), newer)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, err.Error(), "protocol version")
+
+	other := testVersion("10.0.0.1:4000")
+	other.ChainId = "other"
+	_, err = n.Handshake(context.Background(), other)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, err.Error(), "chain")
+
+	// Chains default to the hash of their genesis block
+	genesis := &types.Genesis{Timestamp: 1}
+	assert.Equal(t, genesisChainID(genesis), newTestNode(ServerConfig{Genesis: genesis}).ChainID)
+	assert.NotEqual(t, genesisChainID(genesis), genesisChainID(&types.Genesis{Timestamp: 2}))
+
+	// Nodes configured with other validators are on another chain
+	validators := []*crypto.PublicKey{crypto.MustGeneratePrivateKey().Public()}
+	assert.NotEqual(t, genesisChainID(genesis), genesisChainID(&types.Genesis{Timestamp: 1, Validators: validators}))
+}
+
+func TestBroadcastChecksFeatures(t *testing.T) {
+	var (
+		n       = newTestNode(ServerConfig{ListenAddr: ":3000"})
+		current = &testClient{}
+		old     = &testClient{}
+	)
+	n.addPeer(current, testVersion(":4000"), "")
+	v := testVersion(":5000")
+	v.Features = 0
+	n.addPeer(old, v, "")
+
+	// Peers without the feature aren't sent its messages, every peer takes
+	// blocks
+	assert.NoError(t, n.broadcast(&proto.Vote{}))
+	assert.Equal(t, 1, current.votes)
+	assert.Equal(t, 0, old.votes)
+
+	block := util.RandomBlock()
+	assert.NoError(t, n.broadcast(block))
+	assert.Len(t, old.blocks, 1)
+
+	assert.True(t, supportedFeatures.Has(FeatureGetPeers|FeatureEvidence))
+	assert.False(t, Features(0).Has(FeatureFinality))
+}
+
+func TestPeersAgreeOnProtocolVersion(t *testing.T) {
+	n := newTestNode(ServerConfig{ListenAddr: ":3000"})
+
+	// Nodes speaking a range overlapping with the node's agree on the newest
+	// version both speak
+	newer := testVersion(":4000")
+	newer.MinProtocolVersion, newer.ProtocolVersion = minProtocolVersion, ProtocolVersion+1
+	assert.NoError(t, n.checkCompatible(newer))
+	assert.Equal(t, uint32(ProtocolVersion), agreedVersion(newer))
+
+	// Nodes that don't announce their oldest version only speak their newest
+	old := testVersion(":5000")
+	old.MinProtocolVersion, old.ProtocolVersion = 0, 1
+	assert.NoError(t, n.checkCompatible(old))
+	assert.Equal(t, uint32(1), agreedVersion(old))
+
+	// Features newer than the agreed version aren't used even if announced
+	assert.NoError(t, n.addPeer(&testClient{}, newer, ""))
+	assert.NoError(t, n.addPeer(&testClient{}, old, ""))
+	n.peerLock.RLock()
+	defer n.peerLock.RUnlock()
+	for _, p := range n.peers {
+		assert.Equal(t, p.version == newer, p.has(FeatureInventory))
+		assert.True(t, p.has(FeatureFinality))
+	}
+}
@@ -0,0 +1,81 @@
+package node
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/webstradev/blockstra/crypto"
+)
+
+// challengeLen is the number of random bytes peers sign in handshakes
+const challengeLen = 32
+
+// handshakeDomain is prepended to the challenges signed with node keys, so a
+// signature of a challenge can't pass for a signature of anything else
+var handshakeDomain = []byte("blockstra handshake")
+
+// LoadNodeKey reads the node key stored in the file at path. A new key is
+// generated and stored when the file doesn't exist yet.
+func LoadNodeKey(path string) (*crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := crypto.MustGeneratePrivateKey()
+		// The first half of an ed25519 private key is its seed
+		seed := hex.EncodeToString(key.Bytes()[:32])
+		if err := writeFile(path, []byte(seed+"\n")); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.CreatePrivateKeyFromString(strings.TrimSpace(string(data)))
+}
+
+// nodeID returns the ID of the node with the public node key
+func nodeID(key []byte) string {
+	return hex.EncodeToString(key)
+}
+
+// newChallenge returns random bytes for a peer to sign
+func newChallenge() []byte {
+	challenge := make([]byte, challengeLen)
+	if _, err := rand.Read(challenge); err != nil {
+		panic(err)
+	}
+	return challenge
+}
+
+// signChallenge signs a challenge of a peer with the node key
+func signChallenge(key *crypto.PrivateKey, challenge []byte) []byte {
+	return key.Sign(challengeDigest(challenge)).Bytes()
+}
+
+// verifyChallenge checks that the challenge was signed with the node key
+func verifyChallenge(key, challenge, signature []byte) error {
+	pubKey, err := crypto.PublicKeyFromBytes(key)
+	if err != nil {
+		return fmt.Errorf("invalid node id: %w", err)
+	}
+	sig, err := crypto.SignatureFromBytes(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if !sig.Verify(pubKey, challengeDigest(challenge)) {
+		return errors.New("signature doesn't match node id")
+	}
+	return nil
+}
+
+func challengeDigest(challenge []byte) []byte {
+	sum := sha256.Sum256(bytes.Join([][]byte{handshakeDomain, challenge}, nil))
+	return sum[:]
+}
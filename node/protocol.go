@@ -0,0 +1,127 @@
+package node
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/types"
+)
+
+// ProtocolVersion is the newest version of the protocol the node speaks, it
+// is raised with every change older nodes can't follow. The node still speaks
+// every version down to minProtocolVersion. Two nodes speak the newest
+// version both of them support.
+//
+// Version 2 added the transaction inventory.
+const (
+	ProtocolVersion    = 2
+	minProtocolVersion = 1
+)
+
+// Features is a bitmap of the optional features of a node. A node only uses
+// an RPC of a feature with peers that announced it in their handshake.
+type Features uint64
+
+const (
+	// FeatureGetPeers serves addresses of the network over GetPeers
+	FeatureGetPeers Features = 1 << iota
+	// FeatureFinality takes votes over HandleVote
+	FeatureFinality
+	// FeatureEvidence takes evidence of double signing over HandleEvidence
+	FeatureEvidence
+	// FeatureInventory takes transactions announced by hash over
+	// HandleInventory and serves them over GetData
+	FeatureInventory
+)
+
+// supportedFeatures are the features of this node
+const supportedFeatures = FeatureGetPeers | FeatureFinality | FeatureEvidence | FeatureInventory
+
+// featureVersions holds the protocol version that introduced a feature, peers
+// only use it when they agreed on that version or a newer one
+var featureVersions = map[Features]uint32{
+	FeatureGetPeers:  1,
+	FeatureFinality:  1,
+	FeatureEvidence:  1,
+	FeatureInventory: 2,
+}
+
+// Has returns whether all the features are set
+func (f Features) Has(features Features) bool {
+	return f&features == features
+}
+
+// Available returns whether all the features are set and part of the
+// protocol version
+func (f Features) Available(features Features, version uint32) bool {
+	if !f.Has(features) {
+		return false
+	}
+	for feature, since := range featureVersions {
+		if features.Has(feature) && version < since {
+			return false
+		}
+	}
+	return true
+}
+
+// genesisChainID returns the chain id of the chain starting with the genesis
+// block, nodes without genesis are on the chain without id
+func genesisChainID(genesis *types.Genesis) string {
+	if genesis == nil {
+		return ""
+	}
+	return hex.EncodeToString(types.MustHashBlock(genesis.Block()))
+}
+
+// errIncompatible is returned for nodes that can't be peers of the node
+var errIncompatible = errors.New("incompatible node")
+
+// protocolRange returns the oldest and the newest protocol version the node
+// with the version speaks
+func protocolRange(v *proto.Version) (uint32, uint32) {
+	if v.MinProtocolVersion == 0 || v.MinProtocolVersion > v.ProtocolVersion {
+		return v.ProtocolVersion, v.ProtocolVersion
+	}
+	return v.MinProtocolVersion, v.ProtocolVersion
+}
+
+// agreedVersion returns the protocol version the node speaks with the node
+// with the version, the newest version both of them support
+func agreedVersion(v *proto.Version) uint32 {
+	if _, newest := protocolRange(v); newest < ProtocolVersion {
+		return newest
+	}
+	return ProtocolVersion
+}
+
+// checkCompatible returns why the node with the version can't be a peer, if
+// it can't
+func (n *Node) checkCompatible(v *proto.Version) error {
+	oldest, newest := protocolRange(v)
+	if newest < minProtocolVersion {
+		return fmt.Errorf("%w: protocol version %d is older than the oldest supported version %d", errIncompatible, newest, minProtocolVersion)
+	}
+	if oldest > ProtocolVersion {
+		return fmt.Errorf("%w: protocol version %d is newer than the newest supported version %d", errIncompatible, oldest, ProtocolVersion)
+	}
+	if v.ChainId != n.ChainID {
+		return fmt.Errorf("%w: node is on chain %q instead of %q", errIncompatible, v.ChainId, n.ChainID)
+	}
+	return nil
+}
+
+// featureOf returns the feature a peer needs to take the broadcast message,
+// none for messages every node takes
+func featureOf(msg any) Features {
+	switch msg.(type) {
+	case *proto.Vote:
+		return FeatureFinality
+	case *proto.Evidence:
+		return FeatureEvidence
+	default:
+		return 0
+	}
+}
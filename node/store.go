@@ -3,6 +3,8 @@ package node
 import (
 	"encoding/hex"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/webstradev/blockstra/proto"
@@ -25,10 +27,14 @@ func NewMemoryBlockStore() *MemoryBlockStore {
 }
 
 func (s *MemoryBlockStore) Put(block *proto.Block) error {
+	hash, err := types.HashBlock(block)
+	if err != nil {
+		return err
+	}
+
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	hash := hex.EncodeToString(types.MustHashBlock(block))
-	s.blocks[hash] = block
+	s.blocks[hex.EncodeToString(hash)] = block
 	return nil
 }
 
@@ -42,3 +48,99 @@ func (s *MemoryBlockStore) Get(hash string) (*proto.Block, error) {
 
 	return block, nil
 }
+
+// UTXO is an unspent output of a transaction on the chain
+type UTXO struct {
+	Hash     string
+	OutIndex uint32
+	Output   *proto.TxOutput
+	// Height and Timestamp of the block that created the output
+	Height    int32
+	Timestamp int64
+}
+
+func (u *UTXO) spendable() *types.SpendableOutput {
+	return &types.SpendableOutput{
+		Output:    u.Output,
+		Height:    u.Height,
+		Timestamp: u.Timestamp,
+	}
+}
+
+type UTXOStorer interface {
+	Put(*UTXO) error
+	Get(string) (*UTXO, error)
+	Delete(string) error
+	// List returns all unspent outputs in no particular order
+	List() ([]*UTXO, error)
+}
+
+type MemoryUTXOStore struct {
+	lock  sync.RWMutex
+	utxos map[string]*UTXO
+}
+
+func NewMemoryUTXOStore() *MemoryUTXOStore {
+	return &MemoryUTXOStore{
+		utxos: map[string]*UTXO{},
+	}
+}
+
+func (s *MemoryUTXOStore) Put(utxo *UTXO) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.utxos[utxoKey(utxo.Hash, utxo.OutIndex)] = utxo
+	return nil
+}
+
+func (s *MemoryUTXOStore) Get(key string) (*UTXO, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	utxo, ok := s.utxos[key]
+	if !ok {
+		return nil, fmt.Errorf("utxo [%s] does not exist", key)
+	}
+
+	return utxo, nil
+}
+
+func (s *MemoryUTXOStore) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.utxos, key)
+	return nil
+}
+
+func (s *MemoryUTXOStore) List() ([]*UTXO, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	utxos := make([]*UTXO, 0, len(s.utxos))
+	for _, utxo := range s.utxos {
+		utxos = append(utxos, utxo)
+	}
+	return utxos, nil
+}
+
+func utxoKey(hash string, outIndex uint32) string {
+	return fmt.Sprintf("%s_%d", hash, outIndex)
+}
+
+// writeFile replaces the file at path with data at once, so a crash can't
+// leave half a file behind
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,448 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/webstradev/blockstra/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// errStreamClosed is returned for requests on a stream that ended
+var errStreamClosed = status.Error(codes.Unavailable, "stream closed")
+
+// maxStreamRequests bounds the requests of a peer handled at once on a
+// stream, requests over the limit are answered with ResourceExhausted
+const maxStreamRequests = 64
+
+// peerStreamKey is the context key of the stream a request arrived on
+type peerStreamKey struct{}
+
+// peerStream is a Connect stream to a peer. It sends the messages of the
+// node as requests and answers the requests of the peer, either side can
+// send at any time. It implements proto.NodeClient, so peers connected over
+// a stream are talked to like any other peer.
+type peerStream struct {
+	// ctx carries the remote address of the peer and the stream itself, it
+	// is cancelled when the stream is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	send   func(*proto.Envelope) error
+	recv   func() (*proto.Envelope, error)
+	// conn is the connection the node opened the stream on, it is closed
+	// with the stream
+	conn io.Closer
+
+	// sendLock serializes the sends on the stream
+	sendLock sync.Mutex
+	// requests holds a slot for every request of the peer being handled
+	requests chan struct{}
+
+	lock    sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *proto.Envelope
+	done    chan struct{}
+	err     error
+}
+
+func newPeerStream(ctx context.Context, cancel context.CancelFunc, send func(*proto.Envelope) error, recv func() (*proto.Envelope, error)) *peerStream {
+	s := &peerStream{
+		cancel:   cancel,
+		send:     send,
+		recv:     recv,
+		pending:  map[uint64]chan *proto.Envelope{},
+		done:     make(chan struct{}),
+		requests: make(chan struct{}, maxStreamRequests),
+	}
+	s.ctx = context.WithValue(ctx, peerStreamKey{}, s)
+	return s
+}
+
+// Done is closed when the stream ended
+func (s *peerStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends the stream
+func (s *peerStream) Close() error {
+	s.close(nil)
+	return nil
+}
+
+func (s *peerStream) close(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	s.err = err
+	close(s.done)
+	s.cancel()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+func (s *peerStream) write(env *proto.Envelope) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	return s.send(env)
+}
+
+// serve reads the stream until it ends, passing responses to the requests
+// waiting for them and answering requests with handle
+func (s *peerStream) serve(handle func(context.Context, *proto.Envelope) (*proto.Envelope, error)) {
+	for {
+		env, err := s.recv()
+		if err != nil {
+			s.close(err)
+			return
+		}
+
+		if env.Response {
+			s.lock.Lock()
+			ch, ok := s.pending[env.Id]
+			delete(s.pending, env.Id)
+			s.lock.Unlock()
+
+			// A peer answering a request twice mustn't stall the stream
+			if ok {
+				select {
+				case ch <- env:
+				default:
+				}
+			}
+			continue
+		}
+
+		select {
+		case s.requests <- struct{}{}:
+		default:
+			// Answering in the receive loop slows down a peer flooding the
+			// stream without letting its requests pile up
+			s.respond(env, nil, status.Error(codes.ResourceExhausted, "too many requests in flight"))
+			continue
+		}
+
+		go func(req *proto.Envelope) {
+			defer func() { <-s.requests }()
+			resp, err := handle(s.ctx, req)
+			s.respond(req, resp, err)
+		}(env)
+	}
+}
+
+// respond answers a request with resp or the status of err
+func (s *peerStream) respond(req, resp *proto.Envelope, err error) {
+	if err != nil {
+		st := status.Convert(err)
+		resp = &proto.Envelope{Code: uint32(st.Code()), Error: st.Message()}
+	}
+	resp.Id, resp.Response = req.Id, true
+
+	// The peer hung up when the response can't be sent
+	if err := s.write(resp); err != nil {
+		s.close(err)
+	}
+}
+
+// call sends a request and waits for its response
+func (s *peerStream) call(ctx context.Context, req *proto.Envelope) (*proto.Envelope, error) {
+	ch := make(chan *proto.Envelope, 1)
+
+	s.lock.Lock()
+	s.nextID++
+	id := s.nextID
+	s.pending[id] = ch
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		delete(s.pending, id)
+		s.lock.Unlock()
+	}()
+
+	req.Id = id
+	if err := s.write(req); err != nil {
+		s.close(err)
+		return nil, errStreamClosed
+	}
+
+	select {
+	case resp := <-ch:
+		if code := codes.Code(resp.Code); code != codes.OK {
+			return nil, status.Error(code, resp.Error)
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case <-s.done:
+		return nil, errStreamClosed
+	}
+}
+
+func (s *peerStream) Handshake(ctx context.Context, v *proto.Version, opts ...grpc.CallOption) (*proto.Version, error) {
+	return nil, status.Error(codes.Unimplemented, "peers on a stream already shook hands")
+}
+
+func (s *peerStream) HandleTransaction(ctx context.Context, tx *proto.Transaction, opts ...grpc.CallOption) (*proto.Ack, error) {
+	if _, err := s.call(ctx, &proto.Envelope{Payload: &proto.Envelope_Transaction{Transaction: tx}}); err != nil {
+		return nil, err
+	}
+	return &proto.Ack{}, nil
+}
+
+func (s *peerStream) HandleBlock(ctx context.Context, block *proto.Block, opts ...grpc.CallOption) (*proto.Ack, error) {
+	if _, err := s.call(ctx, &proto.Envelope{Payload: &proto.Envelope_Block{Block: block}}); err != nil {
+		return nil, err
+	}
+	return &proto.Ack{}, nil
+}
+
+func (s *peerStream) HandleVote(ctx context.Context, v *proto.Vote, opts ...grpc.CallOption) (*proto.Ack, error) {
+	if _, err := s.call(ctx, &proto.Envelope{Payload: &proto.Envelope_Vote{Vote: v}}); err != nil {
+		return nil, err
+	}
+	return &proto.Ack{}, nil
+}
+
+func (s *peerStream) HandleEvidence(ctx context.Context, ev *proto.Evidence, opts ...grpc.CallOption) (*proto.Ack, error) {
+	if _, err := s.call(ctx, &proto.Envelope{Payload: &proto.Envelope_Evidence{Evidence: ev}}); err != nil {
+		return nil, err
+	}
+	return &proto.Ack{}, nil
+}
+
+func (s *peerStream) GetOutput(ctx context.Context, op *proto.OutPoint, opts ...grpc.CallOption) (*proto.TxOutput, error) {
+	return nil, status.Error(codes.Unimplemented, "outputs aren't served on streams")
+}
+
+func (s *peerStream) HasActivity(ctx context.Context, req *proto.ActivityRequest, opts ...grpc.CallOption) (*proto.Activity, error) {
+	return nil, status.Error(codes.Unimplemented, "activity isn't served on streams")
+}
+
+func (s *peerStream) Ping(ctx context.Context, hb *proto.Heartbeat, opts ...grpc.CallOption) (*proto.Heartbeat, error) {
+	resp, err := s.call(ctx, &proto.Envelope{Payload: &proto.Envelope_Heartbeat{Heartbeat: hb}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetHeartbeat(), nil
+}
+
+func (s *peerStream) GetPeers(ctx context.Context, req *proto.GetPeersRequest, opts ...grpc.CallOption) (*proto.PeerAddrs, error) {
+	resp, err := s.call(ctx, &proto.Envelope{Payload: &proto.Envelope_PeersRequest{PeersRequest: req}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetPeerAddrs(), nil
+}
+
+func (s *peerStream) HandleInventory(ctx context.Context, inv *proto.Inventory, opts ...grpc.CallOption) (*proto.Ack, error) {
+	if _, err := s.call(ctx, &proto.Envelope{Payload: &proto.Envelope_Inventory{Inventory: inv}}); err != nil {
+		return nil, err
+	}
+	return &proto.Ack{}, nil
+}
+
+func (s *peerStream) GetData(ctx context.Context, inv *proto.Inventory, opts ...grpc.CallOption) (*proto.Transactions, error) {
+	resp, err := s.call(ctx, &proto.Envelope{Payload: &proto.Envelope_DataRequest{DataRequest: inv}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetTransactions(), nil
+}
+
+func (s *peerStream) Connect(ctx context.Context, opts ...grpc.CallOption) (proto.Node_ConnectClient, error) {
+	return nil, status.Error(codes.Unimplemented, "peers on a stream are connected")
+}
+
+// Connect adds the node opening the stream to the peers. The handshake is
+// the first messages of the stream: the node sends its version with a
+// challenge, is answered with our version, a signature of its challenge and
+// a challenge of ours and sends back a signature of our challenge. The
+// stream carries the messages of both nodes after that, so nodes that can't
+// be dialed can be peers.
+func (n *Node) Connect(stream proto.Node_ConnectServer) error {
+	ctx := stream.Context()
+
+	env, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	v := env.GetVersion()
+	if v == nil {
+		n.misbehaved(ctx, penaltyMalformed, errors.New("stream doesn't start with a handshake"))
+		return status.Error(codes.InvalidArgument, "stream doesn't start with a handshake")
+	}
+	if err := n.checkVersion(ctx, v); err != nil {
+		return err
+	}
+
+	resp := n.getVersion()
+	resp.Signature = signChallenge(n.nodeKey, v.Challenge)
+	resp.Challenge = newChallenge()
+	if err := stream.Send(&proto.Envelope{Payload: &proto.Envelope_Version{Version: resp}}); err != nil {
+		return err
+	}
+
+	env, err = stream.Recv()
+	if err != nil {
+		return err
+	}
+	if err := verifyChallenge(v.NodeId, resp.Challenge, env.GetVersion().GetSignature()); err != nil {
+		n.misbehaved(ctx, penaltyInvalid, err)
+		return status.Errorf(codes.PermissionDenied, "node failed to prove its identity: %v", err)
+	}
+
+	// The listen address of the node isn't dialed back, nodes learning it
+	// from the peer list find out whether it is reachable
+	streamCtx, cancel := context.WithCancel(ctx)
+	ps := newPeerStream(streamCtx, cancel, stream.Send, stream.Recv)
+	if err := n.addPeer(ps, v, peerAddr(ctx)); err != nil {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	n.runStream(ps, nodeID(v.NodeId))
+	return nil
+}
+
+// openStream opens a Connect stream to the node of the client and shakes
+// hands over it. The stream owns the connection of the client once it is
+// open and ends when the node stops.
+func (n *Node) openStream(c *nodeClient) (proto.NodeClient, *proto.Version, error) {
+	ctx, cancel := context.WithCancel(n.ctx)
+
+	stream, err := c.Connect(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	ps, v, err := n.streamHandshake(ctx, cancel, stream)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	ps.conn = c
+
+	n.run(func() { n.runStream(ps, nodeID(v.NodeId)) })
+	return ps, v, nil
+}
+
+func (n *Node) streamHandshake(ctx context.Context, cancel context.CancelFunc, stream proto.Node_ConnectClient) (*peerStream, *proto.Version, error) {
+	version := n.getVersion()
+	version.Challenge = newChallenge()
+	if err := stream.Send(&proto.Envelope{Payload: &proto.Envelope_Version{Version: version}}); err != nil {
+		return nil, nil, err
+	}
+
+	env, err := stream.Recv()
+	if err != nil {
+		return nil, nil, err
+	}
+	v := env.GetVersion()
+	if v == nil {
+		return nil, nil, errors.New("handshake answered without version")
+	}
+
+	// The stream context carries the remote address and credentials of the
+	// peer
+	streamCtx := stream.Context()
+	var authInfo credentials.AuthInfo
+	if p, ok := grpcpeer.FromContext(streamCtx); ok {
+		authInfo = p.AuthInfo
+	}
+	if err := n.checkPeerVersion(v, version.Challenge, authInfo); err != nil {
+		return nil, nil, err
+	}
+	if len(v.Challenge) != challengeLen {
+		return nil, nil, fmt.Errorf("challenge must be %d bytes", challengeLen)
+	}
+
+	proof := &proto.Version{NodeId: version.NodeId, Signature: signChallenge(n.nodeKey, v.Challenge)}
+	if err := stream.Send(&proto.Envelope{Payload: &proto.Envelope_Version{Version: proof}}); err != nil {
+		return nil, nil, err
+	}
+
+	return newPeerStream(streamCtx, cancel, stream.Send, stream.Recv), v, nil
+}
+
+// runStream serves the stream of a peer until it ends or the node stops and
+// removes the peer then, unless it is connected over another client by now
+func (n *Node) runStream(ps *peerStream, id string) {
+	go ps.serve(n.handleEnvelope)
+	select {
+	case <-ps.Done():
+	case <-n.ctx.Done():
+		ps.Close()
+	}
+
+	n.peerLock.RLock()
+	p, ok := n.peers[id]
+	current := ok && p.client == proto.NodeClient(ps)
+	n.peerLock.RUnlock()
+
+	if current {
+		n.removePeer(id)
+	}
+}
+
+// handleEnvelope answers a request of a peer on a stream like the RPC of
+// the message
+func (n *Node) handleEnvelope(ctx context.Context, env *proto.Envelope) (*proto.Envelope, error) {
+	if err := n.admit(ctx); err != nil {
+		return nil, err
+	}
+
+	ack := &proto.Envelope{Payload: &proto.Envelope_Ack{Ack: &proto.Ack{}}}
+
+	var err error
+	switch payload := env.Payload.(type) {
+	case *proto.Envelope_Transaction:
+		_, err = n.HandleTransaction(ctx, payload.Transaction)
+	case *proto.Envelope_Block:
+		_, err = n.HandleBlock(ctx, payload.Block)
+	case *proto.Envelope_Vote:
+		_, err = n.HandleVote(ctx, payload.Vote)
+	case *proto.Envelope_Evidence:
+		_, err = n.HandleEvidence(ctx, payload.Evidence)
+	case *proto.Envelope_Inventory:
+		_, err = n.HandleInventory(ctx, payload.Inventory)
+	case *proto.Envelope_Heartbeat:
+		hb, err := n.Ping(ctx, payload.Heartbeat)
+		if err != nil {
+			return nil, err
+		}
+		return &proto.Envelope{Payload: &proto.Envelope_Heartbeat{Heartbeat: hb}}, nil
+	case *proto.Envelope_PeersRequest:
+		addrs, err := n.GetPeers(ctx, payload.PeersRequest)
+		if err != nil {
+			return nil, err
+		}
+		return &proto.Envelope{Payload: &proto.Envelope_PeerAddrs{PeerAddrs: addrs}}, nil
+	case *proto.Envelope_DataRequest:
+		txx, err := n.GetData(ctx, payload.DataRequest)
+		if err != nil {
+			return nil, err
+		}
+		return &proto.Envelope{Payload: &proto.Envelope_Transactions{Transactions: txx}}, nil
+	default:
+		err = fmt.Errorf("unexpected message %T", env.Payload)
+		n.misbehaved(ctx, penaltyMalformed, err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ack, nil
+}
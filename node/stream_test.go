@@ -0,0 +1,210 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// pipeStreams returns two peer streams sending to each other
+func pipeStreams() (*peerStream, *peerStream) {
+	var (
+		ab = make(chan *proto.Envelope, 16)
+		ba = make(chan *proto.Envelope, 16)
+	)
+	pipe := func(in, out chan *proto.Envelope) *peerStream {
+		ctx, cancel := context.WithCancel(context.Background())
+		send := func(env *proto.Envelope) error {
+			select {
+			case out <- env:
+				return nil
+			case <-ctx.Done():
+				return io.EOF
+			}
+		}
+		recv := func() (*proto.Envelope, error) {
+			select {
+			case env := <-in:
+				return env, nil
+			case <-ctx.Done():
+				return nil, io.EOF
+			}
+		}
+		return newPeerStream(ctx, cancel, send, recv)
+	}
+	return pipe(ba, ab), pipe(ab, ba)
+}
+
+func TestPeerStreamCall(t *testing.T) {
+	a, b := pipeStreams()
+	go a.serve(func(ctx context.Context, env *proto.Envelope) (*proto.Envelope, error) {
+		return nil, errors.New("unexpected")
+	})
+	go b.serve(func(ctx context.Context, env *proto.Envelope) (*proto.Envelope, error) {
+		if env.GetBlock() != nil {
+			return nil, status.Error(codes.FailedPrecondition, "unknown parent")
+		}
+		return &proto.Envelope{Payload: &proto.Envelope_Heartbeat{Heartbeat: &proto.Heartbeat{Height: env.GetHeartbeat().Height + 1}}}, nil
+	})
+
+	// Concurrent requests get their own responses
+	done := make(chan int32, 10)
+	for i := int32(0); i < 10; i++ {
+		go func(height int32) {
+			hb, err := a.Ping(context.Background(), &proto.Heartbeat{Height: height})
+			assert.NoError(t, err)
+			done <- hb.Height - height
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, int32(1), <-done)
+	}
+
+	// Errors keep their status code
+	_, err := a.HandleBlock(context.Background(), &proto.Block{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// Requests fail once the stream is closed
+	a.Close()
+	<-a.Done()
+	_, err = a.Ping(context.Background(), &proto.Heartbeat{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestPeerStreamIgnoresDuplicateResponses(t *testing.T) {
+	a, b := pipeStreams()
+	go a.serve(func(ctx context.Context, env *proto.Envelope) (*proto.Envelope, error) {
+		return nil, errors.New("unexpected")
+	})
+
+	// The peer answers every request twice
+	go func() {
+		for {
+			req, err := b.recv()
+			if err != nil {
+				return
+			}
+			resp := &proto.Envelope{Id: req.Id, Response: true, Payload: &proto.Envelope_Heartbeat{Heartbeat: &proto.Heartbeat{}}}
+			b.write(resp)
+			b.write(resp)
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := a.Ping(ctx, &proto.Heartbeat{})
+		cancel()
+		assert.NoError(t, err)
+	}
+
+	a.lock.Lock()
+	assert.Empty(t, a.pending)
+	a.lock.Unlock()
+}
+
+func TestSenderOfStream(t *testing.T) {
+	var (
+		n    = newTestNode(ServerConfig{ListenAddr: ":3000"})
+		a, b = pipeStreams()
+	)
+	assert.NoError(t, n.addPeer(a, testVersion(":4000"), ""))
+
+	// Requests on a stream are sent by the peer of the stream
+	p, ok := n.senderOf(a.ctx)
+	assert.True(t, ok)
+	assert.Equal(t, proto.NodeClient(a), p.client)
+
+	_, ok = n.senderOf(b.ctx)
+	assert.False(t, ok)
+}
+
+func TestConnectWithoutListener(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a node")
+	}
+
+	var (
+		other = newTestNode(ServerConfig{ListenAddr: freeAddr(t)})
+		// The node doesn't listen, like a node behind NAT
+		n = newTestNode(ServerConfig{ListenAddr: freeAddr(t)})
+	)
+	startNode(t, other)
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", other.ListenAddr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	client, version, err := n.dialRemoteNode(other.ListenAddr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.IsType(t, &peerStream{}, client)
+	assert.NoError(t, n.addPeer(client, version, ""))
+
+	// The node is a peer of the other node without being dialed back and
+	// both talk over the stream
+	assert.Eventually(t, func() bool {
+		return other.idOf(n.ListenAddr) == n.id
+	}, time.Second, 10*time.Millisecond)
+
+	other.peerLock.RLock()
+	back := other.peers[n.id].client
+	other.peerLock.RUnlock()
+	hb, err := back.Ping(context.Background(), &proto.Heartbeat{})
+	assert.NoError(t, err)
+	assert.Equal(t, n.nodeKey.Public().Bytes(), hb.NodeId)
+
+	// Closing the stream disconnects both nodes and closes the connection
+	n.removePeer(other.id)
+	assert.Eventually(t, func() bool {
+		return len(other.getPeerList()) == 0
+	}, time.Second, 10*time.Millisecond)
+	conn := client.(*peerStream).conn.(*nodeClient).conn
+	assert.Equal(t, connectivity.Shutdown, conn.GetState())
+}
+
+func TestPeerStreamBoundsRequestsInFlight(t *testing.T) {
+	var (
+		a, b    = pipeStreams()
+		release = make(chan struct{})
+	)
+	go a.serve(func(ctx context.Context, env *proto.Envelope) (*proto.Envelope, error) {
+		<-release
+		return &proto.Envelope{Payload: &proto.Envelope_Heartbeat{Heartbeat: &proto.Heartbeat{}}}, nil
+	})
+	defer a.Close()
+
+	// The request over the limit is refused while the others are handled
+	go func() {
+		for i := 1; i <= maxStreamRequests+1; i++ {
+			b.write(&proto.Envelope{Id: uint64(i), Payload: &proto.Envelope_Heartbeat{Heartbeat: &proto.Heartbeat{}}})
+		}
+	}()
+
+	resp, err := b.recv()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(maxStreamRequests+1), resp.Id)
+	assert.Equal(t, uint32(codes.ResourceExhausted), resp.Code)
+
+	// Handled requests free their slots
+	close(release)
+	for i := 0; i < maxStreamRequests; i++ {
+		resp, err := b.recv()
+		assert.NoError(t, err)
+		assert.Zero(t, resp.Code)
+	}
+	assert.Eventually(t, func() bool { return len(a.requests) == 0 }, time.Second, time.Millisecond*10)
+}
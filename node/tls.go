@@ -0,0 +1,162 @@
+package node
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/webstradev/blockstra/crypto"
+	"google.golang.org/grpc/credentials"
+)
+
+// nodeKeyCertValidity is how long the certificates of node keys are valid,
+// they are created again on every start
+const nodeKeyCertValidity = time.Hour * 24 * 365
+
+// TLSConfig enables TLS on the connections between nodes
+type TLSConfig struct {
+	// CertFile and KeyFile hold the PEM encoded certificate of the node and
+	// its key, without them a self-signed certificate of the node key is used
+	CertFile string
+	KeyFile  string
+	// CAFile holds the PEM encoded certificates the certificates of peers
+	// are verified against. Without it peers have to present a self-signed
+	// certificate of the node key they identify with.
+	CAFile string
+	// Mutual requires nodes connecting to the node to present a certificate
+	// as well
+	Mutual bool
+}
+
+// transportCredentials returns the credentials the node serves and dials
+// other nodes with
+func (n *Node) transportCredentials() (credentials.TransportCredentials, error) {
+	cfg, err := n.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(cfg), nil
+}
+
+func (n *Node) tlsConfig() (*tls.Config, error) {
+	var (
+		cert tls.Certificate
+		err  error
+	)
+	if n.TLS.CertFile != "" || n.TLS.KeyFile != "" {
+		cert, err = tls.LoadX509KeyPair(n.TLS.CertFile, n.TLS.KeyFile)
+	} else {
+		cert, err = nodeKeyCertificate(n.nodeKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+	}
+
+	if n.TLS.CAFile != "" {
+		data, err := os.ReadFile(n.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", n.TLS.CAFile)
+		}
+
+		cfg.RootCAs, cfg.ClientCAs = pool, pool
+		if n.TLS.Mutual {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return cfg, nil
+	}
+
+	// Certificates of node keys aren't issued by anyone, they are bound to
+	// the node id of the handshake instead
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyPeerCertificate = verifyNodeKeyCertificate
+	if n.TLS.Mutual {
+		cfg.ClientAuth = tls.RequireAnyClientCert
+	}
+	return cfg, nil
+}
+
+// nodeKeyCertificate returns a self-signed certificate of the node key
+func nodeKeyCertificate(key *crypto.PrivateKey) (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	var (
+		now      = time.Now()
+		priv     = ed25519.PrivateKey(key.Bytes())
+		template = &x509.Certificate{
+			SerialNumber: serial,
+			Subject:      pkix.Name{CommonName: nodeID(key.Public().Bytes())},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.Add(nodeKeyCertValidity),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}
+	)
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, priv.Public(), priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}, nil
+}
+
+// verifyNodeKeyCertificate checks that a peer presented a valid self-signed
+// certificate of an ed25519 key
+func verifyNodeKeyCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("missing certificate")
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	if _, ok := cert.PublicKey.(ed25519.PublicKey); !ok {
+		return errors.New("certificate isn't of a node key")
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return errors.New("certificate expired")
+	}
+	// The certificate isn't of a CA, CheckSignatureFrom would reject it
+	return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature)
+}
+
+// verifyPeerCertificate checks that the certificate a peer presented on the
+// connection is of the node key it identified with. Certificates verified
+// against a CA aren't bound to node keys.
+func (n *Node) verifyPeerCertificate(authInfo credentials.AuthInfo, id []byte) error {
+	if n.TLS == nil || n.TLS.CAFile != "" {
+		return nil
+	}
+
+	info, ok := authInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return errors.New("missing certificate")
+	}
+
+	key, ok := info.State.PeerCertificates[0].PublicKey.(ed25519.PublicKey)
+	if !ok || !bytes.Equal(key, id) {
+		return errors.New("certificate isn't of the node key")
+	}
+	return nil
+}
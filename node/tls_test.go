@@ -0,0 +1,197 @@
+package node
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+	"go.uber.org/zap"
+)
+
+func TestNodeKeyCertificate(t *testing.T) {
+	key := crypto.MustGeneratePrivateKey()
+
+	cert, err := nodeKeyCertificate(key)
+	assert.NoError(t, err)
+	assert.NoError(t, verifyNodeKeyCertificate(cert.Certificate, nil))
+
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, ed25519.PublicKey(key.Public().Bytes()), parsed.PublicKey)
+
+	assert.Error(t, verifyNodeKeyCertificate(nil, nil))
+	assert.Error(t, verifyNodeKeyCertificate([][]byte{{1, 2, 3}}, nil))
+}
+
+// startTLSNodes starts a node and one bootstrapping from it with the TLS
+// configs and returns them
+func startTLSNodes(t *testing.T, first, second *TLSConfig) (*Node, *Node) {
+	a := New(ServerConfig{ListenAddr: freeAddr(t), TLS: first}, zap.NewNop().Sugar(), []string{})
+	startNode(t, a)
+
+	// Wait for the first node to listen
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", a.ListenAddr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	b := New(ServerConfig{ListenAddr: freeAddr(t), TLS: second}, zap.NewNop().Sugar(), []string{a.ListenAddr})
+	startNode(t, b)
+
+	return a, b
+}
+
+func TestMutualTLSWithNodeKeys(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts nodes")
+	}
+
+	a, b := startTLSNodes(t, &TLSConfig{Mutual: true}, &TLSConfig{Mutual: true})
+	assert.Eventually(t, func() bool {
+		return len(a.getPeerList()) == 1 && len(b.getPeerList()) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// The peers are keyed by the node keys of their certificates
+	assert.Equal(t, a.id, b.idOf(a.ListenAddr))
+	assert.Equal(t, b.id, a.idOf(b.ListenAddr))
+}
+
+func TestTLSRejectsPlaintextNodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts nodes")
+	}
+
+	a, b := startTLSNodes(t, &TLSConfig{Mutual: true}, nil)
+	time.Sleep(500 * time.Millisecond)
+	assert.Empty(t, a.getPeerList())
+	assert.Empty(t, b.getPeerList())
+}
+
+func TestMutualTLSWithCA(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts nodes")
+	}
+
+	var (
+		dir       = t.TempDir()
+		caKey, ca = testCA(t)
+		caFile    = filepath.Join(dir, "ca.pem")
+	)
+	writePEM(t, caFile, "CERTIFICATE", ca.Raw)
+
+	configs := []*TLSConfig{}
+	for _, name := range []string{"a", "b"} {
+		cfg := &TLSConfig{
+			CertFile: filepath.Join(dir, name+".pem"),
+			KeyFile:  filepath.Join(dir, name+".key"),
+			CAFile:   caFile,
+			Mutual:   true,
+		}
+		issueTestCert(t, caKey, ca, cfg.CertFile, cfg.KeyFile)
+		configs = append(configs, cfg)
+	}
+
+	a, b := startTLSNodes(t, configs[0], configs[1])
+	assert.Eventually(t, func() bool {
+		return len(a.getPeerList()) == 1 && len(b.getPeerList()) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Certificates of another CA are rejected
+	otherKey, other := testCA(t)
+	cfg := &TLSConfig{
+		CertFile: filepath.Join(dir, "c.pem"),
+		KeyFile:  filepath.Join(dir, "c.key"),
+		CAFile:   caFile,
+		Mutual:   true,
+	}
+	issueTestCert(t, otherKey, other, cfg.CertFile, cfg.KeyFile)
+
+	c := New(ServerConfig{ListenAddr: freeAddr(t), TLS: cfg}, zap.NewNop().Sugar(), []string{a.ListenAddr})
+	startNode(t, c)
+	time.Sleep(500 * time.Millisecond)
+	assert.Empty(t, c.getPeerList())
+}
+
+// testCA returns the key and certificate of a new certificate authority
+func testCA(t *testing.T) (*ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "blockstra test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+// issueTestCert writes a certificate for 127.0.0.1 issued by the CA and its
+// key to the files
+func issueTestCert(t *testing.T, caKey *ecdsa.PrivateKey, ca *x509.Certificate, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "blockstra test node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+package script
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/webstradev/blockstra/crypto"
+)
+
+// Builder assembles a script from opcodes and data pushes
+type Builder struct {
+	script []byte
+	err    error
+}
+
+func NewBuilder() *Builder {
+	return &Builder{script: []byte{}}
+}
+
+func (b *Builder) AddOp(op Opcode) *Builder {
+	b.script = append(b.script, byte(op))
+	return b
+}
+
+// AddData pushes data using the smallest push opcode that fits
+func (b *Builder) AddData(data []byte) *Builder {
+	switch n := len(data); {
+	case n > MaxElementSize:
+		b.err = fmt.Errorf("%w: element of %d bytes", ErrBudgetExceeded, n)
+		return b
+	case n == 0:
+		b.script = append(b.script, byte(OpFalse))
+	case n <= maxDirectPush:
+		b.script = append(b.script, byte(n))
+	case n <= 0xff:
+		b.script = append(b.script, byte(OpPushData1), byte(n))
+	default:
+		b.script = append(b.script, byte(OpPushData2))
+		b.script = binary.LittleEndian.AppendUint16(b.script, uint16(n))
+	}
+
+	b.script = append(b.script, data...)
+	return b
+}
+
+// AddInt pushes a number, like the height used by OpCheckLockHeight
+func (b *Builder) AddInt(n int64) *Builder {
+	return b.AddData(EncodeNumber(n))
+}
+
+func (b *Builder) Script() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.script) > MaxScriptSize {
+		return nil, fmt.Errorf("%w: script of %d bytes", ErrBudgetExceeded, len(b.script))
+	}
+	return b.script, nil
+}
+
+// PayToAddress locks an output to the owner of an address:
+// OP_DUP OP_ADDRESS <address> OP_EQUALVERIFY OP_CHECKSIG
+func PayToAddress(address crypto.Address) ([]byte, error) {
+	return NewBuilder().
+		AddOp(OpDup).
+		AddOp(OpAddress).
+		AddData(address.Bytes()).
+		AddOp(OpEqualVerify).
+		AddOp(OpCheckSig).
+		Script()
+}
+
+// SignatureUnlock unlocks a PayToAddress output: <signature> <public key>
+func SignatureUnlock(sig *crypto.Signature, pubKey *crypto.PublicKey) ([]byte, error) {
+	return NewBuilder().
+		AddData(sig.Bytes()).
+		AddData(pubKey.Bytes()).
+		Script()
+}
+
+// Instruction is a single opcode of a script with the data it pushes
+type Instruction struct {
+	Op   Opcode
+	Data []byte
+}
+
+// Parse splits a script into its instructions without executing it
+func Parse(script []byte) ([]Instruction, error) {
+	instructions := []Instruction{}
+
+	for pc := 0; pc < len(script); {
+		op := Opcode(script[pc])
+		pc++
+
+		if !op.isPush() {
+			instructions = append(instructions, Instruction{Op: op})
+			continue
+		}
+
+		data, next, err := readPush(script, pc, op)
+		if err != nil {
+			return nil, err
+		}
+		pc = next
+
+		instructions = append(instructions, Instruction{Op: op, Data: data})
+	}
+
+	return instructions, nil
+}
+
+// Disassemble returns a human readable form of the script
+func Disassemble(script []byte) (string, error) {
+	instructions, err := Parse(script)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, len(instructions))
+	for i, ins := range instructions {
+		switch {
+		case !ins.Op.isPush(), ins.Op == OpFalse, ins.Op == OpTrue:
+			parts[i] = ins.Op.String()
+		default:
+			parts[i] = fmt.Sprintf("%x", ins.Data)
+		}
+	}
+
+	return strings.Join(parts, " "), nil
+}
@@ -0,0 +1,52 @@
+package script
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+)
+
+func TestBuilderAddData(t *testing.T) {
+	tests := []struct {
+		size   int
+		prefix []byte
+	}{
+		{0, []byte{byte(OpFalse)}},
+		{1, []byte{0x01}},
+		{maxDirectPush, []byte{maxDirectPush}},
+		{maxDirectPush + 1, []byte{byte(OpPushData1), maxDirectPush + 1}},
+		{MaxElementSize, []byte{byte(OpPushData2), 0x08, 0x02}},
+	}
+
+	for _, tt := range tests {
+		data := bytes.Repeat([]byte{0xaa}, tt.size)
+
+		script, err := NewBuilder().AddData(data).Script()
+		assert.NoError(t, err)
+		assert.Equal(t, tt.prefix, script[:len(tt.prefix)])
+
+		pushed, next, err := readPush(script, 1, Opcode(script[0]))
+		assert.NoError(t, err)
+		assert.Equal(t, data, pushed)
+		assert.Equal(t, len(script), next)
+	}
+
+	_, err := NewBuilder().AddData(make([]byte, MaxElementSize+1)).Script()
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+}
+
+func TestDisassemble(t *testing.T) {
+	address := crypto.MustGeneratePrivateKey().Public().Address()
+
+	script, err := PayToAddress(address)
+	assert.NoError(t, err)
+
+	asm, err := Disassemble(script)
+	assert.NoError(t, err)
+	assert.Equal(t, "OP_DUP OP_ADDRESS "+address.String()+" OP_EQUALVERIFY OP_CHECKSIG", asm)
+
+	_, err = Disassemble([]byte{0x05})
+	assert.Error(t, err)
+}
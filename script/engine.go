@@ -0,0 +1,410 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+)
+
+// Limits of the execution budget. A script exceeding any of them fails.
+const (
+	MaxScriptSize  = 10_000
+	MaxElementSize = 520
+	MaxStackDepth  = 100
+	MaxCost        = 1_000
+	// maxNumberSize is the maximum size of a stack element used as a number
+	maxNumberSize = 8
+)
+
+var (
+	ErrScriptFailed    = errors.New("script failed")
+	ErrBudgetExceeded  = errors.New("script execution budget exceeded")
+	ErrMalformedScript = errors.New("malformed script")
+	// ErrLocked is returned along with ErrScriptFailed by a lock that
+	// doesn't expire before the including block
+	ErrLocked = errors.New("locked")
+)
+
+// Context is the transaction and block a script is executed for
+type Context struct {
+	// SigHash is the message signatures are checked against
+	SigHash []byte
+	// Header of the block the spending transaction is included in
+	Header *proto.Header
+	// Height and Timestamp of the block that created the spent output, used
+	// by the relative time lock opcodes
+	CreatedHeight    int32
+	CreatedTimestamp int64
+}
+
+// Execute runs the unlocking script of an input followed by the locking
+// script of the output it spends. The unlocking script may only push data.
+// The spend is authorized when both run without error and leave a true value
+// on top of the stack.
+func Execute(unlocking, locking []byte, ctx *Context) error {
+	if ctx == nil || ctx.Header == nil {
+		return fmt.Errorf("%w: missing execution context", ErrScriptFailed)
+	}
+
+	e := &engine{ctx: ctx}
+
+	if err := e.run(unlocking, true); err != nil {
+		return fmt.Errorf("unlocking script: %w", err)
+	}
+
+	if err := e.run(locking, false); err != nil {
+		return fmt.Errorf("locking script: %w", err)
+	}
+
+	if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+		return fmt.Errorf("%w: false on top of the stack", ErrScriptFailed)
+	}
+
+	return nil
+}
+
+type engine struct {
+	ctx   *Context
+	stack [][]byte
+	cost  int
+}
+
+func (e *engine) run(script []byte, pushOnly bool) error {
+	if len(script) > MaxScriptSize {
+		return fmt.Errorf("%w: script of %d bytes", ErrBudgetExceeded, len(script))
+	}
+
+	// conditions holds whether each enclosing IF branch is executed
+	conditions := []bool{}
+	executing := func() bool {
+		for _, c := range conditions {
+			if !c {
+				return false
+			}
+		}
+		return true
+	}
+
+	for pc := 0; pc < len(script); {
+		op := Opcode(script[pc])
+		pc++
+
+		if pushOnly && !op.isPush() {
+			return fmt.Errorf("%w: %s in push only script", ErrMalformedScript, op)
+		}
+
+		e.cost += op.cost()
+		if e.cost > MaxCost {
+			return ErrBudgetExceeded
+		}
+
+		if op.isPush() {
+			data, next, err := readPush(script, pc, op)
+			if err != nil {
+				return err
+			}
+			pc = next
+
+			if executing() {
+				if err := e.push(data); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		switch op {
+		case OpIf, OpNotIf:
+			cond := false
+			if executing() {
+				top, err := e.pop()
+				if err != nil {
+					return err
+				}
+				cond = asBool(top) == (op == OpIf)
+			}
+			conditions = append(conditions, cond)
+			continue
+		case OpElse:
+			if len(conditions) == 0 {
+				return fmt.Errorf("%w: %s without %s", ErrMalformedScript, op, OpIf)
+			}
+			conditions[len(conditions)-1] = !conditions[len(conditions)-1]
+			continue
+		case OpEndIf:
+			if len(conditions) == 0 {
+				return fmt.Errorf("%w: %s without %s", ErrMalformedScript, op, OpIf)
+			}
+			conditions = conditions[:len(conditions)-1]
+			continue
+		}
+
+		if !executing() {
+			continue
+		}
+
+		if err := e.execute(op); err != nil {
+			return err
+		}
+	}
+
+	if len(conditions) > 0 {
+		return fmt.Errorf("%w: unbalanced %s", ErrMalformedScript, OpIf)
+	}
+
+	return nil
+}
+
+func (e *engine) execute(op Opcode) error {
+	switch op {
+	case OpVerify:
+		return e.verify()
+	case OpReturn:
+		return fmt.Errorf("%w: %s", ErrScriptFailed, op)
+	case OpDrop:
+		_, err := e.pop()
+		return err
+	case OpDup:
+		top, err := e.peek()
+		if err != nil {
+			return err
+		}
+		return e.push(top)
+	case OpSwap:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(a)
+		return e.push(b)
+	case OpEqual, OpEqualVerify:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(fromBool(bytes.Equal(a, b)))
+		if op == OpEqualVerify {
+			return e.verify()
+		}
+		return nil
+	case OpSHA256:
+		data, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		return e.push(hash[:])
+	case OpAddress:
+		pubKey, err := e.popPublicKey()
+		if err != nil {
+			return err
+		}
+		return e.push(pubKey.Address().Bytes())
+	case OpCheckSig, OpCheckSigVerify:
+		pubKey, err := e.popPublicKey()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		valid := false
+		if sig, err := crypto.SignatureFromBytes(b); err == nil {
+			valid = sig.Verify(pubKey, e.ctx.SigHash)
+		}
+		e.push(fromBool(valid))
+		if op == OpCheckSigVerify {
+			return e.verify()
+		}
+		return nil
+	case OpCheckLockHeight:
+		return e.checkLock(int64(e.ctx.Header.Height), "height")
+	case OpCheckLockTime:
+		return e.checkLock(e.ctx.Header.Timestamp, "timestamp")
+	case OpCheckRelHeight:
+		return e.checkLock(int64(e.ctx.Header.Height-e.ctx.CreatedHeight), "relative height")
+	case OpCheckRelTime:
+		return e.checkLock(e.ctx.Header.Timestamp-e.ctx.CreatedTimestamp, "relative timestamp")
+	default:
+		return fmt.Errorf("%w: unknown opcode 0x%02x", ErrMalformedScript, byte(op))
+	}
+}
+
+// checkLock fails unless the number on top of the stack is at most current.
+// The number is left on the stack.
+func (e *engine) checkLock(current int64, kind string) error {
+	top, err := e.peek()
+	if err != nil {
+		return err
+	}
+
+	lock, err := DecodeNumber(top)
+	if err != nil {
+		return err
+	}
+
+	if lock < 0 {
+		return fmt.Errorf("%w: negative %s lock %d", ErrScriptFailed, kind, lock)
+	}
+	if current < lock {
+		return fmt.Errorf("%w: %w until %s %d, at %d", ErrScriptFailed, ErrLocked, kind, lock, current)
+	}
+
+	return nil
+}
+
+func (e *engine) verify() error {
+	top, err := e.pop()
+	if err != nil {
+		return err
+	}
+	if !asBool(top) {
+		return fmt.Errorf("%w: verify failed", ErrScriptFailed)
+	}
+	return nil
+}
+
+func (e *engine) push(data []byte) error {
+	if len(data) > MaxElementSize {
+		return fmt.Errorf("%w: element of %d bytes", ErrBudgetExceeded, len(data))
+	}
+	if len(e.stack) >= MaxStackDepth {
+		return fmt.Errorf("%w: stack depth", ErrBudgetExceeded)
+	}
+	e.stack = append(e.stack, data)
+	return nil
+}
+
+func (e *engine) pop() ([]byte, error) {
+	top, err := e.peek()
+	if err != nil {
+		return nil, err
+	}
+	e.stack = e.stack[:len(e.stack)-1]
+	return top, nil
+}
+
+func (e *engine) peek() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, fmt.Errorf("%w: stack is empty", ErrScriptFailed)
+	}
+	return e.stack[len(e.stack)-1], nil
+}
+
+func (e *engine) popPublicKey() (*crypto.PublicKey, error) {
+	b, err := e.pop()
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, err := crypto.PublicKeyFromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScriptFailed, err)
+	}
+
+	return pubKey, nil
+}
+
+// readPush returns the data pushed by the push opcode op, whose operands
+// start at pc, and the position of the next opcode.
+func readPush(script []byte, pc int, op Opcode) ([]byte, int, error) {
+	var n int
+
+	switch {
+	case op == OpFalse:
+		return []byte{}, pc, nil
+	case op == OpTrue:
+		return []byte{1}, pc, nil
+	case op <= maxDirectPush:
+		n = int(op)
+	case op == OpPushData1:
+		if pc+1 > len(script) {
+			return nil, 0, fmt.Errorf("%w: truncated %s", ErrMalformedScript, op)
+		}
+		n = int(script[pc])
+		pc++
+	case op == OpPushData2:
+		if pc+2 > len(script) {
+			return nil, 0, fmt.Errorf("%w: truncated %s", ErrMalformedScript, op)
+		}
+		n = int(binary.LittleEndian.Uint16(script[pc:]))
+		pc += 2
+	}
+
+	if pc+n > len(script) {
+		return nil, 0, fmt.Errorf("%w: push of %d bytes past the end of the script", ErrMalformedScript, n)
+	}
+
+	return script[pc : pc+n], pc + n, nil
+}
+
+// asBool interprets a stack element as a boolean, empty and all zero
+// elements are false.
+func asBool(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func fromBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return []byte{}
+}
+
+// DecodeNumber interprets a stack element as a big endian signed number
+func DecodeNumber(b []byte) (int64, error) {
+	if len(b) > maxNumberSize {
+		return 0, fmt.Errorf("%w: number of %d bytes", ErrScriptFailed, len(b))
+	}
+
+	var n int64
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n = -1
+	}
+	for _, v := range b {
+		n = n<<8 | int64(v)
+	}
+
+	return n, nil
+}
+
+// EncodeNumber encodes n as the shortest big endian signed number, the way
+// numbers are pushed onto the stack
+func EncodeNumber(n int64) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+
+	b := make([]byte, maxNumberSize)
+	binary.BigEndian.PutUint64(b, uint64(n))
+
+	// strip leading bytes that only repeat the sign
+	for len(b) > 1 {
+		if (b[0] == 0x00 && b[1]&0x80 == 0) || (b[0] == 0xff && b[1]&0x80 != 0) {
+			b = b[1:]
+			continue
+		}
+		break
+	}
+
+	return b
+}
@@ -0,0 +1,164 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/util"
+)
+
+func mustScript(t *testing.T, b *Builder) []byte {
+	script, err := b.Script()
+	assert.NoError(t, err)
+	return script
+}
+
+func TestExecutePayToAddress(t *testing.T) {
+	var (
+		privKey = crypto.MustGeneratePrivateKey()
+		sigHash = util.RandomHash()
+		ctx     = &Context{SigHash: sigHash, Header: &proto.Header{}}
+	)
+
+	locking, err := PayToAddress(privKey.Public().Address())
+	assert.NoError(t, err)
+
+	unlocking, err := SignatureUnlock(privKey.Sign(sigHash), privKey.Public())
+	assert.NoError(t, err)
+	assert.NoError(t, Execute(unlocking, locking, ctx))
+
+	// Signature over another message
+	unlocking, err = SignatureUnlock(privKey.Sign([]byte("foo")), privKey.Public())
+	assert.NoError(t, err)
+	assert.ErrorIs(t, Execute(unlocking, locking, ctx), ErrScriptFailed)
+
+	// Valid signature of a key that doesn't own the address
+	otherPrivKey := crypto.MustGeneratePrivateKey()
+	unlocking, err = SignatureUnlock(otherPrivKey.Sign(sigHash), otherPrivKey.Public())
+	assert.NoError(t, err)
+	assert.ErrorIs(t, Execute(unlocking, locking, ctx), ErrScriptFailed)
+}
+
+func TestExecuteHashLock(t *testing.T) {
+	var (
+		preimage = []byte("open sesame")
+		hash     = sha256.Sum256(preimage)
+		ctx      = &Context{Header: &proto.Header{}}
+		locking  = mustScript(t, NewBuilder().AddOp(OpSHA256).AddData(hash[:]).AddOp(OpEqual))
+	)
+
+	assert.NoError(t, Execute(mustScript(t, NewBuilder().AddData(preimage)), locking, ctx))
+	assert.ErrorIs(t, Execute(mustScript(t, NewBuilder().AddData([]byte("guess"))), locking, ctx), ErrScriptFailed)
+}
+
+func TestExecuteTimeLocks(t *testing.T) {
+	tests := []struct {
+		op      Opcode
+		lock    int64
+		ctx     *Context
+		success bool
+	}{
+		{OpCheckLockHeight, 10, &Context{Header: &proto.Header{Height: 10}}, true},
+		{OpCheckLockHeight, 10, &Context{Header: &proto.Header{Height: 9}}, false},
+		{OpCheckLockTime, 1_000, &Context{Header: &proto.Header{Timestamp: 1_000}}, true},
+		{OpCheckLockTime, 1_000, &Context{Header: &proto.Header{Timestamp: 999}}, false},
+		{OpCheckRelHeight, 5, &Context{Header: &proto.Header{Height: 10}, CreatedHeight: 5}, true},
+		{OpCheckRelHeight, 5, &Context{Header: &proto.Header{Height: 10}, CreatedHeight: 6}, false},
+		{OpCheckRelTime, 500, &Context{Header: &proto.Header{Timestamp: 1_000}, CreatedTimestamp: 500}, true},
+		{OpCheckRelTime, 500, &Context{Header: &proto.Header{Timestamp: 1_000}, CreatedTimestamp: 501}, false},
+		{OpCheckLockHeight, -1, &Context{Header: &proto.Header{Height: 10}}, false},
+	}
+
+	for _, tt := range tests {
+		locking := mustScript(t, NewBuilder().AddInt(tt.lock).AddOp(tt.op).AddOp(OpDrop).AddOp(OpTrue))
+		err := Execute(nil, locking, tt.ctx)
+		if tt.success {
+			assert.NoError(t, err, tt.op.String())
+		} else {
+			assert.ErrorIs(t, err, ErrScriptFailed, tt.op.String())
+			// Only a lock that hasn't expired yet can become valid later
+			assert.Equal(t, tt.lock >= 0, errors.Is(err, ErrLocked), tt.op.String())
+		}
+	}
+}
+
+func TestExecuteConditionals(t *testing.T) {
+	var (
+		ctx = &Context{Header: &proto.Header{}}
+		// IF <a> ELSE <b> ENDIF <a> EQUAL
+		locking = mustScript(t, NewBuilder().
+			AddOp(OpIf).AddData([]byte("a")).AddOp(OpElse).AddData([]byte("b")).AddOp(OpEndIf).
+			AddData([]byte("a")).AddOp(OpEqual))
+	)
+
+	assert.NoError(t, Execute([]byte{byte(OpTrue)}, locking, ctx))
+	assert.ErrorIs(t, Execute([]byte{byte(OpFalse)}, locking, ctx), ErrScriptFailed)
+
+	// Unbalanced conditionals
+	assert.ErrorIs(t, Execute(nil, []byte{byte(OpTrue), byte(OpIf)}, ctx), ErrMalformedScript)
+	assert.ErrorIs(t, Execute(nil, []byte{byte(OpEndIf)}, ctx), ErrMalformedScript)
+}
+
+func TestExecuteRejectsMalformed(t *testing.T) {
+	ctx := &Context{Header: &proto.Header{}}
+
+	// Unlocking scripts may only push data
+	assert.ErrorIs(t, Execute([]byte{byte(OpTrue), byte(OpDup)}, []byte{byte(OpTrue)}, ctx), ErrMalformedScript)
+	// Push past the end of the script
+	assert.ErrorIs(t, Execute(nil, []byte{0x05, 0x01}, ctx), ErrMalformedScript)
+	// Unknown opcode
+	assert.ErrorIs(t, Execute(nil, []byte{0xff}, ctx), ErrMalformedScript)
+	// Empty stack
+	assert.ErrorIs(t, Execute(nil, []byte{byte(OpDup)}, ctx), ErrScriptFailed)
+	assert.ErrorIs(t, Execute(nil, nil, ctx), ErrScriptFailed)
+	// OP_RETURN always fails
+	assert.ErrorIs(t, Execute(nil, []byte{byte(OpTrue), byte(OpReturn)}, ctx), ErrScriptFailed)
+	// Missing context
+	assert.Error(t, Execute(nil, []byte{byte(OpTrue)}, nil))
+}
+
+func TestExecuteBudget(t *testing.T) {
+	ctx := &Context{Header: &proto.Header{}}
+
+	// Growing the stack past its maximum depth
+	grow := bytes.Repeat([]byte{byte(OpDup)}, MaxStackDepth)
+	assert.ErrorIs(t, Execute(nil, append([]byte{byte(OpTrue)}, grow...), ctx), ErrBudgetExceeded)
+
+	// Exceeding the cost with cheap operations
+	spin := bytes.Repeat([]byte{byte(OpDup), byte(OpDrop)}, MaxCost/2)
+	assert.ErrorIs(t, Execute(nil, append([]byte{byte(OpTrue)}, spin...), ctx), ErrBudgetExceeded)
+
+	// Exceeding the cost with expensive operations
+	hashes := bytes.Repeat([]byte{byte(OpSHA256)}, MaxCost/OpSHA256.cost()+1)
+	assert.ErrorIs(t, Execute(nil, append([]byte{byte(OpTrue)}, hashes...), ctx), ErrBudgetExceeded)
+
+	// Oversized scripts
+	assert.ErrorIs(t, Execute(nil, make([]byte, MaxScriptSize+1), ctx), ErrBudgetExceeded)
+}
+
+func TestNumberEncoding(t *testing.T) {
+	for _, n := range []int64{0, 1, -1, 127, 128, -128, -129, 255, 1 << 40, -(1 << 40)} {
+		decoded, err := DecodeNumber(EncodeNumber(n))
+		assert.NoError(t, err)
+		assert.Equal(t, n, decoded)
+	}
+
+	assert.Equal(t, []byte{0x00, 0x80}, EncodeNumber(128))
+	assert.Equal(t, []byte{0xff}, EncodeNumber(-1))
+}
+
+func FuzzExecute(f *testing.F) {
+	privKey := crypto.MustGeneratePrivateKey()
+	locking, _ := PayToAddress(privKey.Public().Address())
+	f.Add([]byte{}, locking)
+	f.Add([]byte{byte(OpTrue)}, []byte{byte(OpIf), byte(OpElse)})
+
+	f.Fuzz(func(t *testing.T, unlocking, locking []byte) {
+		Execute(unlocking, locking, &Context{Header: &proto.Header{}})
+	})
+}
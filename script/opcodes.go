@@ -0,0 +1,96 @@
+package script
+
+// Opcode is a single instruction of a script. Opcodes 0x01 through 0x4b push
+// that many of the following bytes onto the stack.
+type Opcode byte
+
+const (
+	OpFalse     Opcode = 0x00
+	OpPushData1 Opcode = 0x4c // the next byte is the number of bytes to push
+	OpPushData2 Opcode = 0x4d // the next two bytes (little endian) are the number of bytes to push
+	OpTrue      Opcode = 0x51
+
+	// flow control
+	OpIf     Opcode = 0x63
+	OpNotIf  Opcode = 0x64
+	OpElse   Opcode = 0x67
+	OpEndIf  Opcode = 0x68
+	OpVerify Opcode = 0x69
+	OpReturn Opcode = 0x6a
+
+	// stack
+	OpDrop Opcode = 0x75
+	OpDup  Opcode = 0x76
+	OpSwap Opcode = 0x7c
+
+	// comparison
+	OpEqual       Opcode = 0x87
+	OpEqualVerify Opcode = 0x88
+
+	// crypto
+	OpSHA256         Opcode = 0xa8
+	OpAddress        Opcode = 0xa9 // replaces a public key with its address
+	OpCheckSig       Opcode = 0xac
+	OpCheckSigVerify Opcode = 0xad
+
+	// time locks, all of them leave the stack untouched
+	OpCheckLockHeight Opcode = 0xb1 // fails before the height on the stack
+	OpCheckLockTime   Opcode = 0xb2 // fails before the timestamp on the stack
+	OpCheckRelHeight  Opcode = 0xb3 // fails before the output is as many blocks old as on the stack
+	OpCheckRelTime    Opcode = 0xb4 // fails before the output is as old as the duration on the stack
+)
+
+const maxDirectPush = 0x4b
+
+var opcodeNames = map[Opcode]string{
+	OpFalse:           "OP_FALSE",
+	OpPushData1:       "OP_PUSHDATA1",
+	OpPushData2:       "OP_PUSHDATA2",
+	OpTrue:            "OP_TRUE",
+	OpIf:              "OP_IF",
+	OpNotIf:           "OP_NOTIF",
+	OpElse:            "OP_ELSE",
+	OpEndIf:           "OP_ENDIF",
+	OpVerify:          "OP_VERIFY",
+	OpReturn:          "OP_RETURN",
+	OpDrop:            "OP_DROP",
+	OpDup:             "OP_DUP",
+	OpSwap:            "OP_SWAP",
+	OpEqual:           "OP_EQUAL",
+	OpEqualVerify:     "OP_EQUALVERIFY",
+	OpSHA256:          "OP_SHA256",
+	OpAddress:         "OP_ADDRESS",
+	OpCheckSig:        "OP_CHECKSIG",
+	OpCheckSigVerify:  "OP_CHECKSIGVERIFY",
+	OpCheckLockHeight: "OP_CHECKLOCKHEIGHT",
+	OpCheckLockTime:   "OP_CHECKLOCKTIME",
+	OpCheckRelHeight:  "OP_CHECKRELHEIGHT",
+	OpCheckRelTime:    "OP_CHECKRELTIME",
+}
+
+func (op Opcode) String() string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	if op > OpFalse && op <= maxDirectPush {
+		return "OP_PUSH"
+	}
+	return "OP_UNKNOWN"
+}
+
+// isPush reports whether the opcode only pushes data onto the stack
+func (op Opcode) isPush() bool {
+	return op <= OpPushData2 || op == OpTrue
+}
+
+// cost is what executing the opcode takes from the execution budget
+func (op Opcode) cost() int {
+	switch op {
+	case OpCheckSig, OpCheckSigVerify:
+		return 50
+	case OpSHA256, OpAddress:
+		return 10
+	default:
+		return 1
+	}
+}
@@ -1,31 +1,179 @@
 package types
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"fmt"
 
 	pb "github.com/golang/protobuf/proto"
 	"github.com/webstradev/blockstra/crypto"
 	"github.com/webstradev/blockstra/proto"
 )
 
-// SignBlock hashes and then signs a blocks or panics if fialing to hash
+// SignBlock hashes and then signs a block
+func SignBlock(pk *crypto.PrivateKey, b *proto.Block) (*crypto.Signature, error) {
+	hash, err := HashBlock(b)
+	if err != nil {
+		return nil, err
+	}
+	return pk.Sign(hash), nil
+}
+
+// MustSignBlock hashes and then signs a blocks or panics if fialing to hash
 func MustSignBlock(pk *crypto.PrivateKey, b *proto.Block) *crypto.Signature {
 	return pk.Sign(MustHashBlock(b))
 }
 
+// HashBlock returns a SHA256 of the header of the block
+func HashBlock(block *proto.Block) ([]byte, error) {
+	if block == nil {
+		return nil, fmt.Errorf("block is nil")
+	}
+	return HashHeader(block.Header)
+}
+
 // MustHashBlock returns a SHA256 of the header or panics if encountering an error
 func MustHashBlock(block *proto.Block) []byte {
-	return MustHashHeader(block.Header)
+	hash, err := HashBlock(block)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// HashHeader returns a SHA256 of the header
+func HashHeader(header *proto.Header) ([]byte, error) {
+	if header == nil {
+		return nil, fmt.Errorf("block header is nil")
+	}
+
+	b, err := pb.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(b)
+
+	return hash[:], nil
 }
 
 // MustHashHeader returns a SHA256 of the header or panics if encountering an error
 func MustHashHeader(header *proto.Header) []byte {
-	b, err := pb.Marshal(header)
+	hash, err := HashHeader(header)
 	if err != nil {
 		panic(err)
 	}
+	return hash
+}
 
-	hash := sha256.Sum256(b)
+// CalculateRootHash returns the merkle root of the hashes of the
+// transactions, committing the header to the transactions of the block.
+// A block without transactions has a nil root hash.
+func CalculateRootHash(txs []*proto.Transaction) ([]byte, error) {
+	hashes := make([][]byte, len(txs))
+	for i, tx := range txs {
+		hash, err := HashTransaction(tx)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+
+	return merkleRoot(hashes), nil
+}
+
+// merkleRoot returns the root of a merkle tree of the hashes. The last hash
+// of a level with an odd number of hashes is paired with itself, so a list
+// repeating its last hashes has the same root. VerifyBlock rejects duplicate
+// hashes for that reason. The root of no hashes is nil.
+func merkleRoot(level [][]byte) []byte {
+	if len(level) == 0 {
+		return nil
+	}
+
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+
+		next := make([][]byte, len(level)/2)
+		for i := range next {
+			hash := sha256.Sum256(append(append([]byte{}, level[2*i]...), level[2*i+1]...))
+			next[i] = hash[:]
+		}
+		level = next
+	}
+
+	return level[0]
+}
+
+// MustCalculateRootHash returns the merkle root of the transactions or panics
+// if a transaction can't be hashed
+func MustCalculateRootHash(txs []*proto.Transaction) []byte {
+	root, err := CalculateRootHash(txs)
+	if err != nil {
+		panic(err)
+	}
+	return root
+}
+
+// VerifyBlock checks that a block received from the network is well formed,
+// that its root and evidence hashes match its contents and that all of its
+// transactions and evidence carry valid signatures. Blocks holding a
+// transaction or evidence twice are rejected, so the header hash of a valid
+// block stands for exactly one body.
+func VerifyBlock(block *proto.Block) error {
+	if _, err := HashBlock(block); err != nil {
+		return err
+	}
+
+	root, err := CalculateRootHash(block.Transactions)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(root, block.Header.RootHash) {
+		return fmt.Errorf("root hash %x does not match the transactions", block.Header.RootHash)
+	}
+
+	evidenceHash, err := CalculateEvidenceHash(block.Evidence)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(evidenceHash, block.Header.EvidenceHash) {
+		return fmt.Errorf("evidence hash %x does not match the evidence", block.Header.EvidenceHash)
+	}
+
+	txHashes := make(map[string]bool, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		hash := string(MustHashTransaction(tx))
+		if txHashes[hash] {
+			return fmt.Errorf("duplicate transaction at index %d", i)
+		}
+		txHashes[hash] = true
+	}
+	evidenceHashes := make(map[string]bool, len(block.Evidence))
+	for i, ev := range block.Evidence {
+		hash := string(MustHashEvidence(ev))
+		if evidenceHashes[hash] {
+			return fmt.Errorf("duplicate evidence at index %d", i)
+		}
+		evidenceHashes[hash] = true
+	}
+
+	for i, ev := range block.Evidence {
+		if err := VerifyEvidence(ev); err != nil {
+			return fmt.Errorf("invalid evidence at index %d: %w", i, err)
+		}
+		if ev.First.Header.Height > block.Header.Height {
+			return fmt.Errorf("evidence at index %d is from a later height", i)
+		}
+	}
+
+	for i, tx := range block.Transactions {
+		if err := ValidateTransaction(tx); err != nil {
+			return fmt.Errorf("invalid transaction at index %d: %w", i, err)
+		}
+	}
 
-	return hash[:]
+	return nil
 }
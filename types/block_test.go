@@ -3,8 +3,10 @@ package types
 import (
 	"testing"
 
+	pb "github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
 	"github.com/webstradev/blockstra/util"
 )
 
@@ -30,3 +32,69 @@ func TestMustHashBlock(t *testing.T) {
 
 	assert.Equal(t, 32, len(hash))
 }
+
+func TestVerifyBlock(t *testing.T) {
+	block := util.RandomBlock()
+	assert.NoError(t, VerifyBlock(block))
+
+	// The root hash doesn't commit to the transaction
+	block.Transactions = []*proto.Transaction{
+		{Inputs: []*proto.TxInput{{PublicKey: []byte{1}}}},
+	}
+	assert.Error(t, VerifyBlock(block))
+
+	// The root hash matches but the transaction is malformed
+	block.Header.RootHash = MustCalculateRootHash(block.Transactions)
+	assert.Error(t, VerifyBlock(block))
+
+	assert.Error(t, VerifyBlock(nil))
+	assert.Error(t, VerifyBlock(&proto.Block{}))
+}
+
+func TestCalculateRootHash(t *testing.T) {
+	root, err := CalculateRootHash(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, root)
+
+	txs := []*proto.Transaction{
+		{Version: 1},
+		{Version: 2},
+		{Version: 3},
+	}
+
+	// A single transaction is its own root
+	assert.Equal(t, MustHashTransaction(txs[0]), MustCalculateRootHash(txs[:1]))
+
+	root = MustCalculateRootHash(txs)
+	assert.Equal(t, 32, len(root))
+
+	// Reordering the transactions changes the root
+	assert.NotEqual(t, root, MustCalculateRootHash([]*proto.Transaction{txs[1], txs[0], txs[2]}))
+
+	// Repeating the last transaction of an odd list keeps the root, so blocks
+	// holding a transaction twice are rejected
+	duplicated := append(txs, txs[2])
+	assert.Equal(t, root, MustCalculateRootHash(duplicated))
+
+	block := util.RandomBlock()
+	block.Transactions = duplicated
+	block.Header.RootHash = root
+	assert.ErrorContains(t, VerifyBlock(block), "duplicate transaction")
+}
+
+func FuzzVerifyBlock(f *testing.F) {
+	seed, err := pb.Marshal(util.RandomBlock())
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(seed)
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		block := &proto.Block{}
+		if err := pb.Unmarshal(data, block); err != nil {
+			return
+		}
+		VerifyBlock(block)
+	})
+}
@@ -0,0 +1,150 @@
+package types
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	pb "github.com/golang/protobuf/proto"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+)
+
+// NewEvidence returns the evidence of the proposer of two different blocks at
+// the same height double signing. The headers are ordered by hash, so both
+// orders of the blocks result in the same evidence.
+func NewEvidence(a, b *proto.Block) (*proto.Evidence, error) {
+	ev := &proto.Evidence{
+		First:  signedHeader(a),
+		Second: signedHeader(b),
+	}
+
+	if err := VerifyEvidence(ev); err != nil {
+		return nil, err
+	}
+
+	if bytes.Compare(MustHashHeader(ev.First.Header), MustHashHeader(ev.Second.Header)) > 0 {
+		ev.First, ev.Second = ev.Second, ev.First
+	}
+
+	return ev, nil
+}
+
+// VerifyEvidence checks that the evidence holds two different headers at the
+// same height, both signed by the same key
+func VerifyEvidence(ev *proto.Evidence) error {
+	if ev == nil || ev.First == nil || ev.Second == nil {
+		return fmt.Errorf("evidence is incomplete")
+	}
+
+	first, err := verifySignedHeader(ev.First)
+	if err != nil {
+		return err
+	}
+	second, err := verifySignedHeader(ev.Second)
+	if err != nil {
+		return err
+	}
+
+	if ev.First.Header.Height != ev.Second.Header.Height {
+		return fmt.Errorf("evidence headers are at different heights")
+	}
+	if !bytes.Equal(ev.First.PublicKey, ev.Second.PublicKey) {
+		return fmt.Errorf("evidence headers are signed by different keys")
+	}
+	if bytes.Equal(first, second) {
+		return fmt.Errorf("evidence holds the same header twice")
+	}
+
+	return nil
+}
+
+// EvidenceOffender returns the key of the validator that double signed
+func EvidenceOffender(ev *proto.Evidence) (*crypto.PublicKey, error) {
+	return crypto.PublicKeyFromBytes(ev.First.PublicKey)
+}
+
+// HashEvidence returns a SHA256 of the evidence
+func HashEvidence(ev *proto.Evidence) ([]byte, error) {
+	if ev == nil {
+		return nil, fmt.Errorf("evidence is nil")
+	}
+
+	b, err := pb.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(b)
+
+	return hash[:], nil
+}
+
+func MustHashEvidence(ev *proto.Evidence) []byte {
+	hash, err := HashEvidence(ev)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// CalculateEvidenceHash returns the merkle root of the hashes of the evidence
+// of a block, nil without evidence
+func CalculateEvidenceHash(evidence []*proto.Evidence) ([]byte, error) {
+	hashes := make([][]byte, len(evidence))
+	for i, ev := range evidence {
+		hash, err := HashEvidence(ev)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+
+	return merkleRoot(hashes), nil
+}
+
+// MustCalculateEvidenceHash returns the merkle root of the evidence or panics
+// if the evidence can't be hashed
+func MustCalculateEvidenceHash(evidence []*proto.Evidence) []byte {
+	hash, err := CalculateEvidenceHash(evidence)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// VerifyBlockSignature checks that the block is signed by the key it holds,
+// which says nothing about whether the key may propose the block
+func VerifyBlockSignature(block *proto.Block) error {
+	_, err := verifySignedHeader(signedHeader(block))
+	return err
+}
+
+func signedHeader(block *proto.Block) *proto.SignedHeader {
+	return &proto.SignedHeader{Header: block.Header, PublicKey: block.PublicKey, Signature: block.Signature}
+}
+
+// verifySignedHeader checks the signature of a signed header and returns the
+// hash of the header
+func verifySignedHeader(h *proto.SignedHeader) ([]byte, error) {
+	hash, err := HashHeader(h.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, err := crypto.PublicKeyFromBytes(h.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := crypto.SignatureFromBytes(h.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sig.Verify(pubKey, hash) {
+		return nil, fmt.Errorf("invalid header signature")
+	}
+
+	return hash, nil
+}
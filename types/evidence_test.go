@@ -0,0 +1,97 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/util"
+)
+
+func signedTestBlock(pk *crypto.PrivateKey, height int32) *proto.Block {
+	block := util.RandomBlock()
+	block.Header.Height = height
+	block.PublicKey = pk.Public().Bytes()
+	block.Signature = MustSignBlock(pk, block).Bytes()
+	return block
+}
+
+func TestNewEvidence(t *testing.T) {
+	var (
+		privKey = crypto.MustGeneratePrivateKey()
+		a       = signedTestBlock(privKey, 5)
+		b       = signedTestBlock(privKey, 5)
+	)
+
+	ev, err := NewEvidence(a, b)
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyEvidence(ev))
+
+	// Both orders of the blocks give the same evidence
+	reversed, err := NewEvidence(b, a)
+	assert.NoError(t, err)
+	assert.Equal(t, MustHashEvidence(ev), MustHashEvidence(reversed))
+
+	offender, err := EvidenceOffender(ev)
+	assert.NoError(t, err)
+	assert.Equal(t, privKey.Public().Bytes(), offender.Bytes())
+}
+
+func TestVerifyEvidence(t *testing.T) {
+	var (
+		privKey = crypto.MustGeneratePrivateKey()
+		block   = signedTestBlock(privKey, 5)
+	)
+
+	// The same block twice
+	_, err := NewEvidence(block, block)
+	assert.Error(t, err)
+
+	// Blocks at different heights
+	_, err = NewEvidence(block, signedTestBlock(privKey, 6))
+	assert.Error(t, err)
+
+	// Blocks of different validators
+	_, err = NewEvidence(block, signedTestBlock(crypto.MustGeneratePrivateKey(), 5))
+	assert.Error(t, err)
+
+	// A forged signature
+	forged := signedTestBlock(privKey, 5)
+	forged.Header.Timestamp++
+	_, err = NewEvidence(block, forged)
+	assert.Error(t, err)
+
+	assert.Error(t, VerifyEvidence(nil))
+	assert.Error(t, VerifyEvidence(&proto.Evidence{First: &proto.SignedHeader{}}))
+}
+
+func TestVerifyBlockEvidence(t *testing.T) {
+	var (
+		privKey = crypto.MustGeneratePrivateKey()
+		ev, _   = NewEvidence(signedTestBlock(privKey, 5), signedTestBlock(privKey, 5))
+		block   = util.RandomBlock()
+	)
+	block.Header.Height = 10
+
+	// The evidence hash doesn't commit to the evidence
+	block.Evidence = []*proto.Evidence{ev}
+	assert.Error(t, VerifyBlock(block))
+
+	block.Header.EvidenceHash = MustCalculateEvidenceHash(block.Evidence)
+	assert.NoError(t, VerifyBlock(block))
+
+	// Evidence can't be from the future
+	block.Header.Height = 4
+	assert.Error(t, VerifyBlock(block))
+}
+
+func TestVerifyBlockSignature(t *testing.T) {
+	block := signedTestBlock(crypto.MustGeneratePrivateKey(), 5)
+	assert.NoError(t, VerifyBlockSignature(block))
+
+	block.Header.Timestamp++
+	assert.Error(t, VerifyBlockSignature(block))
+
+	assert.Error(t, VerifyBlockSignature(util.RandomBlock()))
+}
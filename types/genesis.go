@@ -0,0 +1,121 @@
+package types
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+)
+
+// GenesisAllocation assigns an initial balance to an address
+type GenesisAllocation struct {
+	Address crypto.Address
+	Amount  int64
+}
+
+// Genesis describes the initial state of a chain
+type Genesis struct {
+	Timestamp   int64
+	Allocations []GenesisAllocation
+	// Validators are the public keys allowed to propose blocks with proof of
+	// authority
+	Validators []*crypto.PublicKey
+	// Difficulty is the initial difficulty of a proof of work chain
+	Difficulty uint64
+}
+
+// Block returns the genesis block paying out the allocations. It commits to
+// the validators, so chains with other validators have other genesis blocks.
+func (g *Genesis) Block() *proto.Block {
+	block := NewGenesisBlock(g.Timestamp, g.Allocations)
+	block.Header.Difficulty = g.Difficulty
+	block.Header.ValidatorsHash = CalculateValidatorsHash(g.Validators)
+	return block
+}
+
+// CalculateValidatorsHash returns the merkle root of the hashes of the
+// validators, in order since the order decides whose turn it is
+func CalculateValidatorsHash(validators []*crypto.PublicKey) []byte {
+	hashes := make([][]byte, len(validators))
+	for i, v := range validators {
+		hash := sha256.Sum256(v.Bytes())
+		hashes[i] = hash[:]
+	}
+	return merkleRoot(hashes)
+}
+
+// Validate returns an error if the genesis has no validators or lists a
+// validator more than once
+func (g *Genesis) Validate() error {
+	if len(g.Validators) == 0 {
+		return fmt.Errorf("genesis has no validators")
+	}
+
+	for i, v := range g.Validators {
+		if v == nil {
+			return fmt.Errorf("validator %d is nil", i)
+		}
+		for _, other := range g.Validators[:i] {
+			if bytes.Equal(v.Bytes(), other.Bytes()) {
+				return fmt.Errorf("validator %d is listed more than once", i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// NewGenesisBlock creates the block at height 0. It holds a single transaction
+// without inputs paying out all the allocations.
+func NewGenesisBlock(timestamp int64, allocations []GenesisAllocation) *proto.Block {
+	tx := &proto.Transaction{
+		Version: 1,
+		Outputs: make([]*proto.TxOutput, len(allocations)),
+	}
+
+	for i, alloc := range allocations {
+		tx.Outputs[i] = &proto.TxOutput{
+			Amount:  alloc.Amount,
+			Address: alloc.Address.Bytes(),
+		}
+	}
+
+	txs := []*proto.Transaction{tx}
+
+	return &proto.Block{
+		Header: &proto.Header{
+			Version:   "1",
+			Height:    0,
+			RootHash:  MustCalculateRootHash(txs),
+			Timestamp: timestamp,
+		},
+		Transactions: txs,
+	}
+}
+
+// MigrateGenesisAllocations rewrites allocations made to a legacy address to
+// the current address version, for every owner whose public key is known.
+// Allocations of unknown owners are kept as they are, the outputs created for
+// them stay spendable since crypto.PublicKey.Owns understands every version.
+func MigrateGenesisAllocations(allocations []GenesisAllocation, owners []*crypto.PublicKey) []GenesisAllocation {
+	migrated := make([]GenesisAllocation, len(allocations))
+
+	for i, alloc := range allocations {
+		migrated[i] = alloc
+
+		if alloc.Address.Version() == crypto.CurrentAddressVersion {
+			continue
+		}
+
+		for _, owner := range owners {
+			if owner.Owns(alloc.Address) {
+				migrated[i].Address = owner.Address()
+				break
+			}
+		}
+	}
+
+	return migrated
+}
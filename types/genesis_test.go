@@ -0,0 +1,75 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+)
+
+func TestNewGenesisBlock(t *testing.T) {
+	address := crypto.MustGeneratePrivateKey().Public().Address()
+
+	block := NewGenesisBlock(0, []GenesisAllocation{{Address: address, Amount: 1000}})
+
+	assert.Equal(t, int32(0), block.Header.Height)
+	assert.Equal(t, 1, len(block.Transactions))
+	assert.Equal(t, int64(1000), block.Transactions[0].Outputs[0].Amount)
+	assert.Equal(t, address.Bytes(), block.Transactions[0].Outputs[0].Address)
+	assert.NoError(t, VerifyBlock(block))
+}
+
+func TestMigrateGenesisAllocations(t *testing.T) {
+	var (
+		known   = crypto.MustGeneratePrivateKey().Public()
+		unknown = crypto.MustGeneratePrivateKey().Public()
+	)
+
+	knownLegacy, err := known.AddressWithVersion(crypto.AddressVersionLegacy)
+	assert.NoError(t, err)
+	unknownLegacy, err := unknown.AddressWithVersion(crypto.AddressVersionLegacy)
+	assert.NoError(t, err)
+
+	allocations := []GenesisAllocation{
+		{Address: knownLegacy, Amount: 10},
+		{Address: unknownLegacy, Amount: 20},
+		{Address: unknown.Address(), Amount: 30},
+	}
+
+	migrated := MigrateGenesisAllocations(allocations, []*crypto.PublicKey{known})
+
+	assert.Equal(t, known.Address(), migrated[0].Address)
+	assert.Equal(t, int64(10), migrated[0].Amount)
+	assert.Equal(t, unknownLegacy, migrated[1].Address)
+	assert.Equal(t, unknown.Address(), migrated[2].Address)
+
+	// The original allocations are left untouched
+	assert.Equal(t, knownLegacy, allocations[0].Address)
+}
+
+func TestGenesisBlockCommitsToValidators(t *testing.T) {
+	var (
+		a = crypto.MustGeneratePrivateKey().Public()
+		b = crypto.MustGeneratePrivateKey().Public()
+	)
+
+	genesis := &Genesis{Timestamp: 1, Validators: []*crypto.PublicKey{a, b}}
+	assert.Equal(t, MustHashBlock(genesis.Block()), MustHashBlock(genesis.Block()))
+
+	for _, validators := range [][]*crypto.PublicKey{{a}, {b, a}, nil} {
+		other := &Genesis{Timestamp: 1, Validators: validators}
+		assert.NotEqual(t, MustHashBlock(genesis.Block()), MustHashBlock(other.Block()))
+	}
+}
+
+func TestGenesisValidate(t *testing.T) {
+	var (
+		a = crypto.MustGeneratePrivateKey().Public()
+		b = crypto.MustGeneratePrivateKey().Public()
+	)
+
+	assert.NoError(t, (&Genesis{Validators: []*crypto.PublicKey{a, b}}).Validate())
+	assert.Error(t, (&Genesis{}).Validate())
+	assert.Error(t, (&Genesis{Validators: []*crypto.PublicKey{a, nil}}).Validate())
+	assert.Error(t, (&Genesis{Validators: []*crypto.PublicKey{a, b, a}}).Validate())
+}
@@ -0,0 +1,169 @@
+package types
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/script"
+)
+
+// HTLC is a hash time-locked contract. Its output can be claimed by the
+// recipient with the preimage of Hash, or refunded to the sender once the
+// chain reached the Timeout height. Used for atomic swaps.
+type HTLC struct {
+	// Hash is the SHA256 of the secret preimage
+	Hash      []byte
+	Recipient crypto.Address
+	Refund    crypto.Address
+	Timeout   int32
+}
+
+// LockingScript returns the script locking an HTLC output:
+//
+//	OP_IF
+//	  OP_SHA256 <hash> OP_EQUALVERIFY OP_DUP OP_ADDRESS <recipient>
+//	OP_ELSE
+//	  <timeout> OP_CHECKLOCKHEIGHT OP_DROP OP_DUP OP_ADDRESS <refund>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+func (h *HTLC) LockingScript() ([]byte, error) {
+	if len(h.Hash) != sha256.Size {
+		return nil, fmt.Errorf("invalid htlc hash length %d, must be %d", len(h.Hash), sha256.Size)
+	}
+
+	if h.Timeout <= 0 {
+		return nil, fmt.Errorf("htlc timeout must be positive")
+	}
+
+	// OP_ADDRESS derives addresses of the current version, the script could
+	// never be satisfied for addresses of other versions
+	if err := checkHTLCAddress(h.Recipient); err != nil {
+		return nil, fmt.Errorf("invalid htlc recipient: %w", err)
+	}
+	if err := checkHTLCAddress(h.Refund); err != nil {
+		return nil, fmt.Errorf("invalid htlc refund address: %w", err)
+	}
+
+	return script.NewBuilder().
+		AddOp(script.OpIf).
+		AddOp(script.OpSHA256).AddData(h.Hash).AddOp(script.OpEqualVerify).
+		AddOp(script.OpDup).AddOp(script.OpAddress).AddData(h.Recipient.Bytes()).
+		AddOp(script.OpElse).
+		AddInt(int64(h.Timeout)).AddOp(script.OpCheckLockHeight).AddOp(script.OpDrop).
+		AddOp(script.OpDup).AddOp(script.OpAddress).AddData(h.Refund.Bytes()).
+		AddOp(script.OpEndIf).
+		AddOp(script.OpEqualVerify).AddOp(script.OpCheckSig).
+		Script()
+}
+
+func checkHTLCAddress(a crypto.Address) error {
+	if _, err := crypto.AddressFromBytes(a.Bytes()); err != nil {
+		return err
+	}
+	if a.Version() != crypto.CurrentAddressVersion {
+		return fmt.Errorf("address version %d, must be %d", a.Version(), crypto.CurrentAddressVersion)
+	}
+	return nil
+}
+
+// NewHTLCOutput creates an output locked by the contract
+func NewHTLCOutput(amount int64, h *HTLC) (*proto.TxOutput, error) {
+	locking, err := h.LockingScript()
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.TxOutput{
+		Amount:        amount,
+		LockingScript: locking,
+	}, nil
+}
+
+// ParseHTLC extracts the contract from the locking script of an output,
+// failing if the script is not an HTLC.
+func ParseHTLC(locking []byte) (*HTLC, error) {
+	instructions, err := script.Parse(locking)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(instructions) != 17 {
+		return nil, fmt.Errorf("script is not an htlc")
+	}
+
+	recipient, err := crypto.AddressFromBytes(instructions[6].Data)
+	if err != nil {
+		return nil, err
+	}
+
+	refund, err := crypto.AddressFromBytes(instructions[13].Data)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := script.DecodeNumber(instructions[8].Data)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &HTLC{
+		Hash:      instructions[2].Data,
+		Recipient: recipient,
+		Refund:    refund,
+		Timeout:   int32(timeout),
+	}
+
+	// Rebuilding the script verifies all the opcodes around the values
+	rebuilt, err := h.LockingScript()
+	if err != nil || !bytes.Equal(rebuilt, locking) {
+		return nil, fmt.Errorf("script is not an htlc")
+	}
+
+	return h, nil
+}
+
+// SignHTLCClaim signs the transaction and sets the unlocking script of the
+// input spending the HTLC output to claim it with the preimage.
+func SignHTLCClaim(pk *crypto.PrivateKey, tx *proto.Transaction, input *proto.TxInput, preimage []byte) error {
+	sig, err := SignTransaction(pk, tx)
+	if err != nil {
+		return err
+	}
+
+	unlocking, err := script.NewBuilder().
+		AddData(sig.Bytes()).
+		AddData(pk.Public().Bytes()).
+		AddData(preimage).
+		AddOp(script.OpTrue).
+		Script()
+	if err != nil {
+		return err
+	}
+
+	input.UnlockingScript = unlocking
+	return nil
+}
+
+// SignHTLCRefund signs the transaction and sets the unlocking script of the
+// input spending the HTLC output to refund it after the timeout.
+func SignHTLCRefund(pk *crypto.PrivateKey, tx *proto.Transaction, input *proto.TxInput) error {
+	sig, err := SignTransaction(pk, tx)
+	if err != nil {
+		return err
+	}
+
+	unlocking, err := script.NewBuilder().
+		AddData(sig.Bytes()).
+		AddData(pk.Public().Bytes()).
+		AddOp(script.OpFalse).
+		Script()
+	if err != nil {
+		return err
+	}
+
+	input.UnlockingScript = unlocking
+	return nil
+}
@@ -0,0 +1,95 @@
+package types
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/util"
+)
+
+func TestHTLCClaimAndRefund(t *testing.T) {
+	var (
+		sender    = crypto.MustGeneratePrivateKey()
+		recipient = crypto.MustGeneratePrivateKey()
+		preimage  = util.RandomHash()
+		hash      = sha256.Sum256(preimage)
+		prevHash  = util.RandomHash()
+		outputs   = testOutputs{}
+		htlc      = &HTLC{
+			Hash:      hash[:],
+			Recipient: recipient.Public().Address(),
+			Refund:    sender.Public().Address(),
+			Timeout:   100,
+		}
+	)
+
+	output, err := NewHTLCOutput(50, htlc)
+	assert.NoError(t, err)
+	outputs.add(prevHash, 0, output)
+
+	spendTo := func(to *crypto.PrivateKey) *proto.Transaction {
+		return &proto.Transaction{
+			Version: 1,
+			Inputs:  []*proto.TxInput{{PrevTxHash: prevHash}},
+			Outputs: []*proto.TxOutput{{Amount: 50, Address: to.Public().Address().Bytes()}},
+		}
+	}
+
+	// The recipient claims with the preimage before the timeout
+	claim := spendTo(recipient)
+	assert.NoError(t, SignHTLCClaim(recipient, claim, claim.Inputs[0], preimage))
+	assert.NoError(t, VerifyTransaction(claim, outputs, &proto.Header{Height: 10}))
+
+	// A wrong preimage doesn't unlock the output
+	assert.NoError(t, SignHTLCClaim(recipient, claim, claim.Inputs[0], util.RandomHash()))
+	assert.Error(t, VerifyTransaction(claim, outputs, &proto.Header{Height: 10}))
+
+	// Knowing the preimage is not enough for anyone but the recipient
+	assert.NoError(t, SignHTLCClaim(sender, claim, claim.Inputs[0], preimage))
+	assert.Error(t, VerifyTransaction(claim, outputs, &proto.Header{Height: 10}))
+
+	// The sender can only refund after the timeout
+	refund := spendTo(sender)
+	assert.NoError(t, SignHTLCRefund(sender, refund, refund.Inputs[0]))
+	assert.Error(t, VerifyTransaction(refund, outputs, &proto.Header{Height: 99}))
+	assert.NoError(t, VerifyTransaction(refund, outputs, &proto.Header{Height: 100}))
+
+	// The recipient can't take the refund path
+	assert.NoError(t, SignHTLCRefund(recipient, refund, refund.Inputs[0]))
+	assert.Error(t, VerifyTransaction(refund, outputs, &proto.Header{Height: 100}))
+}
+
+func TestParseHTLC(t *testing.T) {
+	hash := sha256.Sum256([]byte("secret"))
+	htlc := &HTLC{
+		Hash:      hash[:],
+		Recipient: crypto.MustGeneratePrivateKey().Public().Address(),
+		Refund:    crypto.MustGeneratePrivateKey().Public().Address(),
+		Timeout:   1234,
+	}
+
+	locking, err := htlc.LockingScript()
+	assert.NoError(t, err)
+
+	parsed, err := ParseHTLC(locking)
+	assert.NoError(t, err)
+	assert.Equal(t, htlc, parsed)
+
+	// Other scripts are not mistaken for an htlc
+	_, err = ParseHTLC(locking[:len(locking)-1])
+	assert.Error(t, err)
+
+	_, err = (&HTLC{Hash: []byte{1}, Timeout: 1}).LockingScript()
+	assert.Error(t, err)
+
+	// Addresses the script can't derive are rejected
+	legacy, err := crypto.MustGeneratePrivateKey().Public().AddressWithVersion(crypto.AddressVersionLegacy)
+	assert.NoError(t, err)
+	_, err = (&HTLC{Hash: hash[:], Recipient: legacy, Refund: htlc.Refund, Timeout: 1}).LockingScript()
+	assert.Error(t, err)
+	_, err = (&HTLC{Hash: hash[:], Recipient: htlc.Recipient, Timeout: 1}).LockingScript()
+	assert.Error(t, err)
+}
@@ -0,0 +1,120 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+)
+
+// MaxMultisigKeys is the maximum number of public keys in a multisig policy
+const MaxMultisigKeys = 16
+
+// NewMultisigOutput creates an output that can only be spent with signatures
+// of at least threshold of the given public keys.
+func NewMultisigOutput(amount int64, threshold uint32, pubKeys []*crypto.PublicKey) (*proto.TxOutput, error) {
+	policy := &proto.MultisigPolicy{
+		Threshold:  threshold,
+		PublicKeys: make([][]byte, len(pubKeys)),
+	}
+
+	for i, pubKey := range pubKeys {
+		policy.PublicKeys[i] = pubKey.Bytes()
+	}
+
+	if err := ValidateMultisigPolicy(policy); err != nil {
+		return nil, err
+	}
+
+	return &proto.TxOutput{
+		Amount:   amount,
+		Multisig: policy,
+	}, nil
+}
+
+// ValidateMultisigPolicy checks that the threshold can be met and that every
+// key of the policy is a valid and unique public key.
+func ValidateMultisigPolicy(policy *proto.MultisigPolicy) error {
+	n := len(policy.PublicKeys)
+	if n == 0 || n > MaxMultisigKeys {
+		return fmt.Errorf("multisig policy must have between 1 and %d keys, got %d", MaxMultisigKeys, n)
+	}
+
+	if policy.Threshold == 0 || int(policy.Threshold) > n {
+		return fmt.Errorf("multisig threshold %d out of range for %d keys", policy.Threshold, n)
+	}
+
+	seen := map[string]bool{}
+	for i, b := range policy.PublicKeys {
+		if _, err := crypto.PublicKeyFromBytes(b); err != nil {
+			return fmt.Errorf("multisig key %d: %w", i, err)
+		}
+		if seen[string(b)] {
+			return fmt.Errorf("multisig key %d is a duplicate", i)
+		}
+		seen[string(b)] = true
+	}
+
+	return nil
+}
+
+// SignMultisigInput signs the transaction with one of the keys of the policy,
+// the result has to be added to the MultisigSignatures of the spending input.
+func SignMultisigInput(pk *crypto.PrivateKey, policy *proto.MultisigPolicy, tx *proto.Transaction) (*proto.MultisigSignature, error) {
+	pubKey := pk.Public().Bytes()
+
+	for i, b := range policy.PublicKeys {
+		if string(b) != string(pubKey) {
+			continue
+		}
+
+		sig, err := SignTransaction(pk, tx)
+		if err != nil {
+			return nil, err
+		}
+
+		return &proto.MultisigSignature{
+			KeyIndex:  uint32(i),
+			Signature: sig.Bytes(),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("key is not part of the multisig policy")
+}
+
+// verifyMultisig checks that sigs contains valid signatures over hash of at
+// least threshold distinct keys of the policy.
+func verifyMultisig(policy *proto.MultisigPolicy, sigs []*proto.MultisigSignature, hash []byte) error {
+	if err := ValidateMultisigPolicy(policy); err != nil {
+		return err
+	}
+
+	signed := map[uint32]bool{}
+	for _, ms := range sigs {
+		if ms == nil || int(ms.KeyIndex) >= len(policy.PublicKeys) {
+			return fmt.Errorf("multisig signature references an unknown key")
+		}
+		if signed[ms.KeyIndex] {
+			return fmt.Errorf("multisig key %d signed more than once", ms.KeyIndex)
+		}
+
+		sig, err := crypto.SignatureFromBytes(ms.Signature)
+		if err != nil {
+			return err
+		}
+
+		// the policy has been validated so the key is known to be valid
+		pubKey := crypto.MustPublicKeyFromBytes(policy.PublicKeys[ms.KeyIndex])
+		if !sig.Verify(pubKey, hash) {
+			return fmt.Errorf("invalid signature of multisig key %d", ms.KeyIndex)
+		}
+
+		signed[ms.KeyIndex] = true
+	}
+
+	if len(signed) < int(policy.Threshold) {
+		return fmt.Errorf("multisig requires %d signatures, got %d", policy.Threshold, len(signed))
+	}
+
+	return nil
+}
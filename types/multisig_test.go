@@ -0,0 +1,78 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/util"
+)
+
+func TestNewMultisigOutput(t *testing.T) {
+	pubKeys := []*crypto.PublicKey{
+		crypto.MustGeneratePrivateKey().Public(),
+		crypto.MustGeneratePrivateKey().Public(),
+	}
+
+	output, err := NewMultisigOutput(10, 2, pubKeys)
+	assert.NoError(t, err)
+	assert.Nil(t, output.Address)
+	assert.NoError(t, validateOutput(output))
+
+	_, err = NewMultisigOutput(10, 3, pubKeys)
+	assert.Error(t, err)
+
+	_, err = NewMultisigOutput(10, 0, pubKeys)
+	assert.Error(t, err)
+
+	_, err = NewMultisigOutput(10, 1, []*crypto.PublicKey{pubKeys[0], pubKeys[0]})
+	assert.Error(t, err)
+}
+
+func TestVerifyTransactionMultisig(t *testing.T) {
+	var (
+		keys = []*crypto.PrivateKey{
+			crypto.MustGeneratePrivateKey(),
+			crypto.MustGeneratePrivateKey(),
+			crypto.MustGeneratePrivateKey(),
+		}
+		prevHash = util.RandomHash()
+		outputs  = testOutputs{}
+	)
+
+	locked, err := NewMultisigOutput(100, 2, []*crypto.PublicKey{keys[0].Public(), keys[1].Public(), keys[2].Public()})
+	assert.NoError(t, err)
+	outputs.add(prevHash, 0, locked)
+
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs:  []*proto.TxInput{{PrevTxHash: prevHash}},
+		Outputs: []*proto.TxOutput{{Amount: 100, Address: keys[0].Public().Address().Bytes()}},
+	}
+
+	sign := func(pk *crypto.PrivateKey) *proto.MultisigSignature {
+		ms, err := SignMultisigInput(pk, locked.Multisig, tx)
+		assert.NoError(t, err)
+		return ms
+	}
+
+	// A single signature does not meet the threshold
+	tx.Inputs[0].MultisigSignatures = []*proto.MultisigSignature{sign(keys[2])}
+	assert.Error(t, VerifyTransaction(tx, outputs, testHeader))
+
+	// The same key signing twice does not count
+	tx.Inputs[0].MultisigSignatures = []*proto.MultisigSignature{sign(keys[2]), sign(keys[2])}
+	assert.Error(t, VerifyTransaction(tx, outputs, testHeader))
+
+	tx.Inputs[0].MultisigSignatures = []*proto.MultisigSignature{sign(keys[2]), sign(keys[0])}
+	assert.NoError(t, VerifyTransaction(tx, outputs, testHeader))
+
+	// A key outside of the policy can't sign
+	_, err = SignMultisigInput(crypto.MustGeneratePrivateKey(), locked.Multisig, tx)
+	assert.Error(t, err)
+
+	// Signatures become invalid once the transaction changes
+	tx.Outputs[0].Amount = 99
+	assert.Error(t, VerifyTransaction(tx, outputs, testHeader))
+}
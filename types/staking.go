@@ -0,0 +1,134 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+)
+
+const (
+	// UnbondingDelay is the number of blocks the outputs of an unbond
+	// transaction stay locked, the stake can still be slashed meanwhile
+	UnbondingDelay = 20
+	// MinValidatorStake is the stake a validator needs to join the
+	// validators at the start of an epoch
+	MinValidatorStake = 100
+)
+
+// NewStakeOutput returns an output bonding amount to the validator. Only
+// owner can release the stake, with an unbond or rotate key transaction.
+func NewStakeOutput(amount int64, owner crypto.Address, validator *crypto.PublicKey) *proto.TxOutput {
+	return &proto.TxOutput{
+		Amount:    amount,
+		Address:   owner.Bytes(),
+		Validator: validator.Bytes(),
+	}
+}
+
+// NewUnbondOutput returns an output of an unbond transaction paying amount
+// to address once the unbonding delay passed
+func NewUnbondOutput(amount int64, address crypto.Address) *proto.TxOutput {
+	return &proto.TxOutput{
+		Amount:   amount,
+		Address:  address.Bytes(),
+		TimeLock: &proto.TimeLock{Height: UnbondingDelay, Relative: true},
+	}
+}
+
+// IsStake returns whether the output is bonded to a validator
+func IsStake(output *proto.TxOutput) bool {
+	return len(output.Validator) > 0
+}
+
+// validateStaking checks the outputs of a transaction against its type
+func validateStaking(tx *proto.Transaction) error {
+	stakes := 0
+	for _, output := range tx.Outputs {
+		if IsStake(output) {
+			stakes++
+		}
+	}
+
+	switch tx.Type {
+	case proto.TxType_TRANSFER:
+		if stakes > 0 {
+			return fmt.Errorf("stake outputs require a bond transaction")
+		}
+	case proto.TxType_BOND:
+		if stakes == 0 {
+			return fmt.Errorf("bond transaction has no stake outputs")
+		}
+	case proto.TxType_UNBOND:
+		if stakes > 0 {
+			return fmt.Errorf("unbond transaction creates stake outputs")
+		}
+		for i, output := range tx.Outputs {
+			lock := output.TimeLock
+			if lock == nil || !lock.Relative || lock.Height < UnbondingDelay {
+				return fmt.Errorf("output %d of unbond transaction must be locked for %d blocks", i, UnbondingDelay)
+			}
+		}
+	case proto.TxType_ROTATE_KEY:
+		if stakes == 0 || stakes != len(tx.Outputs) {
+			return fmt.Errorf("rotate key transaction may only create stake outputs")
+		}
+		for _, output := range tx.Outputs[1:] {
+			if !bytes.Equal(output.Validator, tx.Outputs[0].Validator) {
+				return fmt.Errorf("rotate key transaction bonds to more than one validator")
+			}
+		}
+	default:
+		return fmt.Errorf("unknown transaction type %d", tx.Type)
+	}
+
+	return nil
+}
+
+// verifyStaking checks the outputs spent by a transaction against its type.
+// Stake can only be spent by unbonding it or by moving all of it to a new key.
+func verifyStaking(tx *proto.Transaction, spent []*SpendableOutput) error {
+	var (
+		stakeIn   int64
+		validator []byte
+	)
+	for i, s := range spent {
+		if !IsStake(s.Output) {
+			if tx.Type == proto.TxType_ROTATE_KEY {
+				return fmt.Errorf("input %d of rotate key transaction spends no stake", i)
+			}
+			continue
+		}
+
+		if tx.Type != proto.TxType_UNBOND && tx.Type != proto.TxType_ROTATE_KEY {
+			return fmt.Errorf("input %d spends stake, which requires an unbond or rotate key transaction", i)
+		}
+		if tx.Type == proto.TxType_ROTATE_KEY && validator != nil && !bytes.Equal(validator, s.Output.Validator) {
+			return fmt.Errorf("rotate key transaction spends stake of more than one validator")
+		}
+		validator = s.Output.Validator
+		stakeIn += s.Output.Amount
+	}
+
+	switch tx.Type {
+	case proto.TxType_UNBOND:
+		if stakeIn == 0 {
+			return fmt.Errorf("unbond transaction spends no stake")
+		}
+	case proto.TxType_ROTATE_KEY:
+		if bytes.Equal(validator, tx.Outputs[0].Validator) {
+			return fmt.Errorf("rotate key transaction keeps the key of the validator")
+		}
+
+		var stakeOut int64
+		for _, output := range tx.Outputs {
+			stakeOut += output.Amount
+		}
+		if stakeOut != stakeIn {
+			return fmt.Errorf("rotate key transaction moves %d of %d stake", stakeOut, stakeIn)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/util"
+)
+
+func TestVerifyTransactionStaking(t *testing.T) {
+	var (
+		owner     = crypto.MustGeneratePrivateKey()
+		address   = owner.Public().Address()
+		validator = crypto.MustGeneratePrivateKey().Public()
+		coinHash  = util.RandomHash()
+		stakeHash = util.RandomHash()
+		outputs   = testOutputs{}
+		header    = &proto.Header{Height: 10}
+	)
+	outputs.add(coinHash, 0, &proto.TxOutput{Amount: 100, Address: address.Bytes()})
+	outputs.add(stakeHash, 0, NewStakeOutput(100, address, validator))
+
+	// verify signs a transaction of the given type spending prevHash
+	verify := func(typ proto.TxType, prevHash []byte, out ...*proto.TxOutput) error {
+		tx := &proto.Transaction{
+			Version: 1,
+			Type:    typ,
+			Inputs:  []*proto.TxInput{{PrevTxHash: prevHash, PublicKey: owner.Public().Bytes()}},
+			Outputs: out,
+		}
+		tx.Inputs[0].Signature = MustSignTransaction(owner, tx).Bytes()
+		return VerifyTransaction(tx, outputs, header)
+	}
+
+	// Bonding
+	assert.NoError(t, verify(proto.TxType_BOND, coinHash, NewStakeOutput(100, address, validator)))
+	assert.Error(t, verify(proto.TxType_TRANSFER, coinHash, NewStakeOutput(100, address, validator)))
+	assert.Error(t, verify(proto.TxType_BOND, coinHash, &proto.TxOutput{Amount: 100, Address: address.Bytes()}))
+	assert.Error(t, verify(proto.TxType_BOND, coinHash, &proto.TxOutput{Amount: 100, Address: address.Bytes(), Validator: []byte{1}}))
+
+	// Unbonding keeps the outputs locked for the delay
+	assert.NoError(t, verify(proto.TxType_UNBOND, stakeHash, NewUnbondOutput(100, address)))
+	assert.Error(t, verify(proto.TxType_UNBOND, stakeHash, &proto.TxOutput{Amount: 100, Address: address.Bytes()}))
+	assert.Error(t, verify(proto.TxType_UNBOND, stakeHash, &proto.TxOutput{
+		Amount:   100,
+		Address:  address.Bytes(),
+		TimeLock: &proto.TimeLock{Height: UnbondingDelay - 1, Relative: true},
+	}))
+	assert.Error(t, verify(proto.TxType_UNBOND, coinHash, NewUnbondOutput(100, address)))
+
+	// Stake can't be spent by other transactions
+	assert.Error(t, verify(proto.TxType_TRANSFER, stakeHash, &proto.TxOutput{Amount: 100, Address: address.Bytes()}))
+	assert.Error(t, verify(proto.TxType_BOND, stakeHash, NewStakeOutput(100, address, crypto.MustGeneratePrivateKey().Public())))
+
+	// Rotating the key moves all of the stake to a new key
+	rotated := crypto.MustGeneratePrivateKey().Public()
+	assert.NoError(t, verify(proto.TxType_ROTATE_KEY, stakeHash, NewStakeOutput(100, address, rotated)))
+	assert.Error(t, verify(proto.TxType_ROTATE_KEY, stakeHash, NewStakeOutput(100, address, validator)))
+	assert.Error(t, verify(proto.TxType_ROTATE_KEY, stakeHash, NewStakeOutput(60, address, rotated)))
+	assert.Error(t, verify(proto.TxType_ROTATE_KEY, stakeHash, NewStakeOutput(50, address, rotated), NewStakeOutput(50, address, validator)))
+	assert.Error(t, verify(proto.TxType_ROTATE_KEY, stakeHash, NewStakeOutput(50, address, rotated), &proto.TxOutput{Amount: 50, Address: address.Bytes()}))
+	assert.Error(t, verify(proto.TxType_ROTATE_KEY, coinHash, NewStakeOutput(100, address, rotated)))
+
+	assert.Error(t, verify(proto.TxType(42), coinHash, &proto.TxOutput{Amount: 100, Address: address.Bytes()}))
+}
@@ -0,0 +1,58 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/webstradev/blockstra/proto"
+)
+
+// ErrTimeLocked is returned for time locks that haven't expired yet
+var ErrTimeLocked = errors.New("locked")
+
+// ValidateTimeLock checks that a time lock is well formed. Relative locks are
+// only meaningful on outputs.
+func ValidateTimeLock(lock *proto.TimeLock, allowRelative bool) error {
+	if lock == nil {
+		return nil
+	}
+
+	if lock.Height < 0 || lock.Timestamp < 0 {
+		return fmt.Errorf("time lock must not be negative")
+	}
+
+	if lock.Relative && !allowRelative {
+		return fmt.Errorf("relative time lock not allowed")
+	}
+
+	return nil
+}
+
+// CheckTimeLock returns an error if the lock has not expired yet in the block
+// with the given header. Relative locks count from the height and timestamp of
+// the block that created the locked output.
+func CheckTimeLock(lock *proto.TimeLock, header *proto.Header, createdHeight int32, createdTimestamp int64) error {
+	if lock == nil {
+		return nil
+	}
+
+	var (
+		height    = header.Height
+		timestamp = header.Timestamp
+	)
+
+	if lock.Relative {
+		height -= createdHeight
+		timestamp -= createdTimestamp
+	}
+
+	if height < lock.Height {
+		return fmt.Errorf("%w until height %d (relative: %t), at %d", ErrTimeLocked, lock.Height, lock.Relative, height)
+	}
+
+	if timestamp < lock.Timestamp {
+		return fmt.Errorf("%w until timestamp %d (relative: %t), at %d", ErrTimeLocked, lock.Timestamp, lock.Relative, timestamp)
+	}
+
+	return nil
+}
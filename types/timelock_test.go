@@ -0,0 +1,76 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/util"
+)
+
+func TestCheckTimeLock(t *testing.T) {
+	header := &proto.Header{Height: 10, Timestamp: 1_000}
+
+	assert.NoError(t, CheckTimeLock(nil, header, 0, 0))
+	assert.NoError(t, CheckTimeLock(&proto.TimeLock{Height: 10}, header, 0, 0))
+	assert.ErrorIs(t, CheckTimeLock(&proto.TimeLock{Height: 11}, header, 0, 0), ErrTimeLocked)
+	assert.NoError(t, CheckTimeLock(&proto.TimeLock{Timestamp: 1_000}, header, 0, 0))
+	assert.ErrorIs(t, CheckTimeLock(&proto.TimeLock{Timestamp: 1_001}, header, 0, 0), ErrTimeLocked)
+
+	// Both a height and a timestamp have to be reached
+	assert.Error(t, CheckTimeLock(&proto.TimeLock{Height: 5, Timestamp: 2_000}, header, 0, 0))
+
+	// Relative locks count from the creating block
+	relative := &proto.TimeLock{Height: 5, Timestamp: 500, Relative: true}
+	assert.NoError(t, CheckTimeLock(relative, header, 5, 500))
+	assert.Error(t, CheckTimeLock(relative, header, 6, 500))
+	assert.Error(t, CheckTimeLock(relative, header, 5, 501))
+}
+
+func TestValidateTimeLock(t *testing.T) {
+	assert.NoError(t, ValidateTimeLock(nil, false))
+	assert.NoError(t, ValidateTimeLock(&proto.TimeLock{Height: 1, Relative: true}, true))
+	assert.Error(t, ValidateTimeLock(&proto.TimeLock{Height: 1, Relative: true}, false))
+	assert.Error(t, ValidateTimeLock(&proto.TimeLock{Height: -1}, true))
+}
+
+func TestVerifyTransactionTimeLocks(t *testing.T) {
+	var (
+		privKey  = crypto.MustGeneratePrivateKey()
+		address  = privKey.Public().Address().Bytes()
+		prevHash = util.RandomHash()
+		outputs  = testOutputs{}
+	)
+
+	outputs.addSpendable(prevHash, 0, &SpendableOutput{
+		Output: &proto.TxOutput{
+			Amount:   10,
+			Address:  address,
+			TimeLock: &proto.TimeLock{Height: 3, Relative: true},
+		},
+		Height: 8,
+	})
+
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs:  []*proto.TxInput{{PrevTxHash: prevHash, PublicKey: privKey.Public().Bytes()}},
+		Outputs: []*proto.TxOutput{{Amount: 10, Address: address}},
+	}
+	tx.Inputs[0].Signature = MustSignTransaction(privKey, tx).Bytes()
+
+	// Created at height 8 and locked for 3 blocks
+	assert.Error(t, VerifyTransaction(tx, outputs, &proto.Header{Height: 10}))
+	assert.NoError(t, VerifyTransaction(tx, outputs, &proto.Header{Height: 11}))
+
+	tx.LockTime = &proto.TimeLock{Timestamp: 5_000}
+	tx.Inputs[0].Signature = MustSignTransaction(privKey, tx).Bytes()
+
+	assert.Error(t, VerifyTransaction(tx, outputs, &proto.Header{Height: 11, Timestamp: 4_999}))
+	assert.NoError(t, VerifyTransaction(tx, outputs, &proto.Header{Height: 11, Timestamp: 5_000}))
+
+	// The lock time of a transaction can't be relative
+	tx.LockTime.Relative = true
+	tx.Inputs[0].Signature = MustSignTransaction(privKey, tx).Bytes()
+	assert.Error(t, ValidateTransaction(tx))
+}
@@ -2,42 +2,316 @@ package types
 
 import (
 	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
 
 	pb "github.com/golang/protobuf/proto"
 	"github.com/webstradev/blockstra/crypto"
 	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/script"
 )
 
-// SignTransactions hashes and then signs a transaction or panics if fialing to hash
-func MustSignTransaction(pk *crypto.PrivateKey, b *proto.Transaction) *crypto.Signature {
-	return pk.Sign(MustHashTransaction(b))
+// SignTransaction hashes and then signs a transaction
+func SignTransaction(pk *crypto.PrivateKey, tx *proto.Transaction) (*crypto.Signature, error) {
+	hash, err := hashForSigning(tx)
+	if err != nil {
+		return nil, err
+	}
+	return pk.Sign(hash), nil
 }
 
-func MustHashTransaction(tx *proto.Transaction) []byte {
-	b, err := pb.Marshal(tx)
+// MustSignTransaction hashes and then signs a transaction or panics if fialing to hash
+func MustSignTransaction(pk *crypto.PrivateKey, tx *proto.Transaction) *crypto.Signature {
+	sig, err := SignTransaction(pk, tx)
 	if err != nil {
 		panic(err)
 	}
+	return sig
+}
+
+// HashTransaction returns a SHA256 of the complete transaction, signatures included
+func HashTransaction(tx *proto.Transaction) ([]byte, error) {
+	if tx == nil {
+		return nil, fmt.Errorf("transaction is nil")
+	}
+
+	b, err := pb.Marshal(tx)
+	if err != nil {
+		return nil, err
+	}
 
 	hash := sha256.Sum256(b)
 
-	return hash[:]
+	return hash[:], nil
+}
+
+func MustHashTransaction(tx *proto.Transaction) []byte {
+	hash, err := HashTransaction(tx)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// hashForSigning hashes a copy of the transaction with all input signatures
+// stripped, so every input signs the same message regardless of the order in
+// which the inputs are signed.
+func hashForSigning(tx *proto.Transaction) ([]byte, error) {
+	if tx == nil {
+		return nil, fmt.Errorf("transaction is nil")
+	}
+
+	unsigned := pb.Clone(tx).(*proto.Transaction)
+	for _, input := range unsigned.Inputs {
+		if input != nil {
+			input.Signature = nil
+			input.MultisigSignatures = nil
+			input.UnlockingScript = nil
+		}
+	}
+
+	return HashTransaction(unsigned)
+}
+
+// SpendableOutput is an unspent output together with the height and
+// timestamp of the block that created it.
+type SpendableOutput struct {
+	Output    *proto.TxOutput
+	Height    int32
+	Timestamp int64
 }
 
-func VerifyTransaction(tx *proto.Transaction) bool {
-	for _, input := range tx.Inputs {
-		var (
-			sig    = crypto.SignatureFromBytes(input.Signature)
-			pubKey = crypto.PublicKeyFromBytes(input.PublicKey)
-		)
+// OutputSource looks up the outputs spent by the inputs of a transaction,
+// usually the unspent outputs of the chain.
+type OutputSource interface {
+	GetOutput(txHash []byte, index uint32) (*SpendableOutput, error)
+}
+
+// ErrUnknownOutput is returned for inputs spending an output the output
+// source doesn't hold, because it never existed or was spent already
+var ErrUnknownOutput = errors.New("unknown output")
+
+// ValidateTransaction checks everything about a transaction that can be
+// checked without knowing the outputs it spends: that the outputs are well
+// formed and the signatures of all single key inputs are valid. It is safe to
+// call on untrusted data and never modifies the transaction.
+func ValidateTransaction(tx *proto.Transaction) error {
+	hash, err := hashForSigning(tx)
+	if err != nil {
+		return err
+	}
+
+	if err := ValidateTimeLock(tx.LockTime, false); err != nil {
+		return fmt.Errorf("lock time: %w", err)
+	}
+
+	for i, output := range tx.Outputs {
+		if err := validateOutput(output); err != nil {
+			return fmt.Errorf("output %d: %w", i, err)
+		}
+	}
+
+	if err := validateStaking(tx); err != nil {
+		return err
+	}
+
+	spent := map[string]bool{}
+	for i, input := range tx.Inputs {
+		if input == nil {
+			return fmt.Errorf("input %d is nil", i)
+		}
+
+		outpoint := fmt.Sprintf("%x_%d", input.PrevTxHash, input.PrevOutIndex)
+		if spent[outpoint] {
+			return fmt.Errorf("input %d spends the same output twice", i)
+		}
+		spent[outpoint] = true
+
+		// Multisig and script inputs can only be verified against the
+		// output they spend, see VerifyTransaction
+		if len(input.MultisigSignatures) > 0 || len(input.UnlockingScript) > 0 {
+			continue
+		}
+
+		sig, err := crypto.SignatureFromBytes(input.Signature)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+
+		pubKey, err := crypto.PublicKeyFromBytes(input.PublicKey)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+
+		if !sig.Verify(pubKey, hash) {
+			return fmt.Errorf("input %d: invalid signature", i)
+		}
+	}
+
+	return nil
+}
+
+// VerifyTransaction fully verifies a transaction for inclusion in the block
+// with the given header: every input must satisfy the policy of its spent
+// output, all time locks must have expired, the transaction may not create
+// more value than it consumes and stake may only be spent as its type allows.
+func VerifyTransaction(tx *proto.Transaction, outputs OutputSource, header *proto.Header) error {
+	if err := ValidateTransaction(tx); err != nil {
+		return err
+	}
+
+	if header == nil {
+		return fmt.Errorf("missing header of the including block")
+	}
+
+	if err := CheckTimeLock(tx.LockTime, header, 0, 0); err != nil {
+		return fmt.Errorf("transaction %w", err)
+	}
 
-		// @FIX: Make sure we don't run into problems after verification
-		// due to setting the signature to nil
-		input.Signature = nil
+	if len(tx.Inputs) == 0 {
+		return fmt.Errorf("transaction has no inputs")
+	}
+
+	hash, err := hashForSigning(tx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		totalIn, totalOut int64
+		spentOutputs      = make([]*SpendableOutput, 0, len(tx.Inputs))
+	)
+	for i, input := range tx.Inputs {
+		spent, err := outputs.GetOutput(input.PrevTxHash, input.PrevOutIndex)
+		if err != nil {
+			return fmt.Errorf("input %d: %w: %w", i, ErrUnknownOutput, err)
+		}
+
+		if err := verifySpend(input, spent, hash, header); err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+
+		if err := CheckTimeLock(spent.Output.TimeLock, header, spent.Height, spent.Timestamp); err != nil {
+			return fmt.Errorf("input %d: output %w", i, err)
+		}
+
+		if totalIn, err = addAmount(totalIn, spent.Output.Amount); err != nil {
+			return err
+		}
+		spentOutputs = append(spentOutputs, spent)
+	}
+
+	for _, output := range tx.Outputs {
+		if totalOut, err = addAmount(totalOut, output.Amount); err != nil {
+			return err
+		}
+	}
+
+	if totalOut > totalIn {
+		return fmt.Errorf("outputs worth %d exceed inputs worth %d", totalOut, totalIn)
+	}
+
+	return verifyStaking(tx, spentOutputs)
+}
+
+// verifySpend checks that the input is authorized to spend the output. The
+// signature of single key inputs has already been checked by ValidateTransaction.
+func verifySpend(input *proto.TxInput, spent *SpendableOutput, hash []byte, header *proto.Header) error {
+	output := spent.Output
+
+	if len(output.LockingScript) > 0 {
+		if len(input.MultisigSignatures) > 0 {
+			return fmt.Errorf("multisig signatures given for a script output")
+		}
+
+		return script.Execute(input.UnlockingScript, output.LockingScript, &script.Context{
+			SigHash:          hash,
+			Header:           header,
+			CreatedHeight:    spent.Height,
+			CreatedTimestamp: spent.Timestamp,
+		})
+	}
+
+	if len(input.UnlockingScript) > 0 {
+		return fmt.Errorf("unlocking script given for an output without locking script")
+	}
+
+	if output.Multisig != nil {
+		return verifyMultisig(output.Multisig, input.MultisigSignatures, hash)
+	}
+
+	if len(input.MultisigSignatures) > 0 {
+		return fmt.Errorf("multisig signatures given for a single key output")
+	}
+
+	address, err := crypto.AddressFromBytes(output.Address)
+	if err != nil {
+		return err
+	}
+
+	pubKey, err := crypto.PublicKeyFromBytes(input.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	if !pubKey.Owns(address) {
+		return fmt.Errorf("public key does not own address %s", address)
+	}
+
+	return nil
+}
+
+// addAmount adds two non negative amounts, guarding against overflows
+func addAmount(total, amount int64) (int64, error) {
+	if amount < 0 || total > math.MaxInt64-amount {
+		return 0, fmt.Errorf("amount out of range")
+	}
+	return total + amount, nil
+}
+
+func validateOutput(output *proto.TxOutput) error {
+	if output == nil {
+		return fmt.Errorf("output is nil")
+	}
+
+	if output.Amount <= 0 {
+		return fmt.Errorf("amount must be positive, got %d", output.Amount)
+	}
+
+	if err := ValidateTimeLock(output.TimeLock, true); err != nil {
+		return err
+	}
+
+	locks := 0
+	for _, set := range []bool{len(output.Address) > 0, output.Multisig != nil, len(output.LockingScript) > 0} {
+		if set {
+			locks++
+		}
+	}
+	if locks != 1 {
+		return fmt.Errorf("output must be locked to exactly one of an address, a multisig policy or a script")
+	}
+
+	if IsStake(output) {
+		if len(output.Address) == 0 {
+			return fmt.Errorf("stake output must be locked to an address")
+		}
+		if _, err := crypto.PublicKeyFromBytes(output.Validator); err != nil {
+			return fmt.Errorf("stake output: %w", err)
+		}
+	}
 
-		if !sig.Verify(pubKey, MustHashTransaction(tx)) {
-			return false
+	switch {
+	case output.Multisig != nil:
+		return ValidateMultisigPolicy(output.Multisig)
+	case len(output.LockingScript) > 0:
+		if len(output.LockingScript) > script.MaxScriptSize {
+			return fmt.Errorf("locking script of %d bytes exceeds the maximum of %d", len(output.LockingScript), script.MaxScriptSize)
 		}
+		return nil
+	default:
+		_, err := crypto.AddressFromBytes(output.Address)
+		return err
 	}
-	return true
 }
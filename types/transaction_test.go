@@ -1,14 +1,40 @@
 package types
 
 import (
+	"fmt"
 	"testing"
 
+	pb "github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/webstradev/blockstra/crypto"
 	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/script"
 	"github.com/webstradev/blockstra/util"
 )
 
+// testHeader is the header of the block transactions are verified for
+var testHeader = &proto.Header{Height: 10, Timestamp: 10_000}
+
+// testOutputs is an in memory OutputSource keyed by hash and index
+type testOutputs map[string]*SpendableOutput
+
+// add adds an output created in the genesis block
+func (o testOutputs) add(txHash []byte, index uint32, output *proto.TxOutput) {
+	o.addSpendable(txHash, index, &SpendableOutput{Output: output})
+}
+
+func (o testOutputs) addSpendable(txHash []byte, index uint32, output *SpendableOutput) {
+	o[fmt.Sprintf("%x_%d", txHash, index)] = output
+}
+
+func (o testOutputs) GetOutput(txHash []byte, index uint32) (*SpendableOutput, error) {
+	output, ok := o[fmt.Sprintf("%x_%d", txHash, index)]
+	if !ok {
+		return nil, fmt.Errorf("output not found")
+	}
+	return output, nil
+}
+
 func TestMustHashTransaction(t *testing.T) {
 	fromPrivKey := crypto.MustGeneratePrivateKey()
 	fromAddress := fromPrivKey.Public().Address().Bytes()
@@ -41,5 +67,159 @@ func TestMustHashTransaction(t *testing.T) {
 	sig := MustSignTransaction(fromPrivKey, tx)
 	input.Signature = sig.Bytes()
 
-	assert.True(t, VerifyTransaction(tx))
+	outputs := testOutputs{}
+	outputs.add(input.PrevTxHash, 0, &proto.TxOutput{Amount: 100, Address: fromAddress})
+
+	assert.NoError(t, VerifyTransaction(tx, outputs, testHeader))
+}
+
+func TestVerifyTransactionSpentOutput(t *testing.T) {
+	var (
+		privKey      = crypto.MustGeneratePrivateKey()
+		otherPrivKey = crypto.MustGeneratePrivateKey()
+		prevHash     = util.RandomHash()
+		outputs      = testOutputs{}
+	)
+
+	outputs.add(prevHash, 0, &proto.TxOutput{Amount: 50, Address: privKey.Public().Address().Bytes()})
+
+	makeTx := func(signer *crypto.PrivateKey, amount int64) *proto.Transaction {
+		tx := &proto.Transaction{
+			Version: 1,
+			Inputs:  []*proto.TxInput{{PrevTxHash: prevHash, PublicKey: signer.Public().Bytes()}},
+			Outputs: []*proto.TxOutput{{Amount: amount, Address: otherPrivKey.Public().Address().Bytes()}},
+		}
+		tx.Inputs[0].Signature = MustSignTransaction(signer, tx).Bytes()
+		return tx
+	}
+
+	assert.NoError(t, VerifyTransaction(makeTx(privKey, 50), outputs, testHeader))
+	// Spending more than the output holds
+	assert.Error(t, VerifyTransaction(makeTx(privKey, 51), outputs, testHeader))
+	// Validly signed, but by a key that doesn't own the output
+	assert.Error(t, VerifyTransaction(makeTx(otherPrivKey, 10), outputs, testHeader))
+	// Spending an unknown output
+	assert.Error(t, VerifyTransaction(makeTx(privKey, 10), testOutputs{}, testHeader))
+
+	// Outputs locked to a legacy address stay spendable
+	legacy, err := privKey.Public().AddressWithVersion(crypto.AddressVersionLegacy)
+	assert.NoError(t, err)
+	outputs.add(prevHash, 0, &proto.TxOutput{Amount: 50, Address: legacy.Bytes()})
+	assert.NoError(t, VerifyTransaction(makeTx(privKey, 50), outputs, testHeader))
+}
+
+func TestVerifyTransactionMultipleInputs(t *testing.T) {
+	var (
+		privKeyA = crypto.MustGeneratePrivateKey()
+		privKeyB = crypto.MustGeneratePrivateKey()
+	)
+
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs: []*proto.TxInput{
+			{PrevTxHash: util.RandomHash(), PublicKey: privKeyA.Public().Bytes()},
+			{PrevTxHash: util.RandomHash(), PublicKey: privKeyB.Public().Bytes()},
+		},
+		Outputs: []*proto.TxOutput{
+			{Amount: 10, Address: privKeyA.Public().Address().Bytes()},
+		},
+	}
+
+	tx.Inputs[0].Signature = MustSignTransaction(privKeyA, tx).Bytes()
+	tx.Inputs[1].Signature = MustSignTransaction(privKeyB, tx).Bytes()
+
+	assert.NoError(t, ValidateTransaction(tx))
+	// Verifying must not strip the signatures
+	assert.NoError(t, ValidateTransaction(tx))
+
+	tx.Outputs[0].Amount = 1000
+	assert.Error(t, ValidateTransaction(tx))
+}
+
+func TestValidateTransactionMalformed(t *testing.T) {
+	privKey := crypto.MustGeneratePrivateKey()
+
+	assert.Error(t, ValidateTransaction(nil))
+
+	tx := &proto.Transaction{
+		Inputs: []*proto.TxInput{
+			{PublicKey: privKey.Public().Bytes(), Signature: []byte{1, 2, 3}},
+		},
+	}
+	assert.Error(t, ValidateTransaction(tx))
+
+	tx.Inputs[0] = &proto.TxInput{PublicKey: []byte{1}, Signature: make([]byte, 64)}
+	assert.Error(t, ValidateTransaction(tx))
+
+	tx.Inputs[0] = nil
+	assert.Error(t, ValidateTransaction(tx))
+}
+
+func FuzzVerifyTransaction(f *testing.F) {
+	privKey := crypto.MustGeneratePrivateKey()
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs: []*proto.TxInput{
+			{PrevTxHash: util.RandomHash(), PublicKey: privKey.Public().Bytes()},
+		},
+		Outputs: []*proto.TxOutput{
+			{Amount: 1, Address: privKey.Public().Address().Bytes()},
+		},
+	}
+	tx.Inputs[0].Signature = MustSignTransaction(privKey, tx).Bytes()
+
+	seed, err := pb.Marshal(tx)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(seed)
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tx := &proto.Transaction{}
+		if err := pb.Unmarshal(data, tx); err != nil {
+			return
+		}
+		VerifyTransaction(tx, testOutputs{}, testHeader)
+	})
+}
+
+func TestVerifyTransactionLockingScript(t *testing.T) {
+	var (
+		privKey  = crypto.MustGeneratePrivateKey()
+		prevHash = util.RandomHash()
+		outputs  = testOutputs{}
+	)
+
+	// Spendable by the key from height 20 on
+	locking, err := script.NewBuilder().
+		AddInt(20).AddOp(script.OpCheckLockHeight).AddOp(script.OpDrop).
+		AddData(privKey.Public().Bytes()).AddOp(script.OpCheckSig).
+		Script()
+	assert.NoError(t, err)
+
+	outputs.add(prevHash, 0, &proto.TxOutput{Amount: 10, LockingScript: locking})
+
+	tx := &proto.Transaction{
+		Version: 1,
+		Inputs:  []*proto.TxInput{{PrevTxHash: prevHash}},
+		Outputs: []*proto.TxOutput{{Amount: 10, Address: privKey.Public().Address().Bytes()}},
+	}
+
+	unlocking, err := script.NewBuilder().AddData(MustSignTransaction(privKey, tx).Bytes()).Script()
+	assert.NoError(t, err)
+	tx.Inputs[0].UnlockingScript = unlocking
+
+	assert.NoError(t, ValidateTransaction(tx))
+	assert.NoError(t, VerifyTransaction(tx, outputs, &proto.Header{Height: 20}))
+	assert.Error(t, VerifyTransaction(tx, outputs, &proto.Header{Height: 19}))
+
+	// An unlocking script can't spend an output locked to an address
+	outputs.add(prevHash, 0, &proto.TxOutput{Amount: 10, Address: privKey.Public().Address().Bytes()})
+	assert.Error(t, VerifyTransaction(tx, outputs, &proto.Header{Height: 20}))
+
+	// Outputs are locked in exactly one way
+	assert.Error(t, ValidateTransaction(&proto.Transaction{
+		Outputs: []*proto.TxOutput{{Amount: 10, Address: privKey.Public().Address().Bytes(), LockingScript: locking}},
+	}))
 }
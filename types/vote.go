@@ -0,0 +1,71 @@
+package types
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	pb "github.com/golang/protobuf/proto"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+)
+
+// SignVote hashes and then signs a vote
+func SignVote(pk *crypto.PrivateKey, v *proto.Vote) (*crypto.Signature, error) {
+	hash, err := HashVote(v)
+	if err != nil {
+		return nil, err
+	}
+	return pk.Sign(hash), nil
+}
+
+// MustSignVote hashes and then signs a vote or panics if failing to hash
+func MustSignVote(pk *crypto.PrivateKey, v *proto.Vote) *crypto.Signature {
+	sig, err := SignVote(pk, v)
+	if err != nil {
+		panic(err)
+	}
+	return sig
+}
+
+// HashVote returns a SHA256 of the vote without its signature
+func HashVote(v *proto.Vote) ([]byte, error) {
+	if v == nil {
+		return nil, fmt.Errorf("vote is nil")
+	}
+
+	unsigned := pb.Clone(v).(*proto.Vote)
+	unsigned.Signature = nil
+
+	b, err := pb.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(b)
+
+	return hash[:], nil
+}
+
+// VerifyVote checks that the vote is signed by its public key
+func VerifyVote(v *proto.Vote) error {
+	hash, err := HashVote(v)
+	if err != nil {
+		return err
+	}
+
+	pubKey, err := crypto.PublicKeyFromBytes(v.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	sig, err := crypto.SignatureFromBytes(v.Signature)
+	if err != nil {
+		return err
+	}
+
+	if !sig.Verify(pubKey, hash) {
+		return fmt.Errorf("invalid vote signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+	"github.com/webstradev/blockstra/proto"
+	"github.com/webstradev/blockstra/util"
+)
+
+func TestSignVote(t *testing.T) {
+	privKey := crypto.MustGeneratePrivateKey()
+	vote := &proto.Vote{
+		Type:      proto.VoteType_PRECOMMIT,
+		Height:    1,
+		BlockHash: util.RandomHash(),
+		PublicKey: privKey.Public().Bytes(),
+	}
+
+	assert.Error(t, VerifyVote(vote))
+
+	vote.Signature = MustSignVote(privKey, vote).Bytes()
+	assert.NoError(t, VerifyVote(vote))
+
+	// The signature covers every field of the vote
+	vote.Round = 1
+	assert.Error(t, VerifyVote(vote))
+	vote.Round = 0
+
+	vote.PublicKey = crypto.MustGeneratePrivateKey().Public().Bytes()
+	assert.Error(t, VerifyVote(vote))
+
+	assert.Error(t, VerifyVote(nil))
+}
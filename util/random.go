@@ -17,12 +17,12 @@ func RandomHash() []byte {
 	return hash
 }
 
+// RandomBlock returns a block without transactions at a random height
 func RandomBlock() *proto.Block {
 	header := &proto.Header{
 		Version:   "1",
 		Height:    int32(rand.Intn(1000) + 1),
 		PrevHash:  RandomHash(),
-		RootHash:  RandomHash(),
 		Timestamp: time.Now().UnixNano(),
 	}
 
@@ -0,0 +1,126 @@
+package wallet
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/webstradev/blockstra/crypto"
+)
+
+const (
+	// CoinType is the SLIP-0044 style coin type used in the derivation path
+	CoinType = 1337
+	// DefaultGapLimit is the number of consecutive unused addresses after
+	// which scanning stops
+	DefaultGapLimit = 20
+)
+
+// ActivityChecker reports whether an address has ever been used on chain.
+type ActivityChecker interface {
+	HasActivity(crypto.Address) (bool, error)
+}
+
+// Wallet derives all of its keys from a single mnemonic using the path
+// m/44'/CoinType'/account'/index'.
+type Wallet struct {
+	lock    sync.RWMutex
+	master  *crypto.ExtendedKey
+	account uint32
+	// next is the index of the first address that has not been used yet
+	next uint32
+}
+
+func New(mnemonic, passphrase string, account uint32) (*Wallet, error) {
+	seed, err := crypto.SeedFromMnemonic(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	master, err := crypto.NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Wallet{
+		master:  master,
+		account: account,
+	}, nil
+}
+
+func DerivationPath(account, index uint32) string {
+	return fmt.Sprintf("m/44'/%d'/%d'/%d'", CoinType, account, index)
+}
+
+// Key returns the private key at the given address index
+func (w *Wallet) Key(index uint32) *crypto.PrivateKey {
+	return w.master.
+		Child(44).
+		Child(CoinType).
+		Child(w.account).
+		Child(index).
+		PrivateKey()
+}
+
+func (w *Wallet) Address(index uint32) crypto.Address {
+	return w.Key(index).Public().Address()
+}
+
+// NextIndex returns the index of the first address not known to be used
+func (w *Wallet) NextIndex() uint32 {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.next
+}
+
+// NextKey returns the first unused key and marks it as used, so the following
+// call hands out a fresh one.
+func (w *Wallet) NextKey() *crypto.PrivateKey {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	key := w.Key(w.next)
+	w.next++
+
+	return key
+}
+
+// Scan derives addresses in order until gapLimit consecutive addresses without
+// activity are found, the way a restored wallet discovers its funds. It
+// returns the keys of all used addresses and moves the next index past them.
+func (w *Wallet) Scan(checker ActivityChecker, gapLimit int) ([]*crypto.PrivateKey, error) {
+	if gapLimit <= 0 {
+		gapLimit = DefaultGapLimit
+	}
+
+	var (
+		used = []*crypto.PrivateKey{}
+		next uint32
+		gap  int
+	)
+
+	for index := uint32(0); gap < gapLimit; index++ {
+		key := w.Key(index)
+
+		active, err := checker.HasActivity(key.Public().Address())
+		if err != nil {
+			return nil, err
+		}
+
+		if !active {
+			gap++
+			continue
+		}
+
+		used = append(used, key)
+		next = index + 1
+		gap = 0
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if next > w.next {
+		w.next = next
+	}
+
+	return used, nil
+}
@@ -0,0 +1,72 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webstradev/blockstra/crypto"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+type addressSet map[string]bool
+
+func (s addressSet) HasActivity(a crypto.Address) (bool, error) {
+	return s[a.String()], nil
+}
+
+func TestWalletDeterministic(t *testing.T) {
+	w1, err := New(testMnemonic, "", 0)
+	assert.NoError(t, err)
+	w2, err := New(testMnemonic, "", 0)
+	assert.NoError(t, err)
+
+	assert.Equal(t, w1.Key(3).Bytes(), w2.Key(3).Bytes())
+	assert.NotEqual(t, w1.Key(3).Bytes(), w1.Key(4).Bytes())
+
+	// A different passphrase results in a different wallet
+	w3, err := New(testMnemonic, "secret", 0)
+	assert.NoError(t, err)
+	assert.NotEqual(t, w1.Key(0).Bytes(), w3.Key(0).Bytes())
+
+	_, err = New("not a mnemonic", "", 0)
+	assert.Error(t, err)
+}
+
+func TestWalletKeyMatchesPath(t *testing.T) {
+	w, err := New(testMnemonic, "", 1)
+	assert.NoError(t, err)
+
+	seed, _ := crypto.SeedFromMnemonic(testMnemonic, "")
+	master, _ := crypto.NewMasterKey(seed)
+	key, err := master.Derive(DerivationPath(1, 7))
+	assert.NoError(t, err)
+
+	assert.Equal(t, key.PrivateKey().Bytes(), w.Key(7).Bytes())
+}
+
+func TestWalletScan(t *testing.T) {
+	w, err := New(testMnemonic, "", 0)
+	assert.NoError(t, err)
+
+	// Addresses 0, 2 and 6 have been used, with a gap limit of 3 the last one
+	// should not be discovered
+	used := addressSet{
+		w.Address(0).String(): true,
+		w.Address(2).String(): true,
+		w.Address(6).String(): true,
+	}
+
+	keys, err := w.Scan(used, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(keys))
+	assert.Equal(t, uint32(3), w.NextIndex())
+
+	keys, err = w.Scan(used, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(keys))
+	assert.Equal(t, uint32(7), w.NextIndex())
+
+	assert.Equal(t, w.Key(7).Bytes(), w.NextKey().Bytes())
+	assert.Equal(t, uint32(8), w.NextIndex())
+}